@@ -17,7 +17,9 @@ package burnin
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -34,19 +36,23 @@ var ErrPipelineNotFound = errors.New("no matching pipeline found")
 type NodesPerNetworkMap map[string]map[NodeType]int
 
 type Request struct {
-	PullRequest     string             `toml:"pull_request"`             // e.g. https://github.com/paritytech/polkadot/pull/2013
-	CommitSHA       string             `toml:"commit_sha"`               // optional, only considered if 'custom_binary' is not provided
-	CustomBinary    *string            `toml:"custom_binary,omitempty"`  // optional URL to the polkadot binary, usually on gitlab.example.com
-	CustomOptions   []string           `toml:"custom_options,omitempty"` // optional custom CLI flags to pass to Ansible
-	RequestedBy     string             `toml:"requested_by"`             // github/matrix handle or email address
-	SyncFromScratch bool               `toml:"sync_from_scratch"`        // if true, chain db will be deleted before updating the binary
-	Nodes           NodesPerNetworkMap `toml:"nodes"`                    // e.g. m["kusama"][FullNode] = 2, m["polkadot"][Validator] = 1
+	PullRequest     string             `toml:"pull_request"`              // e.g. https://github.com/paritytech/polkadot/pull/2013
+	CommitSHA       string             `toml:"commit_sha"`                // optional, only considered if 'custom_binary' is not provided
+	CustomBinary    *string            `toml:"custom_binary,omitempty"`   // optional URL to the polkadot binary, usually on gitlab.example.com
+	BinaryChecksum  string             `toml:"binary_checksum,omitempty"` // optional hex encoded SHA-256 of 'custom_binary'
+	CustomOptions   []string           `toml:"custom_options,omitempty"`  // optional custom CLI flags to pass to Ansible
+	RequestedBy     string             `toml:"requested_by"`              // github/matrix handle or email address
+	SyncFromScratch bool               `toml:"sync_from_scratch"`         // if true, chain db will be deleted before updating the binary
+	Nodes           NodesPerNetworkMap `toml:"nodes"`                     // e.g. m["kusama"][FullNode] = 2, m["polkadot"][Validator] = 1
+	// optional additional variables for the playbooks, e.g. extra_vars.node_pruning = 1000
+	ExtraVars map[string]interface{} `toml:"extra_vars,omitempty"`
 }
 
 type Deployment struct {
 	PullRequest     string            `toml:"pull_request"`
 	CommitSHA       string            `toml:"commit_sha"`
 	CustomBinary    string            `toml:"custom_binary"`
+	BinaryChecksum  string            `toml:"binary_checksum,omitempty"` // SHA-256 of the custom binary, if known
 	CustomOptions   []string          `toml:"custom_options,omitempty"`
 	RequestedBy     string            `toml:"requested_by"`
 	SyncFromScratch bool              `toml:"sync_from_scratch"`
@@ -59,36 +65,147 @@ type Deployment struct {
 	InternalFQDN    string            `toml:"internal_fqdn,omitempty"`
 	LogViewer       string            `toml:"log_viewer,omitempty"`
 	Dashboards      map[string]string `toml:"dashboards,omitempty"`
+	MatrixThread    string            `toml:"matrix_thread,omitempty"` // event ID of the request notification
+	Health          *HealthCheck      `toml:"health,omitempty"`        // of the last deployment or update, if checked
+
+	ExtraVars map[string]interface{} `toml:"extra_vars,omitempty"` // from the request
+
+	// What was deployed before the last update. A failed update is rolled back to it.
+	PreviousBinary         string                 `toml:"previous_binary,omitempty"`
+	PreviousBinaryChecksum string                 `toml:"previous_binary_checksum,omitempty"`
+	PreviousCommitSHA      string                 `toml:"previous_commit_sha,omitempty"`
+	PreviousCustomOptions  []string               `toml:"previous_custom_options,omitempty"`
+	PreviousExtraVars      map[string]interface{} `toml:"previous_extra_vars,omitempty"`
+
+	// Set when the last update was rolled back: the binary of the update and why it was rolled back.
+	RolledBackFrom string `toml:"rolled_back_from,omitempty"`
+	RollbackReason string `toml:"rollback_reason,omitempty"`
+
+	// History of the deployment and its updates and rollbacks, oldest first. Only the most recent entries are kept.
+	History []HistoryEntry `toml:"history,omitempty"`
 
 	Filename string `toml:"-"` // name of the "run" file
 }
 
-type Gitlab interface {
+// HistoryEntry records what was deployed on a host by a deploy, update or rollback.
+type HistoryEntry struct {
+	At             time.Time `toml:"at"`
+	Action         string    `toml:"action"` // "deploy", "update" or "rollback"
+	Host           string    `toml:"host"`
+	Binary         string    `toml:"binary"`
+	CommitSHA      string    `toml:"commit_sha,omitempty"`
+	BinaryChecksum string    `toml:"binary_checksum,omitempty"`
+	CustomOptions  []string  `toml:"custom_options,omitempty"`
+	JobURL         string    `toml:"job_url,omitempty"` // of the CI job
+	Outcome        string    `toml:"outcome"`           // "succeeded", "unhealthy", "rolled back" or "failed"
+	Details        string    `toml:"details,omitempty"` // e.g. the problems of an unhealthy node
+}
+
+// JobError is returned by jobs which fail while processing a deployment, so that notifiers can tell which burn-in
+// failed.
+type JobError struct {
+	Deployment Deployment
+	Err        error
+}
+
+func (e *JobError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// Digest summarizes the burn-ins which are currently running.
+type Digest struct {
+	CreatedAt time.Time `json:"created_at"`
+	BurnIns   []BurnIn  `json:"burn_ins"`
+	// OrphanedHosts have a paused runner, but no "run" file. They are neither running a burn-in nor available.
+	OrphanedHosts []string `json:"orphaned_hosts"`
+}
+
+// BurnIn is a burn-in request together with the deployments it resulted in.
+type BurnIn struct {
+	RequestID   string       `json:"request_id"`
+	PullRequest string       `json:"pull_request"`
+	RequestedBy string       `json:"requested_by"`
+	DeployedAt  time.Time    `json:"deployed_at"` // of the first deployment, zero if nothing has been deployed yet
+	UpdatedAt   time.Time    `json:"updated_at"`  // of the last update, zero if it has never been updated
+	Overdue     bool         `json:"overdue"`     // running for longer than it should
+	Deployments []Deployment `json:"deployments"`
+}
+
+// Age formats the time since t relative to the creation of the digest, e.g. "3d 4h". It is empty for the zero time.
+func (d Digest) Age(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	age := d.CreatedAt.Sub(t)
+	days := int(age.Hours()) / 24
+	hours := int(age.Hours()) % 24
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh", days, hours)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dm", int(age.Minutes()))
+}
+
+// Repo is the part of a code forge that hosts the "deployments" repository, i.e. the "request" and "run" files.
+type Repo interface {
 	GetLastCommitDiffs(branch string) ([]CommitDiff, error)
-	GetPipelinesForBranch(branch string) ([]Pipeline, error)
-	GetPipelineForCommit(sha string) (Pipeline, error)
-	GetPipeline(pipelineID int) (Pipeline, error)
-	GetPipelineJobs(pipelineID int) ([]Job, error)
-	GetJob(jobID int) (Job, error)
-	StartJob(jobID int) error
 	CreateBranch(name, fromBranch string) error
 	ListDirectory(path, branch string) ([]FileInfo, error)
+	GetFile(path, branch string) ([]byte, error)
 	CreateFile(path, branch, commitMsg string, content []byte) error
 	UpdateFile(path, branch, commitMsg string, content []byte) error
 	DeleteFile(path, branch, commitMsg string) error
 	CreateMergeRequest(title, sourceBranch, targetBranch string) (MergeRequest, error)
-	GetRunners() ([]Runner, error)
-	GetRunnerTags(id int) ([]string, error)
-	PauseRunner(hostname string) error
-	UnPauseRunner(hostname string) error
 	WebURLForBranch(branch string) (*url.URL, error)
-	WebURLForJob(id int) (*url.URL, error)
 	PrefixSkipCI(string) string
 	PrefixDeploy(network string, n NodeType, msg string) string
 	PrefixUpdateDeployment(msg string) string
 	PrefixCleanup(msg string) string
 }
 
+// CI is the part of a code forge that runs pipelines, e.g. the ones building the Polkadot binary.
+type CI interface {
+	GetPipelinesForBranch(branch string) ([]Pipeline, error)
+	GetPipelineForCommit(sha string) (Pipeline, error)
+	GetPipeline(pipelineID int) (Pipeline, error)
+	GetPipelineJobs(pipelineID int) ([]Job, error)
+	GetJob(jobID int) (Job, error)
+	StartJob(jobID int) error
+	WebURLForJob(id int) (*url.URL, error)
+}
+
+// RunnerPool manages the CI runners installed on the burn-in hosts. Pausing a runner keeps other CI jobs off a host
+// while a burn-in test is running on it.
+type RunnerPool interface {
+	GetRunners() ([]Runner, error)
+	GetRunnerTags(id int) ([]string, error)
+	PauseRunner(hostname string) error
+	UnPauseRunner(hostname string) error
+}
+
+// Gitlab is everything the jobs need from a code forge. The name stems from the time when GitLab was the only
+// supported forge. Use Forge to combine implementations of the different parts from different forges.
+type Gitlab interface {
+	Repo
+	CI
+	RunnerPool
+}
+
+// Forge implements Gitlab by delegating to separate implementations of its parts, e.g. a Gitea repository and CI
+// combined with the runners registered in GitLab.
+type Forge struct {
+	Repo
+	CI
+	RunnerPool
+}
+
 type Pipeline struct {
 	ID        int    `json:"id"`
 	Status    string `json:"status"`
@@ -147,20 +264,237 @@ type AlertMatcher struct {
 	IsRegex bool   `json:"isRegex"`
 }
 
+// Silence as returned by GET /api/v2/silences. Status is one of "pending", "active" or "expired".
+type Silence struct {
+	ID        string         `json:"id"`
+	Matchers  []AlertMatcher `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	Status    struct {
+		State string `json:"state"`
+	} `json:"status"`
+}
+
+// Alert as returned by GET /api/v2/alerts.
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+}
+
+// FiringAlertsError is returned by jobs if alerts are firing for a host after a playbook ran on it.
+type FiringAlertsError struct {
+	Hostname string
+	Alerts   []Alert
+}
+
+func (e *FiringAlertsError) Error() string {
+	names := make([]string, len(e.Alerts))
+	for i, a := range e.Alerts {
+		names[i] = a.Labels["alertname"]
+	}
+
+	return fmt.Sprintf("%d alert(s) firing on %s: %s", len(e.Alerts), e.Hostname, strings.Join(names, ", "))
+}
+
 type Alertmanager interface {
 	CreateSilence(matchers []AlertMatcher, startsAt, endsAt time.Time, createdBy, comment string) (string, error)
+	// GetSilences returns all silences which have not expired yet.
+	GetSilences() ([]Silence, error)
+	// UpdateSilence changes an existing silence and returns its ID, which might differ from the ID of the old one.
+	UpdateSilence(silence Silence) (string, error)
 	DeleteSilence(id string) error
+	// GetAlerts returns the active alerts matching all matchers, including silenced ones.
+	GetAlerts(matchers []AlertMatcher) ([]Alert, error)
 }
 
-type AnsibleDriver interface {
-	RunPlaybook(
-		name string,
-		runOn string,
-		nodeBinary *url.URL,
-		wipeChainDB bool,
-		nodePublicName string,
-		customOptions []string,
-	) error
+// Alertmanagers returns the Alertmanager responsible for the nodes of a network.
+type Alertmanagers interface {
+	ForNetwork(network string) Alertmanager
+}
+
+// ErrNoHostsMatched is returned by DeploymentDriver if the playbook did not run on any host.
+var ErrNoHostsMatched = errors.New("no hosts matched")
+
+// PlaybookResult is what a playbook run did on each host.
+type PlaybookResult struct {
+	Tasks []TaskResult         `json:"tasks"`
+	Stats map[string]HostStats `json:"stats"` // by host
+}
+
+// Failed reports whether the recap of any host counts failed tasks or found the host unreachable. Failures which
+// were ignored or rescued do not count.
+func (r PlaybookResult) Failed() bool {
+	for _, s := range r.Stats {
+		if s.Failures > 0 || s.Unreachable > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Failures returns the tasks which failed, unless they were ignored or rescued, or found their host unreachable.
+func (r PlaybookResult) Failures() []TaskResult {
+	var failures []TaskResult
+	for _, t := range r.Tasks {
+		if (t.Failed && !t.Ignored && !t.Rescued) || t.Unreachable {
+			failures = append(failures, t)
+		}
+	}
+	return failures
+}
+
+// TaskResult is the outcome of a task on one host.
+type TaskResult struct {
+	Task        string        `json:"task"`
+	Host        string        `json:"host"`
+	Changed     bool          `json:"changed"`
+	Failed      bool          `json:"failed"`
+	Unreachable bool          `json:"unreachable"`
+	Skipped     bool          `json:"skipped"`
+	Ignored     bool          `json:"ignored"`       // failed, but the task has "ignore_errors"
+	Rescued     bool          `json:"rescued"`       // failed, but a "rescue" section of the block handled it
+	Msg         string        `json:"msg,omitempty"` // failure message
+	Duration    time.Duration `json:"duration"`
+}
+
+// HostStats are the totals of the "PLAY RECAP" for one host.
+type HostStats struct {
+	OK          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Failures    int `json:"failures"`
+	Unreachable int `json:"unreachable"`
+	Skipped     int `json:"skipped"`
+	Rescued     int `json:"rescued"`
+	Ignored     int `json:"ignored"`
+}
+
+// PlaybookError is returned by DeploymentDriver if a task of the playbook failed.
+type PlaybookError struct {
+	Playbook string
+	Result   PlaybookResult
+	Err      error // e.g. the exit status of ansible-playbook
+}
+
+// Error describes the first failure, e.g. "task 'Restart node' failed on kusama-fullnode-uw1-0: ...".
+func (e *PlaybookError) Error() string {
+	failures := e.Result.Failures()
+	if len(failures) == 0 {
+		return fmt.Sprintf("playbook %s failed: %v", e.Playbook, e.Err)
+	}
+
+	first := failures[0]
+	msg := fmt.Sprintf("task '%s' failed on %s: %s", first.Task, first.Host, first.Msg)
+	if first.Unreachable {
+		msg = fmt.Sprintf("%s was unreachable in task '%s': %s", first.Host, first.Task, first.Msg)
+	}
+	if len(failures) > 1 {
+		msg += fmt.Sprintf(" (and %d more failure(s))", len(failures)-1)
+	}
+	return msg
+}
+
+func (e *PlaybookError) Unwrap() error {
+	return e.Err
+}
+
+// PlaybookTimeoutError is returned by DeploymentDriver if the playbook did not finish in time. All its processes were
+// stopped, which may leave the host half-deployed.
+type PlaybookTimeoutError struct {
+	Playbook string
+	Timeout  time.Duration
+	Err      error // e.g. the exit status of the killed ansible-playbook
+}
+
+func (e *PlaybookTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *PlaybookTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// PlaybookRun describes what a DeploymentDriver should do with a node.
+type PlaybookRun struct {
+	Playbook       string   // e.g. "kusama-nodes.yml"
+	Network        string   // e.g. "kusama", chooses the driver in DriverRouter
+	RunOn          string   // "localhost" or the FQDN of the host
+	NodePublicName string   // e.g. "kusama-fullnode-uw1-0"
+	NodeBinary     *url.URL // optional, e.g. for cleanups
+	BinaryChecksum string   // optional hex encoded SHA-256 of NodeBinary
+	WipeChainDB    bool
+	CustomOptions  []string
+	// ExtraVars are passed to the playbook next to the variables of the fields above, which they must not replace.
+	ExtraVars map[string]interface{}
+}
+
+// DeploymentDriver deploys, updates and cleans up nodes, e.g. by running Ansible playbooks.
+type DeploymentDriver interface {
+	RunPlaybook(run PlaybookRun) error
+}
+
+// DriverRouter implements DeploymentDriver by passing the runs of each network to its own driver, e.g. to deploy
+// some networks without Ansible. Networks without their own driver use Default.
+type DriverRouter struct {
+	Default  DeploymentDriver
+	Networks map[string]DeploymentDriver
+}
+
+func (r DriverRouter) RunPlaybook(run PlaybookRun) error {
+	if d, ok := r.Networks[run.Network]; ok {
+		return d.RunPlaybook(run)
+	}
+	return r.Default.RunPlaybook(run)
+}
+
+// RedactURL returns u without user info and query for logs and errors, since both may carry credentials, e.g. a
+// password or "?private_token=". A nil URL gives "".
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.ForceQuery = false
+	return redacted.String()
+}
+
+// HealthCheck is the outcome of watching a node for a while after a deployment or update.
+type HealthCheck struct {
+	CheckedAt  time.Time `toml:"checked_at"`
+	Healthy    bool      `toml:"healthy"`
+	Version    string    `toml:"version,omitempty"` // e.g. "0.9.30-a7810560c0f"
+	Peers      int       `toml:"peers"`             // at the end of the check
+	FirstBlock int64     `toml:"first_block"`       // best block at the start of the check
+	LastBlock  int64     `toml:"last_block"`        // best block at the end of the check
+	Problems   []string  `toml:"problems,omitempty"`
+}
+
+// Summary describes the outcome in one line, e.g. for notifications.
+func (h HealthCheck) Summary() string {
+	status := "healthy"
+	if !h.Healthy {
+		status = fmt.Sprintf("unhealthy (%s)", strings.Join(h.Problems, "; "))
+	}
+
+	version := h.Version
+	if version == "" {
+		version = "unknown"
+	}
+	return fmt.Sprintf(
+		"%s: version %s, %d peers, best block #%d to #%d",
+		status, version, h.Peers, h.FirstBlock, h.LastBlock,
+	)
+}
+
+// HealthChecker watches a node after a playbook run. host is where its RPC and metrics endpoints are reachable,
+// commitSHA the commit the running version should have been built from, if known.
+type HealthChecker interface {
+	CheckHealth(host, commitSHA string) HealthCheck
 }
 
 // Poller only exists to avoid time.Sleep() calls in tests.
@@ -173,10 +507,16 @@ type Poller interface {
 	) error
 }
 
-type Matrix interface {
-	SendRequestNotification(request Request) error
+// Notifier tells people about the progress of burn-ins, e.g. in a Matrix room.
+type Notifier interface {
+	// SendRequestNotification returns an ID of the notification (e.g. a Matrix event ID), which later notifications
+	// about the same burn-in refer to via Deployment.MatrixThread. It is empty if there is nothing to refer to.
+	SendRequestNotification(request Request) (string, error)
 	SendDeploymentNotification(deployment Deployment) error
 	SendUpdateNotification(deployment Deployment) error
 	SendCleanupNotification(deployment Deployment) error
+	// SendRollbackNotification tells that an update has been rolled back, see Deployment.RollbackReason.
+	SendRollbackNotification(deployment Deployment) error
 	SendErrorNotification(err error) error
+	SendDigestNotification(digest Digest) error
 }
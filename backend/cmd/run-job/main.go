@@ -16,61 +16,204 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/pelletier/go-toml"
 	burnin "gitlab.example.com/burn-in-tests/backend"
 	"gitlab.example.com/burn-in-tests/backend/internal/alertmanager"
 	"gitlab.example.com/burn-in-tests/backend/internal/ansible"
+	"gitlab.example.com/burn-in-tests/backend/internal/dryrun"
+	"gitlab.example.com/burn-in-tests/backend/internal/email"
+	"gitlab.example.com/burn-in-tests/backend/internal/gitea"
 	"gitlab.example.com/burn-in-tests/backend/internal/gitlab"
+	"gitlab.example.com/burn-in-tests/backend/internal/health"
 	"gitlab.example.com/burn-in-tests/backend/internal/job"
+	"gitlab.example.com/burn-in-tests/backend/internal/localnode"
 	"gitlab.example.com/burn-in-tests/backend/internal/matrix"
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+	"gitlab.example.com/burn-in-tests/backend/internal/slack"
+	"gitlab.example.com/burn-in-tests/backend/internal/systemd"
+	"gitlab.example.com/burn-in-tests/backend/internal/webhook"
 )
 
 type config struct {
 	GitlabServerURL         *url.URL `env:"CI_SERVER_URL"`
 	GitlabProjectID         int      `env:"CI_PROJECT_ID"`
-	GitlabToken             string   `env:"GITLAB_TOKEN"`
+	GitlabToken             string   `env:"GITLAB_TOKEN"` // fallback for every token below
 	GitlabDefaultBranch     string   `env:"CI_COMMIT_BRANCH"`
 	GitlabJobID             int      `env:"CI_JOB_ID"`
 	PolkadotGitlabProjectID int      `env:"POLKADOT_GITLAB_PROJECT_ID" envDefault:"42"`
 
-	AlertmanagerAPIURL *url.URL `env:"ALERTMANAGER_API_URL" envDefault:"http://alertmanager.example.com/api/v2"`
-	BaseDirectory      string   `env:"-"`
-	TargetHostname     string   `env:"-"`
+	// Tokens with fewer privileges than GITLAB_TOKEN, each one is only used for what it is needed for.
+	GitlabTokenType        string `env:"GITLAB_TOKEN_TYPE" envDefault:"private"` // "private" or "oauth", not for CI_JOB_TOKEN
+	GitlabRepoToken        string `env:"GITLAB_REPO_TOKEN"`                      // read and write the "deployments" repository
+	GitlabRunnerToken      string `env:"GITLAB_RUNNER_TOKEN"`                    // administrator, for pausing runners
+	PolkadotGitlabToken    string `env:"POLKADOT_GITLAB_TOKEN"`                  // read pipelines and play jobs in the build project
+	GitlabJobToken         string `env:"CI_JOB_TOKEN"`
+	GitlabReadWithJobToken bool   `env:"GITLAB_READ_WITH_JOB_TOKEN"` // use CI_JOB_TOKEN to read pipelines and jobs
+
+	// The "deployments" repository and its CI can be hosted on Gitea/Forgejo instead of GitLab. Runners are always
+	// managed through GitLab.
+	DeploymentsForge string   `env:"DEPLOYMENTS_FORGE" envDefault:"gitlab"`
+	GiteaServerURL   *url.URL `env:"GITEA_SERVER_URL"`
+	GiteaRepository  string   `env:"GITEA_REPOSITORY"` // e.g. "burn-in-tests/deployments"
+	GiteaToken       string   `env:"GITEA_TOKEN"`
+
+	// Comma separated API URLs of the peers of the Alertmanager cluster, used for networks without their own cluster.
+	AlertmanagerAPIURLs []*url.URL `env:"ALERTMANAGER_API_URL" envDefault:"http://alertmanager.example.com/api/v2"`
+	// Clusters of networks with their own Alertmanager, e.g. "kusama=https://a/api/v2,https://b/api/v2;westend=...".
+	AlertmanagerNetworkAPIURLs []string `env:"ALERTMANAGER_NETWORK_API_URLS" envSeparator:";"`
+	AlertmanagerUsername       string   `env:"ALERTMANAGER_USERNAME"`
+	AlertmanagerPassword       string   `env:"ALERTMANAGER_PASSWORD"`
+	AlertmanagerBearerToken    string   `env:"ALERTMANAGER_BEARER_TOKEN"`
+	AlertmanagerClientCert     string   `env:"ALERTMANAGER_CLIENT_CERT"` // path to a PEM file, enables mTLS
+	AlertmanagerClientKey      string   `env:"ALERTMANAGER_CLIENT_KEY"`
+	AlertmanagerCACert         string   `env:"ALERTMANAGER_CA_CERT"`
+
+	SilenceDuration time.Duration `env:"SILENCE_DURATION" envDefault:"1h"` // upper bound, expired after the playbook
+	SilenceGrace    time.Duration `env:"SILENCE_GRACE" envDefault:"0s"`    // keep silences after the playbook
+
+	// Alerts firing after a deployment or update fail the job. By default all critical alerts of the host count.
+	AlertGateDisabled bool          `env:"ALERT_GATE_DISABLED"`
+	AlertGateNames    []string      `env:"ALERT_GATE_NAMES"`                                 // e.g. "NodeDown,BlockProductionStalled"
+	AlertGateLabels   []string      `env:"ALERT_GATE_LABELS" envDefault:"severity=critical"` // e.g. "severity=critical,team=node"
+	AlertGateDelay    time.Duration `env:"ALERT_GATE_DELAY" envDefault:"2m"`
+
+	DigestMaxAge time.Duration `env:"DIGEST_MAX_AGE" envDefault:"168h"` // burn-ins running longer are flagged
+
+	// Nodes are watched for this long after deployments and updates, zero to skip the check.
+	HealthCheckWindow   time.Duration `env:"HEALTH_CHECK_WINDOW" envDefault:"5m"`
+	HealthCheckInterval time.Duration `env:"HEALTH_CHECK_INTERVAL" envDefault:"15s"`
+	HealthMinPeers      int           `env:"HEALTH_MIN_PEERS" envDefault:"3"`
+	HealthRPCPort       int           `env:"HEALTH_RPC_PORT" envDefault:"9933"`
+	HealthMetricsPort   int           `env:"HEALTH_METRICS_PORT" envDefault:"9615"`
+
+	// Entries kept in the history of a "run" file, see "run-job history".
+	HistoryLimit int `env:"HISTORY_LIMIT" envDefault:"50"`
+
+	// Comma separated names of the variables requests may set in their "extra_vars" table, e.g. "node_pruning".
+	AllowedExtraVars []string `env:"ALLOWED_EXTRA_VARS"`
+
+	// "ansible" runs the playbooks in .maintain/ansible, "systemd" deploys the binary over SSH without Ansible and
+	// "local" runs the nodes as processes on this machine, e.g. for tests.
+	DeploymentDriver string `env:"DEPLOYMENT_DRIVER" envDefault:"ansible"`
+	// Networks using another driver than DEPLOYMENT_DRIVER, e.g. "westend=systemd,kusama=ansible".
+	DeploymentDrivers []string `env:"DEPLOYMENT_DRIVERS"`
+
+	// Settings of the "systemd" driver. Empty values use the defaults of package systemd.
+	SystemdSSHUser    string   `env:"SYSTEMD_SSH_USER"`
+	SystemdSSHArgs    []string `env:"SYSTEMD_SSH_ARGS"` // e.g. "-i,/path/to/key"
+	SystemdSudo       string   `env:"SYSTEMD_SUDO"`     // "-" if the SSH user is root
+	SystemdUnit       string   `env:"SYSTEMD_UNIT"`
+	SystemdBinaryDir  string   `env:"SYSTEMD_BINARY_DIR"`
+	SystemdBinaryLink string   `env:"SYSTEMD_BINARY_LINK"`
+	SystemdChainDB    string   `env:"SYSTEMD_CHAIN_DB"`
+
+	// Settings of the "local" driver.
+	LocalNodesDir   string `env:"LOCAL_NODES_DIR" envDefault:"local-nodes"`
+	LocalNodeBinary string `env:"LOCAL_NODE_BINARY"` // runs this file instead of downloading the node binary
+
+	// Directory for the full output of every playbook run, kept as CI artifact. Empty to only log the output.
+	AnsibleLogDir string `env:"ANSIBLE_LOG_DIR" envDefault:"ansible-logs"`
+	// Semicolon separated regular expressions for warnings of ansible-playbook. A warning fails the playbook run if it
+	// matches a denied pattern or no allowed one. Unset to use the defaults of package ansible.
+	AnsibleWarningsAllow []string `env:"ANSIBLE_WARNINGS_ALLOW" envSeparator:";"`
+	AnsibleWarningsDeny  []string `env:"ANSIBLE_WARNINGS_DENY" envSeparator:";"`
+	// Playbooks running longer are killed, e.g. "30m". Zero for no limit.
+	AnsibleTimeout time.Duration `env:"ANSIBLE_TIMEOUT" envDefault:"30m"`
+	// Timeouts of single playbooks, e.g. "kusama-nodes.yml=45m,cleanup.yml=10m".
+	AnsiblePlaybookTimeouts []string `env:"ANSIBLE_PLAYBOOK_TIMEOUTS"`
+	// Semicolon separated regular expressions for the names of variables which are passed to ansible-playbook in a
+	// temporary file instead of on the command line and redacted in its output. Unset to use the defaults of package
+	// ansible.
+	AnsibleSensitiveVars []string `env:"ANSIBLE_SENSITIVE_VARS" envSeparator:";"`
+	// Password file for Ansible Vault, relative to the working directory of run-job. Not Ansible's own
+	// ANSIBLE_VAULT_PASSWORD_FILE, which would be resolved relative to the playbooks.
+	VaultPasswordFile string `env:"BURNIN_VAULT_PASSWORD_FILE"`
+
+	BaseDirectory  string `env:"-"`
+	TargetHostname string `env:"-"`
+
+	// Set by "--dry-run", which skips all side effects of the job and prints them as plan in text or JSON.
+	DryRunFormat string       `env:"-"`
+	DryRunPlan   *dryrun.Plan `env:"-"`
 
 	MatrixHomeserverURL *url.URL `env:"MATRIX_HOMESERVER_URL" envDefault:"https://matrix.example.com/"`
 	// default room is "Burn-in Monitoring"
 	MatrixRoomID      string `env:"MATRIX_ROOM_ID" envDefault:"!someroom:matrix.example.com"`
 	MatrixAccessToken string `env:"MATRIX_TOKEN"`
+	// TOML file mapping requested_by to Matrix user IDs, for mentions and direct messages on failures
+	MatrixIdentityMap string `env:"MATRIX_IDENTITY_MAP"`
+	// Directory with templates overriding the embedded ones, e.g. "deployment.html". See "run-job render-notification".
+	MatrixTemplateDir string `env:"MATRIX_TEMPLATE_DIR"`
+	// Link to the burn-in frontend in the notifications.
+	OverviewURL string `env:"BURNIN_OVERVIEW_URL" envDefault:"https://burnins.example.com/"`
+	// Matrix user IDs which may use the commands of "run-job matrix-bot", e.g. "@alice:matrix.example.com".
+	MatrixBotAllowedUsers []string `env:"MATRIX_BOT_ALLOWED_USERS"`
+	MatrixBotBranch       string   `env:"MATRIX_BOT_BRANCH" envDefault:"master"` // branch the bot commits to
+
+	// Comma separated list of "matrix", "slack", "webhook" and "email".
+	Notifiers       []string `env:"NOTIFIERS" envDefault:"matrix"`
+	SlackWebhookURL *url.URL `env:"SLACK_WEBHOOK_URL"`
+	WebhookURL      *url.URL `env:"WEBHOOK_URL"`
+	WebhookSecret   string   `env:"WEBHOOK_SECRET"` // signs the requests with HMAC-SHA256 if set
+	SMTPAddr        string   `env:"SMTP_ADDR"`      // e.g. "smtp.example.com:587"
+	SMTPUsername    string   `env:"SMTP_USERNAME"`
+	SMTPPassword    string   `env:"SMTP_PASSWORD"`
+	EmailFrom       string   `env:"EMAIL_FROM" envDefault:"burnin@example.com"`
+	EmailTo         []string `env:"EMAIL_TO"`
 }
 
 func main() {
+	dryRunFormat := extractDryRun()
 	if len(os.Args) < 2 {
 		usage()
 	}
 
 	cfg := parseConfig()
+	if dryRunFormat != "" {
+		switch os.Args[1] {
+		case "deploy", "update", "cleanup", "refresh":
+		default:
+			log.Fatalf("--dry-run is only supported by deploy, update, cleanup and refresh\n")
+		}
+		cfg.DryRunFormat = dryRunFormat
+		cfg.DryRunPlan = dryrun.NewPlan(os.Args[1])
+	}
 	ansiblePath := path.Join(cfg.BaseDirectory, ".maintain", "ansible")
 
 	var cmdErr error
-	var matrixClient burnin.Matrix
+	var notifier burnin.Notifier
 
 	switch os.Args[1] {
 	case "request":
-		matrixClient, cmdErr = cmdRequest(cfg)
+		notifier, cmdErr = cmdRequest(cfg)
 	case "deploy":
-		matrixClient, cmdErr = cmdDeploy(cfg, ansiblePath)
+		notifier, cmdErr = cmdDeploy(cfg, ansiblePath)
 	case "update":
-		matrixClient, cmdErr = cmdUpdate(cfg, ansiblePath)
+		notifier, cmdErr = cmdUpdate(cfg, ansiblePath)
 	case "cleanup":
-		matrixClient, cmdErr = cmdCleanup(cfg, ansiblePath)
+		notifier, cmdErr = cmdCleanup(cfg, ansiblePath)
 	case "refresh":
-		matrixClient, cmdErr = cmdRefresh(cfg, ansiblePath)
+		notifier, cmdErr = cmdRefresh(cfg, ansiblePath)
+	case "digest":
+		notifier, cmdErr = cmdDigest(cfg)
+	case "matrix-bot":
+		notifier, cmdErr = cmdMatrixBot(cfg)
+	case "render-notification":
+		notifier, cmdErr = cmdRenderNotification(cfg)
+	case "history":
+		notifier, cmdErr = cmdHistory(cfg)
 	default:
 		usage()
 	}
@@ -78,116 +221,594 @@ func main() {
 	if cmdErr != nil {
 		log.Printf("job '%s' failed: %v\n", os.Args[1], cmdErr)
 
-		if matrixClient != nil {
-			if err := matrixClient.SendErrorNotification(cmdErr); err != nil {
-				log.Fatalf("sending error notification to matrix failed: %v\n", err)
+		if notifier != nil {
+			if err := notifier.SendErrorNotification(cmdErr); err != nil {
+				log.Fatalf("sending error notification failed: %v\n", err)
 			}
 		}
+		printPlan(cfg)
 		os.Exit(1)
 	}
 
+	printPlan(cfg)
 	log.Println("done")
 }
 
-func cmdRequest(cfg config) (burnin.Matrix, error) {
-	burninGitlab := makeGitlabClient(cfg.GitlabServerURL, cfg.GitlabProjectID, cfg.GitlabToken)
-	buildGitlab := makeGitlabClient(cfg.GitlabServerURL, cfg.PolkadotGitlabProjectID, cfg.GitlabToken)
+// extractDryRun removes "--dry-run" or "--dry-run=<text|json>" from os.Args and returns the format of the plan, which
+// is empty if the flag is not set.
+func extractDryRun() string {
+	format := ""
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--dry-run", "--dry-run=text":
+			format = "text"
+		case "--dry-run=json":
+			format = "json"
+		default:
+			if strings.HasPrefix(arg, "--dry-run=") {
+				log.Fatalf("unsupported plan format in '%s' (must be 'text' or 'json')\n", arg)
+			}
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+	return format
+}
+
+// printPlan writes the side effects skipped by "--dry-run" to stdout.
+func printPlan(cfg config) {
+	if cfg.DryRunPlan == nil {
+		return
+	}
+
+	if cfg.DryRunFormat == "json" {
+		b, err := cfg.DryRunPlan.JSON()
+		if err != nil {
+			log.Fatalf("encoding plan failed: %v\n", err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Print(cfg.DryRunPlan.Text())
+}
+
+func cmdRequest(cfg config) (burnin.Notifier, error) {
+	burninGitlab := makeBurninForge(cfg)
+	buildGitlab := makeGitlabClient(cfg.GitlabServerURL, cfg.PolkadotGitlabProjectID, buildCredentials(cfg))
 
 	jobURL, err := burninGitlab.WebURLForJob(cfg.GitlabJobID)
 	if err != nil {
 		return nil, err
 	}
 
-	matrixClient := matrix.NewClient(cfg.MatrixHomeserverURL, cfg.MatrixRoomID, cfg.MatrixAccessToken, jobURL)
+	notifier := makeNotifier(cfg, jobURL)
 
-	return matrixClient, job.ProcessRequest(
+	return notifier, job.ProcessRequest(
 		cfg.BaseDirectory,
 		cfg.GitlabDefaultBranch,
 		burninGitlab,
 		buildGitlab,
 		job.Poller{},
-		matrixClient,
+		notifier,
+		jobOptions(cfg),
 	)
 }
 
-func cmdDeploy(cfg config, ansiblePath string) (burnin.Matrix, error) {
-	glClient := makeGitlabClient(cfg.GitlabServerURL, cfg.GitlabProjectID, cfg.GitlabToken)
+func cmdDeploy(cfg config, ansiblePath string) (burnin.Notifier, error) {
+	glClient := makeBurninForge(cfg)
 	jobURL, err := glClient.WebURLForJob(cfg.GitlabJobID)
 	if err != nil {
 		return nil, err
 	}
 
-	matrixClient := matrix.NewClient(cfg.MatrixHomeserverURL, cfg.MatrixRoomID, cfg.MatrixAccessToken, jobURL)
-	alertmgr := alertmanager.NewClient(cfg.AlertmanagerAPIURL)
-	ansibleDriver := ansible.NewDriver(ansiblePath)
+	notifier := makeNotifier(cfg, jobURL)
+	alertmgr := makeAlertmanagers(cfg)
+	driver := makeDriver(cfg, ansiblePath)
+	opts := jobOptions(cfg)
+	opts.JobURL = jobURL.String()
 
-	return matrixClient, job.ProcessDeploy(
+	return notifier, job.ProcessDeploy(
 		cfg.BaseDirectory,
 		cfg.GitlabDefaultBranch,
 		cfg.TargetHostname,
 		glClient,
 		alertmgr,
-		ansibleDriver,
-		matrixClient,
+		driver,
+		notifier,
+		opts,
 	)
 }
 
-func cmdUpdate(cfg config, ansiblePath string) (burnin.Matrix, error) {
-	glClient := makeGitlabClient(cfg.GitlabServerURL, cfg.GitlabProjectID, cfg.GitlabToken)
+func cmdUpdate(cfg config, ansiblePath string) (burnin.Notifier, error) {
+	glClient := makeBurninForge(cfg)
 	jobURL, err := glClient.WebURLForJob(cfg.GitlabJobID)
 	if err != nil {
 		return nil, err
 	}
 
-	matrixClient := matrix.NewClient(cfg.MatrixHomeserverURL, cfg.MatrixRoomID, cfg.MatrixAccessToken, jobURL)
-	alertmgr := alertmanager.NewClient(cfg.AlertmanagerAPIURL)
-	ansibleDriver := ansible.NewDriver(ansiblePath)
+	notifier := makeNotifier(cfg, jobURL)
+	alertmgr := makeAlertmanagers(cfg)
+	driver := makeDriver(cfg, ansiblePath)
+	opts := jobOptions(cfg)
+	opts.JobURL = jobURL.String()
 
-	return matrixClient, job.ProcessUpdate(
+	return notifier, job.ProcessUpdate(
 		cfg.BaseDirectory,
 		cfg.GitlabDefaultBranch,
 		glClient,
 		alertmgr,
-		ansibleDriver,
-		matrixClient,
+		driver,
+		notifier,
+		opts,
 	)
 }
 
-func cmdCleanup(cfg config, ansiblePath string) (burnin.Matrix, error) {
-	glClient := makeGitlabClient(cfg.GitlabServerURL, cfg.GitlabProjectID, cfg.GitlabToken)
+func cmdCleanup(cfg config, ansiblePath string) (burnin.Notifier, error) {
+	glClient := makeBurninForge(cfg)
 	jobURL, err := glClient.WebURLForJob(cfg.GitlabJobID)
 	if err != nil {
 		return nil, err
 	}
 
-	matrixClient := matrix.NewClient(cfg.MatrixHomeserverURL, cfg.MatrixRoomID, cfg.MatrixAccessToken, jobURL)
-	alertmgr := alertmanager.NewClient(cfg.AlertmanagerAPIURL)
-	ansibleDriver := ansible.NewDriver(ansiblePath)
+	notifier := makeNotifier(cfg, jobURL)
+	alertmgr := makeAlertmanagers(cfg)
+	driver := makeDriver(cfg, ansiblePath)
 
-	return matrixClient, job.ProcessCleanup(
+	return notifier, job.ProcessCleanup(
 		cfg.GitlabDefaultBranch,
 		glClient,
 		alertmgr,
-		ansibleDriver,
-		matrixClient,
+		driver,
+		notifier,
+		jobOptions(cfg),
 	)
 }
 
-func cmdRefresh(cfg config, ansiblePath string) (burnin.Matrix, error) {
-	glClient := makeGitlabClient(cfg.GitlabServerURL, cfg.GitlabProjectID, cfg.GitlabToken)
+func cmdRefresh(cfg config, ansiblePath string) (burnin.Notifier, error) {
+	glClient := makeBurninForge(cfg)
+	jobURL, err := glClient.WebURLForJob(cfg.GitlabJobID)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := makeNotifier(cfg, jobURL)
+	alertmgr := makeAlertmanagers(cfg)
+	driver := makeDriver(cfg, ansiblePath)
+	return notifier, job.ProcessRefresh(glClient, alertmgr, driver, jobOptions(cfg))
+}
+
+func cmdDigest(cfg config) (burnin.Notifier, error) {
+	glClient := makeBurninForge(cfg)
 	jobURL, err := glClient.WebURLForJob(cfg.GitlabJobID)
 	if err != nil {
 		return nil, err
 	}
 
-	matrixClient := matrix.NewClient(cfg.MatrixHomeserverURL, cfg.MatrixRoomID, cfg.MatrixAccessToken, jobURL)
-	alertmgr := alertmanager.NewClient(cfg.AlertmanagerAPIURL)
-	ansibleDriver := ansible.NewDriver(ansiblePath)
-	return matrixClient, job.ProcessRefresh(glClient, alertmgr, ansibleDriver)
+	notifier := makeNotifier(cfg, jobURL)
+	return notifier, job.ProcessDigest(cfg.BaseDirectory, glClient, notifier, jobOptions(cfg))
+}
+
+// cmdMatrixBot runs until it is killed. It has no notifier, as there is no burn-in its errors could be related to.
+func cmdMatrixBot(cfg config) (burnin.Notifier, error) {
+	allowedUsers := nonEmpty(cfg.MatrixBotAllowedUsers)
+	if len(allowedUsers) == 0 {
+		return nil, errors.New("MATRIX_BOT_ALLOWED_USERS is required for the Matrix bot")
+	}
+
+	chatOps := &job.ChatOps{
+		Repo:         makeBurninForge(cfg),
+		Branch:       cfg.MatrixBotBranch,
+		AllowedUsers: allowedUsers,
+	}
+
+	client := matrix.NewClient(cfg.MatrixHomeserverURL, cfg.MatrixRoomID, cfg.MatrixAccessToken, nil, nil, matrix.Templates{})
+	return nil, client.Listen(chatOps.Handle)
+}
+
+// cmdRenderNotification prints a Matrix notification as HTML and plain text, without sending it. It renders the
+// templates in MATRIX_TEMPLATE_DIR with the "run" file given as second argument, or with sample data.
+func cmdRenderNotification(cfg config) (burnin.Notifier, error) {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	deployment := sampleDeployment
+	if len(os.Args) > 3 {
+		data, err := ioutil.ReadFile(os.Args[3])
+		if err != nil {
+			return nil, err
+		}
+		deployment = burnin.Deployment{}
+		if err := toml.Unmarshal(data, &deployment); err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %v", os.Args[3], err)
+		}
+		deployment.Filename = filepath.Base(os.Args[3])
+	}
+
+	jobURL, err := url.Parse("https://gitlab.example.com/burn-in-tests/deployments/-/jobs/1/")
+	if err != nil {
+		return nil, err
+	}
+
+	client := matrix.NewClient(
+		cfg.MatrixHomeserverURL,
+		cfg.MatrixRoomID,
+		"",
+		jobURL,
+		loadIdentities(cfg),
+		loadTemplates(cfg),
+	)
+	html, plain, err := client.Preview(os.Args[2], deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("%s\n\n%s\n", html, plain)
+	return nil, nil
+}
+
+// cmdHistory prints the history of a "run" file, given as path or as name in the "runs" folder.
+func cmdHistory(cfg config) (burnin.Notifier, error) {
+	if len(os.Args) < 3 {
+		usage()
+	}
+
+	filename := os.Args[2]
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		filename = filepath.Join(cfg.BaseDirectory, "runs", filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var deployment burnin.Deployment
+	if err := toml.Unmarshal(data, &deployment); err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %v", filename, err)
+	}
+
+	if len(deployment.History) == 0 {
+		fmt.Printf("%s has no history\n", filepath.Base(filename))
+		return nil, nil
+	}
+
+	for _, entry := range deployment.History {
+		fmt.Printf("%s  %s on %s: %s\n", entry.At.UTC().Format(time.RFC3339), entry.Action, entry.Host, entry.Outcome)
+		fmt.Printf("  binary:  %s\n", entry.Binary)
+		if entry.CommitSHA != "" {
+			fmt.Printf("  commit:  %s\n", entry.CommitSHA)
+		}
+		if entry.BinaryChecksum != "" {
+			fmt.Printf("  sha-256: %s\n", entry.BinaryChecksum)
+		}
+		if len(entry.CustomOptions) > 0 {
+			fmt.Printf("  options: %s\n", strings.Join(entry.CustomOptions, " "))
+		}
+		if entry.JobURL != "" {
+			fmt.Printf("  CI job:  %s\n", entry.JobURL)
+		}
+		if entry.Details != "" {
+			fmt.Printf("  details: %s\n", entry.Details)
+		}
+	}
+	return nil, nil
+}
+
+var sampleDeployment = burnin.Deployment{
+	PullRequest:    "https://github.com/paritytech/polkadot/pull/2013",
+	CommitSHA:      "0fb42a943e216914ee7181b978c86786edbd07ba",
+	CustomBinary:   "https://gitlab.example.com/parity/polkadot/-/jobs/805835/artifacts/raw/artifacts/polkadot",
+	BinaryChecksum: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	CustomOptions:  []string{"--wasm-execution=compiled"},
+	RequestedBy:    "mxinden",
+	Network:        "kusama",
+	NodeType:       burnin.FullNode,
+	DeployedAt:     time.Date(2021, 1, 12, 16, 0, 0, 0, time.UTC),
+	DeployedOn:     "kusama-fullnode-uw1-0",
+	PublicFQDN:     "kusama-fullnode-uw1-0.example.com",
+	InternalFQDN:   "kusama-fullnode-uw1-0-int.example.com",
+	LogViewer:      "https://grafana.example.com/explore?orgId=1",
+	Dashboards: map[string]string{
+		"substrate_networking": "https://grafana.example.com/d/vKVuiD9Zk/substrate-networking?orgId=1",
+	},
+	Health: &burnin.HealthCheck{
+		CheckedAt:  time.Date(2021, 1, 12, 16, 10, 0, 0, time.UTC),
+		Healthy:    true,
+		Version:    "0.8.27-a7810560c0f",
+		Peers:      25,
+		FirstBlock: 5793100,
+		LastBlock:  5793150,
+	},
+	Filename: "run-kusama-fullnode-0-1610000000.toml",
+}
+
+// makeNotifier returns a notifier sending to everything in NOTIFIERS.
+func makeNotifier(cfg config, jobURL *url.URL) burnin.Notifier {
+	if cfg.DryRunPlan != nil {
+		return dryrun.Notifier{Plan: cfg.DryRunPlan}
+	}
+
+	var notifiers notify.Multi
+
+	for _, name := range nonEmpty(cfg.Notifiers) {
+		switch name {
+		case "matrix":
+			notifiers = append(notifiers, matrix.NewClient(
+				cfg.MatrixHomeserverURL,
+				cfg.MatrixRoomID,
+				cfg.MatrixAccessToken,
+				jobURL,
+				loadIdentities(cfg),
+				loadTemplates(cfg),
+			))
+		case "slack":
+			if cfg.SlackWebhookURL == nil {
+				log.Fatalln("SLACK_WEBHOOK_URL is required for the 'slack' notifier")
+			}
+			notifiers = append(notifiers, notify.Events{Sender: slack.NewClient(cfg.SlackWebhookURL), JobURL: jobURL})
+		case "webhook":
+			if cfg.WebhookURL == nil {
+				log.Fatalln("WEBHOOK_URL is required for the 'webhook' notifier")
+			}
+			sender := webhook.NewClient(cfg.WebhookURL, cfg.WebhookSecret)
+			notifiers = append(notifiers, notify.Events{Sender: sender, JobURL: jobURL})
+		case "email":
+			sender, err := email.NewClient(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.EmailFrom, nonEmpty(cfg.EmailTo))
+			if err != nil {
+				log.Fatalf("creating email client failed: %v\n", err)
+			}
+			notifiers = append(notifiers, notify.Events{Sender: sender, JobURL: jobURL})
+		default:
+			log.Fatalf("unsupported notifier '%s' (must be 'matrix', 'slack', 'webhook' or 'email')\n", name)
+		}
+	}
+
+	return notifiers
+}
+
+func loadIdentities(cfg config) matrix.Identities {
+	if cfg.MatrixIdentityMap == "" {
+		return nil
+	}
+
+	identities, err := matrix.LoadIdentities(cfg.MatrixIdentityMap)
+	if err != nil {
+		log.Fatalf("loading MATRIX_IDENTITY_MAP failed: %v\n", err)
+	}
+	return identities
+}
+
+func loadTemplates(cfg config) matrix.Templates {
+	templates, err := matrix.LoadTemplates(cfg.MatrixTemplateDir, cfg.OverviewURL)
+	if err != nil {
+		log.Fatalf("loading MATRIX_TEMPLATE_DIR failed: %v\n", err)
+	}
+	return templates
+}
+
+func makeAnsibleDriver(cfg config, ansiblePath string) burnin.DeploymentDriver {
+	allow, deny := nonEmpty(cfg.AnsibleWarningsAllow), nonEmpty(cfg.AnsibleWarningsDeny)
+	if allow == nil {
+		allow = ansible.DefaultAllowedWarnings
+	}
+	if deny == nil {
+		deny = ansible.DefaultDeniedWarnings
+	}
+
+	policy, err := ansible.NewWarningPolicy(allow, deny)
+	if err != nil {
+		log.Fatalf("parsing ANSIBLE_WARNINGS_ALLOW/ANSIBLE_WARNINGS_DENY failed: %v\n", err)
+	}
+
+	timeouts := ansible.Timeouts{Default: cfg.AnsibleTimeout, Playbooks: make(map[string]time.Duration)}
+	for playbook, value := range parseLabels(cfg.AnsiblePlaybookTimeouts) {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("invalid timeout of playbook '%s' in ANSIBLE_PLAYBOOK_TIMEOUTS: %v\n", playbook, err)
+		}
+		timeouts.Playbooks[playbook] = timeout
+	}
+
+	patterns := nonEmpty(cfg.AnsibleSensitiveVars)
+	if patterns == nil {
+		patterns = ansible.DefaultSensitiveVars
+	}
+	sensitive, err := ansible.NewSensitiveVars(patterns)
+	if err != nil {
+		log.Fatalf("parsing ANSIBLE_SENSITIVE_VARS failed: %v\n", err)
+	}
+
+	vault := cfg.VaultPasswordFile
+	if vault != "" {
+		// ansible-playbook runs in the directory of the playbooks
+		if vault, err = filepath.Abs(vault); err != nil {
+			log.Fatalf("invalid BURNIN_VAULT_PASSWORD_FILE: %v\n", err)
+		}
+		if _, err := os.Stat(vault); err != nil {
+			log.Fatalf("invalid BURNIN_VAULT_PASSWORD_FILE: %v\n", err)
+		}
+	}
+
+	return ansible.NewDriver(ansiblePath, ansible.Options{
+		LogDir:            cfg.AnsibleLogDir,
+		Warnings:          policy,
+		Timeouts:          timeouts,
+		SensitiveVars:     sensitive,
+		VaultPasswordFile: vault,
+		Check:             cfg.DryRunPlan != nil,
+	})
+}
+
+func makeSystemdDriver(cfg config) burnin.DeploymentDriver {
+	return systemd.NewDriver(systemd.Options{
+		User:       cfg.SystemdSSHUser,
+		SSHArgs:    nonEmpty(cfg.SystemdSSHArgs),
+		Sudo:       cfg.SystemdSudo,
+		Unit:       cfg.SystemdUnit,
+		BinaryDir:  cfg.SystemdBinaryDir,
+		BinaryLink: cfg.SystemdBinaryLink,
+		ChainDB:    cfg.SystemdChainDB,
+		Timeout:    cfg.AnsibleTimeout,
+		Check:      cfg.DryRunPlan != nil,
+	})
+}
+
+// makeDriver returns the driver of DEPLOYMENT_DRIVER, except for the networks listed in DEPLOYMENT_DRIVERS.
+func makeDriver(cfg config, ansiblePath string) burnin.DeploymentDriver {
+	drivers := make(map[string]burnin.DeploymentDriver)
+	byName := func(name string) burnin.DeploymentDriver {
+		if d, ok := drivers[name]; ok {
+			return d
+		}
+
+		switch name {
+		case "ansible":
+			drivers[name] = makeAnsibleDriver(cfg, ansiblePath)
+		case "systemd":
+			drivers[name] = makeSystemdDriver(cfg)
+		case "local":
+			drivers[name] = localnode.NewDriver(localnode.Options{
+				Dir:    cfg.LocalNodesDir,
+				Binary: cfg.LocalNodeBinary,
+				Check:  cfg.DryRunPlan != nil,
+			})
+		default:
+			log.Fatalf("unsupported deployment driver '%s' (must be 'ansible', 'systemd' or 'local')\n", name)
+		}
+		return drivers[name]
+	}
+
+	router := burnin.DriverRouter{
+		Default:  byName(cfg.DeploymentDriver),
+		Networks: make(map[string]burnin.DeploymentDriver),
+	}
+	for network, name := range parseLabels(cfg.DeploymentDrivers) {
+		router.Networks[network] = byName(name)
+	}
+
+	if cfg.DryRunPlan != nil {
+		return dryrun.DeploymentDriver{DeploymentDriver: router, Plan: cfg.DryRunPlan}
+	}
+	return router
+}
+
+// makeAlertmanagers returns a client for the default Alertmanager cluster and one for each network with its own.
+func makeAlertmanagers(cfg config) burnin.Alertmanagers {
+	auth := alertmanager.Auth{
+		Username:    cfg.AlertmanagerUsername,
+		Password:    cfg.AlertmanagerPassword,
+		BearerToken: cfg.AlertmanagerBearerToken,
+		ClientCert:  cfg.AlertmanagerClientCert,
+		ClientKey:   cfg.AlertmanagerClientKey,
+		CACert:      cfg.AlertmanagerCACert,
+	}
+
+	makeClient := func(endpoints []*url.URL) *alertmanager.Client {
+		c, err := alertmanager.NewClient(endpoints, auth)
+		if err != nil {
+			log.Fatalf("creating alertmanager client for %v failed: %v\n", endpoints, err)
+		}
+		return c
+	}
+
+	router := alertmanager.Router{
+		Default:  makeClient(cfg.AlertmanagerAPIURLs),
+		Networks: make(map[string]*alertmanager.Client),
+	}
+
+	for _, entry := range cfg.AlertmanagerNetworkAPIURLs {
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid ALERTMANAGER_NETWORK_API_URLS entry '%s' (must be 'network=url,url')\n", entry)
+		}
+
+		var endpoints []*url.URL
+		for _, raw := range strings.Split(parts[1], ",") {
+			u, err := url.Parse(strings.TrimSpace(raw))
+			if err != nil || !u.IsAbs() {
+				log.Fatalf("invalid alertmanager URL '%s' for network %s\n", raw, parts[0])
+			}
+			endpoints = append(endpoints, u)
+		}
+
+		router.Networks[strings.TrimSpace(parts[0])] = makeClient(endpoints)
+	}
+
+	if cfg.DryRunPlan != nil {
+		return dryrun.Alertmanagers{Alertmanagers: router, Plan: cfg.DryRunPlan}
+	}
+	return router
+}
+
+func jobOptions(cfg config) job.Options {
+	return job.Options{
+		SilenceDuration: cfg.SilenceDuration,
+		SilenceGrace:    cfg.SilenceGrace,
+		AlertGate: job.AlertGate{
+			Disabled:   cfg.AlertGateDisabled || cfg.DryRunPlan != nil, // nothing was deployed in a dry run
+			AlertNames: nonEmpty(cfg.AlertGateNames),
+			Labels:     parseLabels(cfg.AlertGateLabels),
+			Delay:      cfg.AlertGateDelay,
+		},
+		DigestMaxAge:     cfg.DigestMaxAge,
+		AllowedExtraVars: nonEmpty(cfg.AllowedExtraVars),
+		HealthChecker:    makeHealthChecker(cfg),
+		HistoryLimit:     cfg.HistoryLimit,
+	}
+}
+
+// makeHealthChecker returns nil if health checks are disabled, which they are in a dry run, too.
+func makeHealthChecker(cfg config) burnin.HealthChecker {
+	if cfg.HealthCheckWindow <= 0 || cfg.DryRunPlan != nil {
+		return nil
+	}
+
+	return health.NewChecker(health.Options{
+		Window:      cfg.HealthCheckWindow,
+		Interval:    cfg.HealthCheckInterval,
+		MinPeers:    cfg.HealthMinPeers,
+		RPCPort:     cfg.HealthRPCPort,
+		MetricsPort: cfg.HealthMetricsPort,
+	})
+}
+
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// parseLabels turns "name=value" pairs into a map. It never returns nil, so an empty ALERT_GATE_LABELS disables the
+// label check instead of falling back to the default.
+func parseLabels(pairs []string) map[string]string {
+	labels := make(map[string]string)
+	for _, p := range pairs {
+		if p == "" {
+			continue
+		}
+
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid label '%s' (must be 'name=value')\n", p)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return labels
 }
 
 func usage() {
-	fmt.Printf("usage: %s <request|deploy|update|cleanup|refresh>\n", os.Args[0])
+	fmt.Printf("usage: %s <request|deploy|update|cleanup|refresh|digest|matrix-bot>\n", os.Args[0])
+	fmt.Printf("       %s --dry-run[=text|json] <deploy|update|cleanup|refresh>\n", os.Args[0])
+	fmt.Printf("       %s render-notification <request|deployment|update|cleanup|rollback|status|error|digest> [<run file>]\n", os.Args[0])
+	fmt.Printf("       %s history <run file>\n", os.Args[0])
 	os.Exit(1)
 }
 
@@ -210,11 +831,58 @@ func parseConfig() config {
 		log.Fatalf("unable to determine hostname: %v\n", err)
 	}
 
+	if cfg.GitlabTokenType != "private" && cfg.GitlabTokenType != "oauth" {
+		log.Fatalf("unsupported GITLAB_TOKEN_TYPE '%s' (must be 'private' or 'oauth')\n", cfg.GitlabTokenType)
+	}
+
 	return cfg
 }
 
-func makeGitlabClient(url *url.URL, projectID int, token string) burnin.Gitlab {
-	glClient, err := gitlab.NewClient(url, projectID, token)
+// gitlabToken returns the first of the given values which is set.
+func (cfg config) gitlabToken(values ...string) gitlab.Token {
+	token := gitlab.Token{Type: gitlab.PrivateToken}
+	if cfg.GitlabTokenType == "oauth" {
+		token.Type = gitlab.OAuthToken
+	}
+
+	for _, v := range values {
+		if v != "" {
+			token.Value = v
+			break
+		}
+	}
+
+	return token
+}
+
+// withJobToken adds CI_JOB_TOKEN if this is enabled. GitLab rejects it for repository, project and user calls, which
+// keep using the read token.
+func (cfg config) withJobToken(credentials gitlab.Credentials) gitlab.Credentials {
+	if cfg.GitlabReadWithJobToken && cfg.GitlabJobToken != "" {
+		credentials.Job = gitlab.Token{Type: gitlab.JobToken, Value: cfg.GitlabJobToken}
+	}
+
+	return credentials
+}
+
+// burninCredentials returns the credentials for the "deployments" project and the runners on the burn-in hosts.
+func burninCredentials(cfg config) gitlab.Credentials {
+	return cfg.withJobToken(gitlab.Credentials{
+		Read:        cfg.gitlabToken(cfg.GitlabRepoToken, cfg.GitlabToken),
+		Write:       cfg.gitlabToken(cfg.GitlabRepoToken, cfg.GitlabToken),
+		RunnerAdmin: cfg.gitlabToken(cfg.GitlabRunnerToken, cfg.GitlabToken),
+	})
+}
+
+// buildCredentials returns the credentials for the project building the node binaries. Playing a manual job requires
+// write access, runners are never touched.
+func buildCredentials(cfg config) gitlab.Credentials {
+	token := cfg.gitlabToken(cfg.PolkadotGitlabToken, cfg.GitlabToken)
+	return cfg.withJobToken(gitlab.Credentials{Read: token, Write: token})
+}
+
+func makeGitlabClient(url *url.URL, projectID int, credentials gitlab.Credentials) burnin.Gitlab {
+	glClient, err := gitlab.NewClient(url, projectID, credentials)
 	if err != nil {
 		log.Fatalf("creating gitlab client for %s failed: %v\n", url, err)
 	}
@@ -225,3 +893,40 @@ func makeGitlabClient(url *url.URL, projectID int, token string) burnin.Gitlab {
 
 	return glClient
 }
+
+// makeBurninForge returns the client for the "deployments" repository, its CI and the runners on the burn-in hosts.
+func makeBurninForge(cfg config) burnin.Gitlab {
+	forge := makeDeploymentsForge(cfg)
+	if cfg.DryRunPlan != nil {
+		return dryrun.Gitlab{Gitlab: forge, Plan: cfg.DryRunPlan}
+	}
+	return forge
+}
+
+func makeDeploymentsForge(cfg config) burnin.Gitlab {
+	glClient := makeGitlabClient(cfg.GitlabServerURL, cfg.GitlabProjectID, burninCredentials(cfg))
+
+	switch cfg.DeploymentsForge {
+	case "gitlab":
+		return glClient
+	case "gitea":
+		if cfg.GiteaServerURL == nil {
+			log.Fatalln("GITEA_SERVER_URL is required when DEPLOYMENTS_FORGE is 'gitea'")
+		}
+
+		giteaClient, err := gitea.NewClient(cfg.GiteaServerURL, cfg.GiteaRepository, cfg.GiteaToken)
+		if err != nil {
+			log.Fatalf("creating gitea client for %s failed: %v\n", cfg.GiteaServerURL, err)
+		}
+
+		if err := giteaClient.Authenticate(); err != nil {
+			log.Fatalf("gitea auth failed: %v\n", err)
+		}
+
+		return burnin.Forge{Repo: giteaClient, CI: giteaClient, RunnerPool: glClient}
+	default:
+		log.Fatalf("unsupported DEPLOYMENTS_FORGE '%s' (must be 'gitlab' or 'gitea')\n", cfg.DeploymentsForge)
+	}
+
+	return nil
+}
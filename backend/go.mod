@@ -1,6 +1,6 @@
 module gitlab.example.com/burn-in-tests/backend
 
-go 1.15
+go 1.16
 
 require (
 	github.com/caarlos0/env/v6 v6.4.0
@@ -17,28 +17,120 @@ package alertmanager
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/job"
 )
 
+// Auth holds the optional credentials for the Alertmanager API. Basic auth or a bearer token may be combined with mTLS,
+// but not with each other, as both use the Authorization header.
+type Auth struct {
+	Username    string
+	Password    string
+	BearerToken string
+	ClientCert  string // path to a PEM encoded client certificate for mTLS
+	ClientKey   string // path to the PEM encoded key of ClientCert
+	CACert      string // path to a PEM encoded CA certificate of the server, the system roots are used if empty
+}
+
+func (a Auth) validate() error {
+	if (a.Username != "" || a.Password != "") && a.BearerToken != "" {
+		return errors.New("either basic auth or a bearer token may be used for Alertmanager, not both")
+	}
+	return nil
+}
+
+func (a Auth) apply(request *http.Request) {
+	if a.Username != "" || a.Password != "" {
+		request.SetBasicAuth(a.Username, a.Password)
+	}
+	if a.BearerToken != "" {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.BearerToken))
+	}
+}
+
+func (a Auth) tlsConfig() (*tls.Config, error) {
+	if a.ClientCert == "" && a.CACert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if a.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(a.ClientCert, a.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if a.CACert != "" {
+		pem, err := ioutil.ReadFile(a.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", a.CACert)
+		}
+	}
+
+	return config, nil
+}
+
+// Client talks to a cluster of Alertmanager peers. Requests go to the first peer which answers, starting with the one
+// which answered the previous request. A peer is skipped on network errors and 5xx responses. POST requests (e.g.
+// creating silences) are only sent to the next peer if the connection failed, as the peer may have handled them
+// despite an error, and sending them again would create duplicate silences.
 type Client struct {
-	apiURL     *url.URL
+	endpoints  []*url.URL // API URLs of the peers (e.g. http://alertmanager.example.com/api/v2)
+	current    int
+	auth       Auth
 	httpClient *http.Client
 }
 
-func NewClient(apiURL *url.URL) *Client {
-	return &Client{
-		apiURL: apiURL,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+func NewClient(endpoints []*url.URL, auth Auth) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("at least one Alertmanager API URL is required")
+	}
+
+	if err := auth.validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := auth.tlsConfig()
+	if err != nil {
+		return nil, err
 	}
+
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
+
+	return &Client{
+		endpoints:  endpoints,
+		auth:       auth,
+		httpClient: httpClient,
+	}, nil
 }
 
 func (c *Client) CreateSilence(
@@ -48,33 +140,118 @@ func (c *Client) CreateSilence(
 	createdBy string,
 	comment string,
 ) (string, error) {
-	payload := struct {
-		Matchers  []burnin.AlertMatcher `json:"matchers"`
-		StartsAt  time.Time             `json:"startsAt"`
-		EndsAt    time.Time             `json:"endsAt"`
-		CreatedBy string                `json:"createdBy"`
-		Comment   string                `json:"comment"`
-	}{
+	return c.postSilence(burnin.Silence{
 		Matchers:  matchers,
 		StartsAt:  startsAt,
 		EndsAt:    endsAt,
 		CreatedBy: createdBy,
 		Comment:   comment,
+	})
+}
+
+func (c *Client) GetSilences() ([]burnin.Silence, error) {
+	request, response, err := c.do(http.MethodGet, nil, nil, "silences")
+	if err != nil {
+		return nil, err
 	}
+	defer response.Body.Close()
 
-	buf, err := json.Marshal(payload)
+	if err := errorIfNotOK(request, nil, response, false); err != nil {
+		return nil, err
+	}
+
+	var silences []burnin.Silence
+	if err := json.NewDecoder(response.Body).Decode(&silences); err != nil {
+		return nil, err
+	}
+
+	unexpired := make([]burnin.Silence, 0, len(silences))
+	for _, s := range silences {
+		if s.Status.State != "expired" {
+			unexpired = append(unexpired, s)
+		}
+	}
+
+	return unexpired, nil
+}
+
+// UpdateSilence posts the silence with its ID set. Alertmanager updates it in place if possible, otherwise it expires
+// the old silence and creates a new one.
+func (c *Client) UpdateSilence(silence burnin.Silence) (string, error) {
+	if silence.ID == "" {
+		return "", errors.New("unable to update a silence without an ID")
+	}
+
+	return c.postSilence(silence)
+}
+
+func (c *Client) DeleteSilence(id string) error {
+	request, response, err := c.do(http.MethodDelete, nil, nil, "silence", url.PathEscape(id))
 	if err != nil {
-		return "", err
+		return err
+	}
+	return errorIfNotOK(request, nil, response, true)
+}
+
+func (c *Client) GetAlerts(matchers []burnin.AlertMatcher) ([]burnin.Alert, error) {
+	q := url.Values{}
+	q.Set("active", "true")
+	q.Set("silenced", "true")
+	q.Set("inhibited", "false")
+	for _, m := range matchers {
+		q.Add("filter", formatMatcher(m))
 	}
 
-	u := fmt.Sprintf("%s/silences", c.apiURL.String())
-	request, err := http.NewRequest(http.MethodPost, u, bytes.NewBuffer(buf))
+	request, response, err := c.do(http.MethodGet, q, nil, "alerts")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if err := errorIfNotOK(request, nil, response, false); err != nil {
+		return nil, err
+	}
+
+	var alerts []burnin.Alert
+	if err := json.NewDecoder(response.Body).Decode(&alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// formatMatcher returns the matcher in the syntax of the "filter" query parameter, e.g. instance=~".*foo.*".
+func formatMatcher(m burnin.AlertMatcher) string {
+	op := "="
+	if m.IsRegex {
+		op = "=~"
+	}
+	return m.Name + op + strconv.Quote(m.Value)
+}
+
+func (c *Client) postSilence(silence burnin.Silence) (string, error) {
+	payload := struct {
+		ID        string                `json:"id,omitempty"`
+		Matchers  []burnin.AlertMatcher `json:"matchers"`
+		StartsAt  time.Time             `json:"startsAt"`
+		EndsAt    time.Time             `json:"endsAt"`
+		CreatedBy string                `json:"createdBy"`
+		Comment   string                `json:"comment"`
+	}{
+		ID:        silence.ID,
+		Matchers:  silence.Matchers,
+		StartsAt:  silence.StartsAt,
+		EndsAt:    silence.EndsAt,
+		CreatedBy: silence.CreatedBy,
+		Comment:   silence.Comment,
+	}
+
+	buf, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	response, err := c.httpClient.Do(request)
+	request, response, err := c.do(http.MethodPost, nil, buf, "silences")
 	if err != nil {
 		return "", err
 	}
@@ -95,19 +272,69 @@ func (c *Client) CreateSilence(
 	return createSilenceResponse.SilenceID, nil
 }
 
-func (c *Client) DeleteSilence(id string) error {
-	u := fmt.Sprintf("%s/silence/%s", c.apiURL.String(), id)
+// do sends the request to the peers in turn until one of them answers with a status below 500. The response of the
+// last peer is returned if all of them fail with a server error. POST requests only go to the next peer if the
+// connection to one failed.
+func (c *Client) do(
+	method string,
+	query url.Values,
+	payload []byte,
+	paths ...string,
+) (*http.Request, *http.Response, error) {
+	var lastErr error
+	idempotent := method != http.MethodPost
 
-	request, err := http.NewRequest(http.MethodDelete, u, nil)
-	if err != nil {
-		return err
-	}
+	for i := range c.endpoints {
+		idx := (c.current + i) % len(c.endpoints)
+		u, err := job.AddPathsToURL(c.endpoints[idx], paths...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if query != nil {
+			u.RawQuery = query.Encode()
+		}
 
-	response, err := c.httpClient.Do(request)
-	if err != nil {
-		return err
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+
+		request, err := http.NewRequest(method, u.String(), body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if payload != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		c.auth.apply(request)
+
+		response, err := c.httpClient.Do(request)
+		switch {
+		case err != nil && !idempotent && !connectionFailed(err):
+			return nil, nil, err
+		case err != nil:
+			lastErr = err
+		case response.StatusCode >= http.StatusInternalServerError && idempotent && i < len(c.endpoints)-1:
+			_ = response.Body.Close()
+			lastErr = fmt.Errorf("%s %s: %s", method, u, response.Status)
+		default:
+			c.current = idx
+			return request, response, nil
+		}
+
+		if i < len(c.endpoints)-1 {
+			log.Printf("alertmanager %s failed, trying next peer: %v\n", c.endpoints[idx], lastErr)
+		}
 	}
-	return errorIfNotOK(request, nil, response, true)
+
+	return nil, nil, lastErr
+}
+
+// connectionFailed reports whether err happened before a request was sent, so that it cannot have reached the peer.
+func connectionFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
 func errorIfNotOK(request *http.Request, payload []byte, response *http.Response, closeResponse bool) error {
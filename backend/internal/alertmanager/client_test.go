@@ -0,0 +1,300 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package alertmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func TestClient(t *testing.T) {
+	var posted []map[string]interface{}
+	var deleted []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/silences", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = fmt.Fprint(w, `[
+  {"id": "a", "status": {"state": "active"}, "createdBy": "Burn-in Automator", "matchers": [{"name": "instance", "value": ".*host.*", "isRegex": true}]},
+  {"id": "b", "status": {"state": "expired"}},
+  {"id": "c", "status": {"state": "pending"}}
+]`)
+		case http.MethodPost:
+			payload := make(map[string]interface{})
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			posted = append(posted, payload)
+			_, _ = fmt.Fprintf(w, `{"silenceID": "silence-%d"}`, len(posted))
+		}
+	})
+	mux.HandleFunc("/api/v2/silence/", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		deleted = append(deleted, r.URL.Path)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	apiURL, err := url.Parse(server.URL + "/api/v2")
+	require.NoError(t, err)
+	client, err := NewClient([]*url.URL{apiURL}, Auth{})
+	require.NoError(t, err)
+
+	silences, err := client.GetSilences()
+	require.NoError(t, err)
+	require.Len(t, silences, 2)
+	require.Equal(t, "a", silences[0].ID)
+	require.Equal(t, []burnin.AlertMatcher{{Name: "instance", Value: ".*host.*", IsRegex: true}}, silences[0].Matchers)
+	require.Equal(t, "c", silences[1].ID)
+
+	now := time.Now()
+	id, err := client.CreateSilence(silences[0].Matchers, now, now.Add(time.Hour), "Burn-in Automator", "testing")
+	require.NoError(t, err)
+	require.Equal(t, "silence-1", id)
+	require.NotContains(t, posted[0], "id")
+
+	silences[0].EndsAt = now
+	id, err = client.UpdateSilence(silences[0])
+	require.NoError(t, err)
+	require.Equal(t, "silence-2", id)
+	require.Equal(t, "a", posted[1]["id"])
+
+	_, err = client.UpdateSilence(burnin.Silence{})
+	require.Error(t, err)
+
+	require.NoError(t, client.DeleteSilence("a"))
+	require.Equal(t, []string{"/api/v2/silence/a"}, deleted)
+
+	server.Close()
+	require.Error(t, client.DeleteSilence("a"))
+}
+
+func TestClient_GetAlerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v2/alerts", r.URL.Path)
+		q := r.URL.Query()
+		require.Equal(t, "true", q.Get("active"))
+		require.Equal(t, "false", q.Get("inhibited"))
+		require.Equal(t, []string{`instance=~".*host.*"`, `chain="kusama"`}, q["filter"])
+		_, _ = fmt.Fprint(w, `[{"fingerprint": "f1", "labels": {"alertname": "NodeDown", "severity": "critical"}, "annotations": {"summary": "down"}}]`)
+	}))
+	defer server.Close()
+
+	apiURL, err := url.Parse(server.URL + "/api/v2")
+	require.NoError(t, err)
+
+	client, err := NewClient([]*url.URL{apiURL}, Auth{})
+	require.NoError(t, err)
+
+	alerts, err := client.GetAlerts([]burnin.AlertMatcher{
+		{Name: "instance", Value: ".*host.*", IsRegex: true},
+		{Name: "chain", Value: "kusama"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []burnin.Alert{{
+		Fingerprint: "f1",
+		Labels:      map[string]string{"alertname": "NodeDown", "severity": "critical"},
+		Annotations: map[string]string{"summary": "down"},
+	}}, alerts)
+}
+
+func TestClient_failover(t *testing.T) {
+	var hits []string
+	handler := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.WriteHeader(status)
+			_, _ = fmt.Fprint(w, `[]`)
+		}
+	}
+
+	broken := httptest.NewServer(handler("broken", http.StatusServiceUnavailable))
+	defer broken.Close()
+	healthy := httptest.NewServer(handler("healthy", http.StatusOK))
+	defer healthy.Close()
+	down := httptest.NewServer(handler("down", http.StatusOK))
+	down.Close()
+
+	client, err := NewClient([]*url.URL{mustParse(t, down.URL), mustParse(t, broken.URL), mustParse(t, healthy.URL)}, Auth{})
+	require.NoError(t, err)
+
+	_, err = client.GetSilences()
+	require.NoError(t, err)
+	require.Equal(t, []string{"broken", "healthy"}, hits)
+
+	// the peer which answered last is asked first
+	_, err = client.GetSilences()
+	require.NoError(t, err)
+	require.Equal(t, []string{"broken", "healthy", "healthy"}, hits)
+
+	// a 4xx response is not a reason to ask another peer
+	client, err = NewClient([]*url.URL{mustParse(t, broken.URL)}, Auth{})
+	require.NoError(t, err)
+	_, err = client.GetSilences()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "503 Service Unavailable")
+
+	_, err = NewClient(nil, Auth{})
+	require.Error(t, err)
+}
+
+func TestClient_failoverSilences(t *testing.T) {
+	var hits []string
+	handler := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.WriteHeader(status)
+			_, _ = fmt.Fprint(w, `{"silenceID":"`+name+`"}`)
+		}
+	}
+
+	broken := httptest.NewServer(handler("broken", http.StatusServiceUnavailable))
+	defer broken.Close()
+	healthy := httptest.NewServer(handler("healthy", http.StatusOK))
+	defer healthy.Close()
+	down := httptest.NewServer(handler("down", http.StatusOK))
+	down.Close()
+
+	// the broken peer may have created the silence anyway
+	client, err := NewClient([]*url.URL{mustParse(t, broken.URL), mustParse(t, healthy.URL)}, Auth{})
+	require.NoError(t, err)
+	_, err = client.CreateSilence(nil, time.Now(), time.Now().Add(time.Hour), "burnin", "test")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "503 Service Unavailable")
+	require.Equal(t, []string{"broken"}, hits)
+
+	// a peer which cannot be connected to has not seen the request
+	client, err = NewClient([]*url.URL{mustParse(t, down.URL), mustParse(t, healthy.URL)}, Auth{})
+	require.NoError(t, err)
+	id, err := client.CreateSilence(nil, time.Now(), time.Now().Add(time.Hour), "burnin", "test")
+	require.NoError(t, err)
+	require.Equal(t, "healthy", id)
+	require.Equal(t, []string{"broken", "healthy"}, hits)
+}
+
+func TestClient_auth(t *testing.T) {
+	var authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		_, _ = fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	for _, auth := range []Auth{{}, {Username: "burnin", Password: "s3cr3t"}, {BearerToken: "t0ken"}} {
+		client, err := NewClient([]*url.URL{mustParse(t, server.URL)}, auth)
+		require.NoError(t, err)
+		_, err = client.GetSilences()
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, []string{"", "Basic YnVybmluOnMzY3IzdA==", "Bearer t0ken"}, authorization)
+
+	_, err := NewClient(
+		[]*url.URL{mustParse(t, server.URL)},
+		Auth{Username: "burnin", Password: "s3cr3t", BearerToken: "t0ken"},
+	)
+	require.EqualError(t, err, "either basic auth or a bearer token may be used for Alertmanager, not both")
+}
+
+func TestClient_mTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientKey := writeSelfSignedCert(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Len(t, r.TLS.PeerCertificates, 1)
+		_, _ = fmt.Fprint(w, `[]`)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caCert := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0600))
+
+	client, err := NewClient([]*url.URL{mustParse(t, server.URL)}, Auth{CACert: caCert})
+	require.NoError(t, err)
+	_, err = client.GetSilences()
+	require.Error(t, err, "server requires a client certificate")
+
+	client, err = NewClient([]*url.URL{mustParse(t, server.URL)}, Auth{ClientCert: clientCert, ClientKey: clientKey, CACert: caCert})
+	require.NoError(t, err)
+	_, err = client.GetSilences()
+	require.NoError(t, err)
+
+	_, err = NewClient([]*url.URL{mustParse(t, server.URL)}, Auth{ClientCert: caCert, ClientKey: caCert})
+	require.Error(t, err)
+}
+
+func TestRouter(t *testing.T) {
+	defaultClient, err := NewClient([]*url.URL{mustParse(t, "http://alertmanager.example.com/api/v2")}, Auth{})
+	require.NoError(t, err)
+	kusamaClient, err := NewClient([]*url.URL{mustParse(t, "http://kusama.alertmanager.example.com/api/v2")}, Auth{})
+	require.NoError(t, err)
+
+	router := Router{Default: defaultClient, Networks: map[string]*Client{"kusama": kusamaClient}}
+	require.Same(t, kusamaClient, router.ForNetwork("kusama"))
+	require.Same(t, defaultClient, router.ForNetwork("polkadot"))
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "burn-in"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
@@ -0,0 +1,32 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package alertmanager
+
+import burnin "gitlab.example.com/burn-in-tests/backend"
+
+// Router sends everything concerning the nodes of a network to the Alertmanager of that network. Networks without
+// their own Alertmanager use Default.
+type Router struct {
+	Default  *Client
+	Networks map[string]*Client
+}
+
+func (r Router) ForNetwork(network string) burnin.Alertmanager {
+	if c, ok := r.Networks[network]; ok {
+		return c
+	}
+	return r.Default
+}
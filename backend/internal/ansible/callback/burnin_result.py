@@ -0,0 +1,90 @@
+# Copyright (C) 2022 Parity Technologies (UK) Ltd.
+# SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+# Writes the results of a playbook run to the file named by BURNIN_RESULT_FILE once the run finished, in the format
+# of Ansible's "json" stdout callback. Unlike that callback, it leaves stdout to the usual one, which streams the
+# output while the playbook runs. Failures of tasks with "ignore_errors" are marked as "ignored".
+
+from __future__ import absolute_import, division, print_function
+__metaclass__ = type
+
+import json
+import os
+from datetime import datetime
+
+from ansible.parsing.ajson import AnsibleJSONEncoder
+from ansible.plugins.callback import CallbackBase
+
+DOCUMENTATION = '''
+    name: burnin_result
+    callback: burnin_result
+    type: aggregate
+    short_description: writes the results of a playbook run to BURNIN_RESULT_FILE
+    description:
+      - Used by the burn-in backend to tell which tasks failed on which host.
+'''
+
+
+def _now():
+    return datetime.utcnow().isoformat() + 'Z'
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = 'aggregate'
+    CALLBACK_NAME = 'burnin_result'
+    CALLBACK_NEEDS_ENABLED = False
+    CALLBACK_NEEDS_WHITELIST = False
+
+    def __init__(self):
+        super(CallbackModule, self).__init__()
+        self.path = os.environ.get('BURNIN_RESULT_FILE')
+        self.plays = []
+
+    def v2_playbook_on_play_start(self, play):
+        self.plays.append({'play': {'name': play.get_name()}, 'tasks': []})
+
+    def v2_playbook_on_task_start(self, task, is_conditional):
+        self._start(task)
+
+    def v2_playbook_on_handler_task_start(self, task):
+        self._start(task)
+
+    def _start(self, task):
+        self.plays[-1]['tasks'].append({
+            'task': {'name': task.get_name(), 'duration': {'start': _now()}},
+            'hosts': {},
+        })
+
+    def _record(self, result, **status):
+        task = self.plays[-1]['tasks'][-1]
+        task['task']['duration']['end'] = _now()
+        host = dict(
+            changed=bool(result._result.get('changed', False)),
+            msg=result._result.get('msg', ''),
+            stderr=result._result.get('stderr', ''),
+        )
+        host.update(status)
+        task['hosts'][result._host.get_name()] = host
+
+    def v2_runner_on_ok(self, result):
+        self._record(result)
+
+    def v2_runner_on_failed(self, result, ignore_errors=False):
+        self._record(result, failed=True, ignored=ignore_errors)
+
+    def v2_runner_on_unreachable(self, result):
+        self._record(result, unreachable=True)
+
+    def v2_runner_on_skipped(self, result):
+        self._record(result, skipped=True)
+
+    def v2_playbook_on_stats(self, stats):
+        if not self.path:
+            return
+        output = {
+            'plays': self.plays,
+            'stats': dict((host, stats.summarize(host)) for host in sorted(stats.processed.keys())),
+        }
+        with open(self.path, 'w') as f:
+            json.dump(output, f, cls=AnsibleJSONEncoder)
@@ -16,24 +16,70 @@
 package ansible
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
 )
 
 type Driver struct {
-	path    string // absolute path to ansible files such as playbooks, inventory, etc.
-	debug   bool   // enabled by setting environment variable DEBUG_ANSIBLE=1
-	verbose bool   // enabled by setting environment variable DEBUG_ANSIBLE=2
+	path      string // absolute path to ansible files such as playbooks, inventory, etc.
+	logDir    string // receives the full output of every playbook run, e.g. to keep it as CI artifact
+	warnings  WarningPolicy
+	timeouts  Timeouts
+	sensitive []*regexp.Regexp // names of variables which are passed in a temporary file and redacted in the output
+	vault     string           // password file of Ansible Vault, if any
+	check     bool             // runs playbooks with "--check --diff", which only reports changes
+	killGrace time.Duration    // between SIGTERM and SIGKILL when a playbook run is stopped
+	command   string           // "ansible-playbook", replaced in tests
+	debug     bool             // enabled by setting environment variable DEBUG_ANSIBLE=1
+	verbose   bool             // enabled by setting environment variable DEBUG_ANSIBLE=2
+}
+
+// Options of the driver. The zero value logs no output to files, fails on any warning, never times out and passes all
+// variables on the command line.
+type Options struct {
+	LogDir   string // receives the full output of every playbook run, unless empty
+	Warnings WarningPolicy
+	Timeouts Timeouts
+	// SensitiveVars match the names of variables which are kept off the command line of ansible-playbook, see
+	// DefaultSensitiveVars. Their values are written to a temporary vars file and redacted in the output.
+	SensitiveVars     []*regexp.Regexp
+	VaultPasswordFile string // passed as "--vault-password-file", unless empty
+	Check             bool   // report what the playbooks would change instead of changing it
+}
+
+// Timeouts limit how long playbooks may run. Zero means no limit.
+type Timeouts struct {
+	Default   time.Duration
+	Playbooks map[string]time.Duration // by playbook name, e.g. "kusama-nodes.yml"
+}
+
+// For returns the timeout of the playbook.
+func (t Timeouts) For(playbook string) time.Duration {
+	if timeout, ok := t.Playbooks[playbook]; ok {
+		return timeout
+	}
+	return t.Default
 }
 
-func NewDriver(path string) *Driver {
+// NewDriver returns a driver which streams the output of the playbooks to the job log. ansible-playbook runs in its
+// own process group, which is killed as a whole when the playbook times out or run-job is interrupted.
+func NewDriver(path string, opts Options) *Driver {
 	debug := false
 	verbose := false
 
@@ -44,26 +90,43 @@ func NewDriver(path string) *Driver {
 	}
 
 	return &Driver{
-		path,
-		debug,
-		verbose,
+		path:      path,
+		logDir:    opts.LogDir,
+		warnings:  opts.Warnings,
+		timeouts:  opts.Timeouts,
+		sensitive: opts.SensitiveVars,
+		vault:     opts.VaultPasswordFile,
+		check:     opts.Check,
+		killGrace: 10 * time.Second,
+		command:   "ansible-playbook",
+		debug:     debug,
+		verbose:   verbose,
 	}
 }
 
-func (d *Driver) RunPlaybook(
-	name string,
-	runOn string,
-	nodeBinary *url.URL,
-	wipeChainDB bool,
-	nodePublicName string,
-	customOptions []string,
-) error {
-	args, err := buildArgs(name, runOn, nodeBinary, wipeChainDB, nodePublicName, customOptions)
+func (d *Driver) RunPlaybook(run burnin.PlaybookRun) error {
+	name, nodePublicName := run.Playbook, run.NodePublicName
+	args, secrets, err := buildArgs(run, d.sensitive)
 	if err != nil {
 		return err
 	}
 
-	if d.debug {
+	if len(secrets) > 0 {
+		varsFile, err := writeVarsFile(secrets)
+		if err != nil {
+			return fmt.Errorf("writing sensitive vars failed: %v", err)
+		}
+		defer os.Remove(varsFile)
+		args = append(args, "-e", "@"+varsFile)
+	}
+
+	if d.vault != "" {
+		args = append(args, "--vault-password-file", d.vault)
+	}
+
+	if d.check {
+		args = append(args, "--check", "--diff")
+	} else if d.debug {
 		args = append(args, "--diff")
 	}
 
@@ -71,8 +134,30 @@ func (d *Driver) RunPlaybook(
 		args = append(args, "-vvvv")
 	}
 
-	cmd := exec.Command("ansible-playbook", args...)
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	timeout := d.timeouts.For(name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	callbackDir, err := ioutil.TempDir("", "burnin-callback-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(callbackDir)
+	callbackEnv, resultFile, err := resultCallbackEnv(callbackDir)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(d.command, args...)
 	cmd.Dir = d.path
+	cmd.Env = append(os.Environ(), callbackEnv...)
+	setProcessGroup(cmd)
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -84,52 +169,111 @@ func (d *Driver) RunPlaybook(
 		return err
 	}
 
-	log.Println(strings.Join(cmd.Args, " "))
-	if err := cmd.Start(); err != nil {
+	logFile, err := d.createLogFile(name, nodePublicName)
+	if err != nil {
 		return err
 	}
 
-	stdoutBuf, err := ioutil.ReadAll(stdoutPipe)
-	if err != nil {
-		return err
+	var file io.Writer
+	if logFile != nil {
+		defer logFile.Close()
+		fmt.Fprintln(logFile, strings.Join(cmd.Args, " "))
+		file = logFile
 	}
-	stdout := string(stdoutBuf)
+	out := newOutput(name+"/"+nodePublicName, file)
+	out.redact = newRedactor(secrets)
 
-	stderrBuf, err := ioutil.ReadAll(stderrPipe)
-	if err != nil {
+	log.Println(strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
 		return err
 	}
-	stderr := string(stderrBuf)
+	exited := make(chan struct{})
+	defer close(exited)
+	go d.stopOnDone(ctx, cmd, exited)
 
+	// Both pipes have to be read to the end before waiting, as Wait closes them.
+	readErr := out.readAll(stdoutPipe, stderrPipe)
 	err = cmd.Wait()
-	if err != nil || strings.Contains(stdout, "no hosts matched") || strings.Contains(stderr, "[WARNING]") {
-		return fmt.Errorf("err: %v\nstdout: %s\nstderr: %s\n", err, stdout, stderr)
-	} else if d.debug {
-		fmt.Println("stdout:", stdout)
-		fmt.Println("stderr:", stderr)
+	if err == nil {
+		err = readErr
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = &burnin.PlaybookTimeoutError{Playbook: name, Timeout: timeout, Err: err}
+	case ctx.Err() != nil:
+		err = fmt.Errorf("interrupted, killed ansible-playbook (%v)", err)
+	}
+
+	report, readErr := ioutil.ReadFile(resultFile)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		log.Printf("%s/%s reading the playbook results failed: %v\n", name, nodePublicName, readErr)
+	}
+
+	return out.result(name, err, report, d.warnings, logFile)
+}
+
+// stopOnDone terminates the process group of the command once ctx is done, unless the command exited before. The
+// group is killed if it is still around after the grace period.
+func (d *Driver) stopOnDone(ctx context.Context, cmd *exec.Cmd, exited <-chan struct{}) {
+	select {
+	case <-exited:
+		return
+	case <-ctx.Done():
+	}
+
+	log.Printf("stopping ansible-playbook (pid %d): %v\n", cmd.Process.Pid, ctx.Err())
+	if err := signalProcessGroup(cmd, syscall.SIGTERM); err != nil {
+		log.Printf("terminating process group of pid %d failed: %v\n", cmd.Process.Pid, err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(d.killGrace):
+		if err := signalProcessGroup(cmd, syscall.SIGKILL); err != nil {
+			log.Printf("killing process group of pid %d failed: %v\n", cmd.Process.Pid, err)
+		}
+	}
+}
+
+// createLogFile returns nil if no log directory is configured.
+func (d *Driver) createLogFile(playbook, nodePublicName string) (*os.File, error) {
+	if d.logDir == "" {
+		return nil, nil
 	}
-	return nil
+
+	if err := os.MkdirAll(d.logDir, 0755); err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf(
+		"%s-%s-%s.log",
+		strings.TrimSuffix(filepath.Base(playbook), filepath.Ext(playbook)),
+		nodePublicName,
+		time.Now().UTC().Format("20060102T150405Z"),
+	)
+	return os.Create(filepath.Join(d.logDir, filename))
 }
 
-type ansibleVars struct {
-	InventoryHostname string   `json:"inventory_hostname,omitempty"`
-	PublicName        string   `json:"node_public_name"`
-	Binary            string   `json:"node_binary,omitempty"`
-	CustomOptions     []string `json:"node_custom_options"`
-	ForceWipe         bool     `json:"node_force_wipe,omitempty"`
+// coreVars are set from the fields of burnin.PlaybookRun. Extra vars must neither replace them nor Ansible's own
+// variables (e.g. "ansible_host"), which could point the playbook to another host.
+var coreVars = map[string]bool{
+	"inventory_hostname":   true,
+	"node_public_name":     true,
+	"node_binary":          true,
+	"node_binary_checksum": true,
+	"node_custom_options":  true,
+	"node_force_wipe":      true,
 }
 
-func buildArgs(
-	playbook string,
-	runOn string,
-	nodeBinary *url.URL,
-	wipeChainDB bool,
-	nodePublicName string,
-	customOptions []string,
-) ([]string, error) {
-	args := []string{playbook, "-i", "inventory.yaml", "-l", nodePublicName}
-
-	if runOn == "localhost" {
+var varName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// buildArgs returns the arguments of ansible-playbook, which include all variables except the sensitive ones. These are
+// returned separately.
+func buildArgs(run burnin.PlaybookRun, sensitive []*regexp.Regexp) ([]string, map[string]interface{}, error) {
+	args := []string{run.Playbook, "-i", "inventory.yaml", "-l", run.NodePublicName}
+
+	if run.RunOn == "localhost" {
 		args = append(
 			args,
 			"--connection=local",
@@ -142,39 +286,55 @@ func buildArgs(
 		)
 	}
 
-	vars := buildVars(runOn, nodeBinary, wipeChainDB, nodePublicName, customOptions)
+	vars, err := buildVars(run)
+	if err != nil {
+		return nil, nil, err
+	}
+	secrets := splitVars(vars, sensitive, run.NodeBinary)
+
 	b, err := json.Marshal(vars)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return append(args, "-e", string(b)), nil
+	return append(args, "-e", string(b)), secrets, nil
 }
 
-func buildVars(
-	runOn string,
-	nodeBinary *url.URL,
-	wipeChainDB bool,
-	nodePublicName string,
-	customOptions []string,
-) ansibleVars {
-	vars := ansibleVars{
-		PublicName:    nodePublicName,
-		ForceWipe:     wipeChainDB,
-		CustomOptions: []string{},
+func buildVars(run burnin.PlaybookRun) (map[string]interface{}, error) {
+	vars := map[string]interface{}{
+		"node_public_name":    run.NodePublicName,
+		"node_custom_options": []string{},
+	}
+
+	if run.NodeBinary != nil {
+		vars["node_binary"] = run.NodeBinary.String()
+	}
+
+	if run.BinaryChecksum != "" {
+		vars["node_binary_checksum"] = run.BinaryChecksum
+	}
+
+	if run.CustomOptions != nil {
+		vars["node_custom_options"] = run.CustomOptions
 	}
 
-	if nodeBinary != nil {
-		vars.Binary = nodeBinary.String()
+	if run.WipeChainDB {
+		vars["node_force_wipe"] = true
 	}
 
-	if customOptions != nil {
-		vars.CustomOptions = customOptions
+	if run.RunOn == "localhost" {
+		vars["inventory_hostname"] = run.NodePublicName
 	}
 
-	if runOn == "localhost" {
-		vars.InventoryHostname = nodePublicName
+	for name, value := range run.ExtraVars {
+		if !varName.MatchString(name) {
+			return nil, fmt.Errorf("invalid extra var name '%s'", name)
+		}
+		if coreVars[name] || strings.HasPrefix(strings.ToLower(name), "ansible_") {
+			return nil, fmt.Errorf("extra var '%s' must not replace a variable of the driver or Ansible", name)
+		}
+		vars[name] = value
 	}
 
-	return vars
+	return vars, nil
 }
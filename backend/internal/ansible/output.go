@@ -0,0 +1,185 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// tailLines is how many lines of each stream end up in the error of a failed playbook run.
+const tailLines = 40
+
+// output passes the lines ansible-playbook writes to stdout and stderr on to the job log and the log file as they
+// arrive. Only the last lines of each stream are kept in memory.
+type output struct {
+	prefix string            // in front of every line in the job log, e.g. "deploy.yml/kusama-fullnode-uw1-0"
+	file   io.Writer         // receives the full output, nil if it is not kept
+	redact *strings.Replacer // hides the values of sensitive variables, nil if there are none
+
+	mu             sync.Mutex
+	stdout, stderr tail
+	noHostsMatched bool
+	warnings       []string
+}
+
+func newOutput(prefix string, file io.Writer) *output {
+	return &output{
+		prefix: prefix,
+		file:   file,
+		stdout: tail{max: tailLines},
+		stderr: tail{max: tailLines},
+	}
+}
+
+// readAll reads stdout and stderr concurrently, so that neither pipe fills up while the other one is read, and returns
+// once both are closed.
+func (o *output) readAll(stdout, stderr io.Reader) error {
+	var (
+		wg   sync.WaitGroup
+		errs [2]error
+	)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = o.read("stdout", stdout, &o.stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = o.read("stderr", stderr, &o.stderr)
+	}()
+	wg.Wait()
+
+	if errs[0] != nil {
+		return errs[0]
+	}
+	return errs[1]
+}
+
+func (o *output) read(stream string, r io.Reader, t *tail) error {
+	reader := bufio.NewReader(r)
+	for {
+		// ReadString copes with lines of any length, unlike bufio.Scanner which stops at long lines.
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			o.line(stream, t, strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (o *output) line(stream string, t *tail, line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.redact != nil {
+		line = o.redact.Replace(line)
+	}
+
+	if o.file != nil {
+		fmt.Fprintf(o.file, "%s %s | %s\n", time.Now().UTC().Format(time.RFC3339), stream, line)
+	}
+
+	log.Printf("%s %s | %s\n", o.prefix, stream, line)
+	t.add(line)
+	if stream == "stdout" && strings.Contains(line, "no hosts matched") {
+		o.noHostsMatched = true
+	}
+	if stream == "stderr" && isWarning(line) {
+		o.warnings = append(o.warnings, line)
+	}
+}
+
+// result returns the error of the playbook run, if any. The report of the result callback (nil if there is none)
+// turns a failed run into a burnin.PlaybookError. Runs without any host are burnin.ErrNoHostsMatched. Warnings fail the
+// run unless the policy allows them.
+func (o *output) result(playbook string, err error, report []byte, policy WarningPolicy, logFile *os.File) error {
+	var result burnin.PlaybookResult
+	if report != nil {
+		if o.redact != nil {
+			report = []byte(o.redact.Replace(string(report)))
+		}
+		var parseErr error
+		if result, parseErr = parseResult(report); parseErr != nil {
+			log.Printf("%s %v\n", o.prefix, parseErr)
+		} else {
+			o.noHostsMatched = o.noHostsMatched || len(result.Stats) == 0
+		}
+	}
+
+	denied, allowed := policy.split(o.warnings)
+	for _, w := range allowed {
+		log.Printf("%s allowed warning: %s\n", o.prefix, w)
+	}
+
+	// Ignored and rescued failures leave the exit status and the recap alone.
+	switch {
+	case (err != nil || result.Failed()) && len(result.Failures()) > 0:
+		return &burnin.PlaybookError{Playbook: playbook, Result: result, Err: err}
+	case err != nil:
+		return o.error(err, logFile)
+	case o.noHostsMatched:
+		return o.error(burnin.ErrNoHostsMatched, logFile)
+	case len(denied) > 0:
+		return o.error(fmt.Errorf("%d warning(s): %s", len(denied), strings.Join(denied, "; ")), logFile)
+	}
+	return nil
+}
+
+// error contains the last lines of both streams, the full output is in the log file (if any).
+func (o *output) error(err error, logFile *os.File) error {
+	fullOutput := ""
+	if logFile != nil {
+		fullOutput = fmt.Sprintf("full output: %s\n", logFile.Name())
+	}
+	return fmt.Errorf("err: %w\nstdout: %s\nstderr: %s\n%s", err, o.stdout.String(), o.stderr.String(), fullOutput)
+}
+
+// tail keeps the last max lines of a stream.
+type tail struct {
+	max     int
+	lines   []string
+	dropped int
+}
+
+func (t *tail) add(line string) {
+	if len(t.lines) == t.max {
+		t.lines = append(t.lines[:0], t.lines[1:]...)
+		t.dropped++
+	}
+	t.lines = append(t.lines, line)
+}
+
+func (t *tail) String() string {
+	if t.dropped == 0 {
+		return strings.Join(t.lines, "\n")
+	}
+	return fmt.Sprintf("[%d earlier lines omitted]\n%s", t.dropped, strings.Join(t.lines, "\n"))
+}
@@ -0,0 +1,29 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on Windows, where the driver can only kill ansible-playbook itself.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup kills the command, whatever the signal.
+func signalProcessGroup(cmd *exec.Cmd, _ syscall.Signal) error {
+	return cmd.Process.Kill()
+}
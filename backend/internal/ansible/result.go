@@ -0,0 +1,161 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	_ "embed" // for the callback plugin
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// resultCallback is an Ansible callback plugin, which writes the results of a playbook run to the file named by the
+// environment variable BURNIN_RESULT_FILE in the format of the "json" stdout callback. The usual stdout callback keeps
+// streaming the output meanwhile.
+//
+//go:embed callback/burnin_result.py
+var resultCallback []byte
+
+// resultCallbackEnv writes the callback plugin to dir and returns the environment loading it into ansible-playbook,
+// together with the file the results are written to. The file does not exist if the run ended before the recap.
+func resultCallbackEnv(dir string) ([]string, string, error) {
+	if err := ioutil.WriteFile(filepath.Join(dir, "burnin_result.py"), resultCallback, 0644); err != nil {
+		return nil, "", err
+	}
+
+	// ANSIBLE_CALLBACK_PLUGINS replaces the "callback_plugins" path of ansible.cfg, so it has to extend an own one.
+	plugins := dir
+	if path := os.Getenv("ANSIBLE_CALLBACK_PLUGINS"); path != "" {
+		plugins += string(os.PathListSeparator) + path
+	}
+	resultFile := filepath.Join(dir, "result.json")
+	return []string{"ANSIBLE_CALLBACK_PLUGINS=" + plugins, "BURNIN_RESULT_FILE=" + resultFile}, resultFile, nil
+}
+
+// report is what the result callback writes, which is the output of Ansible's "json" stdout callback, plus "ignored"
+// for failures of tasks with "ignore_errors".
+type report struct {
+	Plays []struct {
+		Tasks []struct {
+			Task struct {
+				Name     string   `json:"name"`
+				Duration duration `json:"duration"`
+			} `json:"task"`
+			Hosts map[string]hostResult `json:"hosts"`
+		} `json:"tasks"`
+	} `json:"plays"`
+	Stats map[string]burnin.HostStats `json:"stats"`
+}
+
+type hostResult struct {
+	Changed     bool            `json:"changed"`
+	Failed      bool            `json:"failed"`
+	Unreachable bool            `json:"unreachable"`
+	Skipped     bool            `json:"skipped"`
+	Ignored     bool            `json:"ignored"`
+	Msg         json.RawMessage `json:"msg"` // usually a string, sometimes a list or an object
+	Stderr      string          `json:"stderr"`
+}
+
+type duration struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// parseResult turns the report written by the result callback into a PlaybookResult.
+func parseResult(data []byte) (burnin.PlaybookResult, error) {
+	var r report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return burnin.PlaybookResult{}, fmt.Errorf("parsing the playbook results failed: %v", err)
+	}
+
+	result := burnin.PlaybookResult{Stats: r.Stats}
+	for _, play := range r.Plays {
+		for _, task := range play.Tasks {
+			hosts := make([]string, 0, len(task.Hosts))
+			for host := range task.Hosts {
+				hosts = append(hosts, host)
+			}
+			sort.Strings(hosts)
+
+			for _, host := range hosts {
+				h := task.Hosts[host]
+				result.Tasks = append(result.Tasks, burnin.TaskResult{
+					Task:        task.Task.Name,
+					Host:        host,
+					Changed:     h.Changed,
+					Failed:      h.Failed,
+					Unreachable: h.Unreachable,
+					Skipped:     h.Skipped,
+					Ignored:     h.Ignored,
+					Msg:         h.message(),
+					Duration:    task.Task.Duration.elapsed(),
+				})
+			}
+		}
+	}
+	markRescued(&result)
+
+	return result, nil
+}
+
+// markRescued marks the failures which a "rescue" section handled, as Ansible only counts them in the recap. A host
+// stops at the first failure which is neither ignored nor rescued, so only the last ones counted as failures in the
+// recap of the host were not rescued.
+func markRescued(result *burnin.PlaybookResult) {
+	for host, stats := range result.Stats {
+		var failed []int
+		for i, t := range result.Tasks {
+			if t.Host == host && t.Failed && !t.Ignored {
+				failed = append(failed, i)
+			}
+		}
+		for n := 0; n < len(failed)-stats.Failures; n++ {
+			result.Tasks[failed[n]].Rescued = true
+		}
+	}
+}
+
+// message prefers "msg" over "stderr", which is what failed commands have.
+func (h hostResult) message() string {
+	var msg string
+	if len(h.Msg) > 0 && json.Unmarshal(h.Msg, &msg) != nil {
+		msg = string(h.Msg)
+	}
+	if msg == "" {
+		msg = h.Stderr
+	}
+	return strings.TrimSpace(msg)
+}
+
+func (d duration) elapsed() time.Duration {
+	start, err := time.Parse(time.RFC3339Nano, d.Start)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse(time.RFC3339Nano, d.End)
+	if err != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func Test_parseResult(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/failed.json")
+	require.NoError(t, err)
+
+	result, err := parseResult(data)
+	require.NoError(t, err)
+	require.Equal(t, burnin.PlaybookResult{
+		Tasks: []burnin.TaskResult{
+			{Task: "Gathering Facts", Host: "kusama-fullnode-uw1-0", Duration: 2500 * time.Millisecond},
+			{Task: "Download binary", Host: "kusama-fullnode-uw1-0", Changed: true, Duration: 37500 * time.Millisecond},
+			{
+				Task:     "Restart node",
+				Host:     "kusama-fullnode-uw1-0",
+				Changed:  true,
+				Failed:   true,
+				Msg:      "non-zero return code",
+				Duration: 25 * time.Second,
+			},
+		},
+		Stats: map[string]burnin.HostStats{
+			"kusama-fullnode-uw1-0": {OK: 2, Changed: 2, Failures: 1},
+		},
+	}, result)
+
+	err = &burnin.PlaybookError{Playbook: "kusama-nodes.yml", Result: result}
+	require.Equal(t, "task 'Restart node' failed on kusama-fullnode-uw1-0: non-zero return code", err.Error())
+
+	// messages which are not strings, and failed commands without a message
+	result, err = parseResult([]byte(`{"plays": [{"tasks": [{"task": {"name": "Check"}, "hosts": {
+		"b": {"unreachable": true, "msg": ["Failed to connect", "timeout"]},
+		"a": {"failed": true, "stderr": " No such file\n"}
+	}}]}]}`))
+	require.NoError(t, err)
+	require.Len(t, result.Tasks, 2)
+	require.Equal(t, "No such file", result.Tasks[0].Msg)
+	require.Equal(t, `["Failed to connect", "timeout"]`, result.Tasks[1].Msg)
+
+	err = &burnin.PlaybookError{Playbook: "kusama-nodes.yml", Result: result}
+	require.Equal(t, "task 'Check' failed on a: No such file (and 1 more failure(s))", err.Error())
+
+	_, err = parseResult([]byte("{"))
+	require.Error(t, err)
+}
+
+func Test_parseResult_ignoredAndRescued(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ignored.json")
+	require.NoError(t, err)
+
+	result, err := parseResult(data)
+	require.NoError(t, err)
+	require.Len(t, result.Tasks, 3)
+	require.True(t, result.Tasks[0].Failed)
+	require.True(t, result.Tasks[0].Ignored)
+	require.False(t, result.Tasks[0].Rescued)
+	require.True(t, result.Tasks[1].Failed)
+	require.True(t, result.Tasks[1].Rescued)
+	require.Empty(t, result.Failures())
+	require.False(t, result.Failed())
+
+	// the failure which stopped the host is the last one
+	result.Stats["kusama-fullnode-uw1-0"] = burnin.HostStats{Failures: 1}
+	result.Tasks[1].Rescued = false
+	markRescued(&result)
+	require.False(t, result.Tasks[1].Rescued)
+	require.Len(t, result.Failures(), 1)
+	require.True(t, result.Failed())
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultSensitiveVars match the names of variables holding keys, tokens and credentials, e.g. "node_key" or
+// "telemetry_token".
+var DefaultSensitiveVars = []string{`(?i)(^|_)(key|token|secret|passw(or)?d|credentials?|auth)(_|$)`}
+
+// redacted replaces the values of sensitive variables in the output of ansible-playbook.
+const redacted = "[redacted]"
+
+// minSecretLength is the length below which values are not redacted.
+const minSecretLength = 4
+
+// NewSensitiveVars compiles the patterns for Options.SensitiveVars.
+func NewSensitiveVars(patterns []string) ([]*regexp.Regexp, error) {
+	return compilePatterns("sensitive var", patterns)
+}
+
+// splitVars moves the variables whose name matches one of the patterns out of vars. "node_binary" counts as sensitive
+// if the URL carries credentials, either as user info or in the query (e.g. "?private_token=...").
+func splitVars(vars map[string]interface{}, patterns []*regexp.Regexp, binary *url.URL) map[string]interface{} {
+	sensitive := make(map[string]interface{})
+	for name, value := range vars {
+		if matchesAny(patterns, name) || (name == "node_binary" && binary != nil && hasCredentials(binary)) {
+			sensitive[name] = value
+			delete(vars, name)
+		}
+	}
+	return sensitive
+}
+
+// hasCredentials reports whether u has any of the parts burnin.RedactURL removes.
+func hasCredentials(u *url.URL) bool {
+	return u.User != nil || u.RawQuery != ""
+}
+
+// writeVarsFile writes the variables to a file only readable by the current user, which ansible-playbook reads with
+// "-e @<file>". The caller removes the file.
+func writeVarsFile(vars map[string]interface{}) (string, error) {
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+
+	// CreateTemp creates the file with mode 0600, before anything is written to it.
+	f, err := os.CreateTemp("", "burnin-vars-*.json")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// newRedactor returns a replacer hiding the values of the variables, as well as the password and query values of
+// "node_binary", which Ansible modules may print on their own (e.g. as "url_password"). It returns nil if there is
+// nothing to hide. Values shorter than minSecretLength are kept, as replacing them would garble unrelated output.
+func newRedactor(vars map[string]interface{}) *strings.Replacer {
+	var secrets []string
+	for name, value := range vars {
+		secrets = appendSecrets(secrets, value)
+
+		if name != "node_binary" {
+			continue
+		}
+		u, err := url.Parse(fmt.Sprint(value))
+		if err != nil {
+			continue
+		}
+		if u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				secrets = append(secrets, password, url.QueryEscape(password))
+			}
+		}
+		for _, values := range u.Query() {
+			secrets = append(secrets, values...)
+		}
+	}
+	// Longer values first, so that a value containing another one is replaced as a whole.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var oldnew []string
+	for _, s := range secrets {
+		if len(s) >= minSecretLength {
+			oldnew = append(oldnew, s, redacted)
+		}
+	}
+	if len(oldnew) == 0 {
+		return nil
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// appendSecrets appends the string values of value. Numbers and booleans are skipped, since replacing e.g. every "1"
+// or "true" in the output would hide more than it protects.
+func appendSecrets(secrets []string, value interface{}) []string {
+	switch v := value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return secrets
+	case []interface{}:
+		for _, item := range v {
+			secrets = appendSecrets(secrets, item)
+		}
+		return secrets
+	case []string:
+		return append(secrets, v...)
+	default:
+		return append(secrets, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// WarningPolicy decides which warnings of ansible-playbook fail a playbook run. A warning fails the run if it matches
+// a pattern in Deny or none in Allow. The zero value fails the run on any warning.
+type WarningPolicy struct {
+	Allow []*regexp.Regexp
+	Deny  []*regexp.Regexp
+}
+
+// DefaultAllowedWarnings allows every warning not denied by DefaultDeniedWarnings.
+var DefaultAllowedWarnings = []string{"."}
+
+// DefaultDeniedWarnings are warnings about hosts and the inventory. They mean the playbook did not run where it
+// should have.
+var DefaultDeniedWarnings = []string{
+	`Could not match supplied host pattern`,
+	`provided hosts list is empty`,
+	`No inventory was parsed`,
+	`Unable to parse .* as an inventory source`,
+	`Failed to parse .* with .* plugin`,
+	`[Ii]nventory .* (is empty|not found|does not exist)`,
+}
+
+// NewWarningPolicy compiles the patterns.
+func NewWarningPolicy(allow, deny []string) (WarningPolicy, error) {
+	var (
+		policy WarningPolicy
+		err    error
+	)
+
+	if policy.Allow, err = compilePatterns("warning", allow); err != nil {
+		return WarningPolicy{}, err
+	}
+	if policy.Deny, err = compilePatterns("warning", deny); err != nil {
+		return WarningPolicy{}, err
+	}
+	return policy, nil
+}
+
+// DefaultWarningPolicy only fails playbook runs on DefaultDeniedWarnings.
+func DefaultWarningPolicy() WarningPolicy {
+	policy, err := NewWarningPolicy(DefaultAllowedWarnings, DefaultDeniedWarnings)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func compilePatterns(kind string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern '%s': %v", kind, p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// split separates the warnings which fail the run from the ones which are only reported.
+func (p WarningPolicy) split(warnings []string) (denied, allowed []string) {
+	for _, w := range warnings {
+		if matchesAny(p.Deny, w) || !matchesAny(p.Allow, w) {
+			denied = append(denied, w)
+		} else {
+			allowed = append(allowed, w)
+		}
+	}
+	return denied, allowed
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWarning tells whether a line on stderr starts a warning, e.g. "[WARNING]: ..." or "[DEPRECATION WARNING]: ...".
+func isWarning(line string) bool {
+	return strings.Contains(line, "[WARNING]") || strings.Contains(line, "[DEPRECATION WARNING]")
+}
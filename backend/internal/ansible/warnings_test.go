@@ -0,0 +1,49 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package ansible
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarningPolicy_split(t *testing.T) {
+	warnings := []string{
+		"[WARNING]: Could not match supplied host pattern, ignoring: kusama-fullnode-uw1-0",
+		"[WARNING]: Unable to parse /ansible/inventory/kusama.yml as an inventory source",
+		"[WARNING]: provided hosts list is empty, only localhost is available",
+		"[DEPRECATION WARNING]: The 'warn' parameter is deprecated.",
+		"[WARNING]: Consider using the get_url or uri module rather than running 'curl'.",
+	}
+
+	denied, allowed := DefaultWarningPolicy().split(warnings)
+	require.Equal(t, warnings[:3], denied)
+	require.Equal(t, warnings[3:], allowed)
+
+	policy, err := NewWarningPolicy([]string{`^\[DEPRECATION WARNING\]`, ""}, nil)
+	require.NoError(t, err)
+	denied, allowed = policy.split(warnings)
+	require.Equal(t, append(warnings[:3:3], warnings[4]), denied)
+	require.Equal(t, warnings[3:4], allowed)
+
+	denied, allowed = WarningPolicy{}.split(warnings)
+	require.Equal(t, warnings, denied)
+	require.Empty(t, allowed)
+
+	_, err = NewWarningPolicy(nil, []string{"("})
+	require.EqualError(t, err, "invalid warning pattern '(': error parsing regexp: missing closing ): `(`")
+}
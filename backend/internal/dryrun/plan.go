@@ -0,0 +1,71 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package dryrun replaces the side effects of jobs with entries in a plan. Reads still go to the real services, so
+// the plan shows what the job would have done given the current state.
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Effect is a side effect which a job skipped.
+type Effect struct {
+	Action  string `json:"action"`            // e.g. "update file", "pause runner"
+	Target  string `json:"target"`            // e.g. the path of a file or a hostname
+	Details string `json:"details,omitempty"` // e.g. the commit message
+	Content string `json:"content,omitempty"` // of files, only in the JSON plan
+}
+
+func (e Effect) String() string {
+	if e.Details == "" {
+		return fmt.Sprintf("%s %s", e.Action, e.Target)
+	}
+	return fmt.Sprintf("%s %s (%s)", e.Action, e.Target, e.Details)
+}
+
+// Plan lists the side effects of a job in the order it would have caused them.
+type Plan struct {
+	Command string   `json:"command"` // e.g. "deploy"
+	Effects []Effect `json:"effects"`
+}
+
+func NewPlan(command string) *Plan {
+	return &Plan{Command: command, Effects: []Effect{}}
+}
+
+func (p *Plan) add(e Effect) {
+	log.Printf("dry run: skipping %s\n", e)
+	p.Effects = append(p.Effects, e)
+}
+
+// Text returns the plan as numbered list.
+func (p *Plan) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "dry run of '%s' skipped %d side effect(s)\n", p.Command, len(p.Effects))
+	for i, e := range p.Effects {
+		fmt.Fprintf(&b, "%3d. %s\n", i+1, e)
+	}
+	return b.String()
+}
+
+// JSON returns the plan as indented JSON document.
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package dryrun
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	plan := NewPlan("cleanup")
+	plan.add(Effect{Action: "unpause runner", Target: "kusama-fullnode-uw1-0"})
+	plan.add(Effect{Action: "delete file", Target: "runs/run-kusama-fullnode-0-1.toml", Details: "[skip ci] cleanup"})
+
+	require.Equal(
+		t,
+		"dry run of 'cleanup' skipped 2 side effect(s)\n"+
+			"  1. unpause runner kusama-fullnode-uw1-0\n"+
+			"  2. delete file runs/run-kusama-fullnode-0-1.toml ([skip ci] cleanup)\n",
+		plan.Text(),
+	)
+
+	b, err := plan.JSON()
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "command": "cleanup",
+  "effects": [
+    {"action": "unpause runner", "target": "kusama-fullnode-uw1-0"},
+    {"action": "delete file", "target": "runs/run-kusama-fullnode-0-1.toml", "details": "[skip ci] cleanup"}
+  ]
+}`, string(b))
+
+	b, err = NewPlan("refresh").JSON()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"command": "refresh", "effects": []}`, string(b))
+}
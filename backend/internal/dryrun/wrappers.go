@@ -0,0 +1,206 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package dryrun
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// SilenceID is returned for silences which were not created.
+const SilenceID = "dry-run"
+
+// Gitlab skips changes to the repository and the runners.
+type Gitlab struct {
+	burnin.Gitlab
+	Plan *Plan
+}
+
+func (g Gitlab) CreateBranch(name, fromBranch string) error {
+	g.Plan.add(Effect{Action: "create branch", Target: name, Details: "from " + fromBranch})
+	return nil
+}
+
+func (g Gitlab) CreateFile(path, branch, commitMsg string, content []byte) error {
+	g.Plan.add(Effect{Action: "create file", Target: onBranch(path, branch), Details: commitMsg, Content: string(content)})
+	return nil
+}
+
+func (g Gitlab) UpdateFile(path, branch, commitMsg string, content []byte) error {
+	g.Plan.add(Effect{Action: "update file", Target: onBranch(path, branch), Details: commitMsg, Content: string(content)})
+	return nil
+}
+
+func (g Gitlab) DeleteFile(path, branch, commitMsg string) error {
+	g.Plan.add(Effect{Action: "delete file", Target: onBranch(path, branch), Details: commitMsg})
+	return nil
+}
+
+func (g Gitlab) CreateMergeRequest(title, sourceBranch, targetBranch string) (burnin.MergeRequest, error) {
+	g.Plan.add(Effect{Action: "create merge request", Target: sourceBranch + " -> " + targetBranch, Details: title})
+	return burnin.MergeRequest{}, nil
+}
+
+func (g Gitlab) StartJob(jobID int) error {
+	g.Plan.add(Effect{Action: "start job", Target: fmt.Sprint(jobID)})
+	return nil
+}
+
+func (g Gitlab) PauseRunner(hostname string) error {
+	g.Plan.add(Effect{Action: "pause runner", Target: hostname})
+	return nil
+}
+
+func (g Gitlab) UnPauseRunner(hostname string) error {
+	g.Plan.add(Effect{Action: "unpause runner", Target: hostname})
+	return nil
+}
+
+func onBranch(path, branch string) string {
+	return fmt.Sprintf("%s on branch '%s'", path, branch)
+}
+
+// Alertmanagers skips changes to silences in every Alertmanager.
+type Alertmanagers struct {
+	burnin.Alertmanagers
+	Plan *Plan
+}
+
+func (a Alertmanagers) ForNetwork(network string) burnin.Alertmanager {
+	return Alertmanager{Alertmanager: a.Alertmanagers.ForNetwork(network), Plan: a.Plan, network: network}
+}
+
+// Alertmanager skips changes to silences. Silences it did not create are looked up as usual.
+type Alertmanager struct {
+	burnin.Alertmanager
+	Plan    *Plan
+	network string
+}
+
+func (a Alertmanager) CreateSilence(
+	matchers []burnin.AlertMatcher,
+	startsAt, endsAt time.Time,
+	createdBy, comment string,
+) (string, error) {
+	a.Plan.add(Effect{
+		Action:  "create silence",
+		Target:  a.target(matchers),
+		Details: fmt.Sprintf("until %s: %s", endsAt.Format(time.RFC3339), comment),
+	})
+	return SilenceID, nil
+}
+
+func (a Alertmanager) UpdateSilence(silence burnin.Silence) (string, error) {
+	a.Plan.add(Effect{
+		Action:  "update silence",
+		Target:  a.target(silence.Matchers),
+		Details: fmt.Sprintf("%s until %s", silence.ID, silence.EndsAt.Format(time.RFC3339)),
+	})
+	return silence.ID, nil
+}
+
+func (a Alertmanager) DeleteSilence(id string) error {
+	a.Plan.add(Effect{Action: "expire silence", Target: id, Details: a.network})
+	return nil
+}
+
+func (a Alertmanager) target(matchers []burnin.AlertMatcher) string {
+	var parts []string
+	for _, m := range matchers {
+		op := "="
+		if m.IsRegex {
+			op = "=~"
+		}
+		parts = append(parts, fmt.Sprintf("%s%s%q", m.Name, op, m.Value))
+	}
+	return fmt.Sprintf("{%s} on %s", strings.Join(parts, ","), a.network)
+}
+
+// DeploymentDriver records the playbook runs and passes them on to a driver running playbooks in check mode.
+type DeploymentDriver struct {
+	burnin.DeploymentDriver
+	Plan *Plan
+}
+
+func (d DeploymentDriver) RunPlaybook(run burnin.PlaybookRun) error {
+	details := []string{"on " + run.RunOn}
+	if run.NodeBinary != nil {
+		details = append(details, "binary "+burnin.RedactURL(run.NodeBinary))
+	}
+	if run.WipeChainDB {
+		details = append(details, "wiping the chain database")
+	}
+	if len(run.CustomOptions) > 0 {
+		details = append(details, "options "+strings.Join(run.CustomOptions, " "))
+	}
+	if len(run.ExtraVars) > 0 {
+		details = append(details, fmt.Sprintf("extra vars %v", run.ExtraVars))
+	}
+	d.Plan.add(Effect{
+		Action:  "run playbook",
+		Target:  run.Playbook + " for " + run.NodePublicName,
+		Details: strings.Join(details, ", "),
+	})
+
+	return d.DeploymentDriver.RunPlaybook(run)
+}
+
+// Notifier skips all notifications.
+type Notifier struct {
+	Plan *Plan
+}
+
+func (n Notifier) SendRequestNotification(request burnin.Request) (string, error) {
+	n.Plan.add(Effect{Action: "send request notification", Target: request.PullRequest})
+	return "", nil
+}
+
+func (n Notifier) SendDeploymentNotification(deployment burnin.Deployment) error {
+	n.Plan.add(Effect{Action: "send deployment notification", Target: deployment.Filename})
+	return nil
+}
+
+func (n Notifier) SendUpdateNotification(deployment burnin.Deployment) error {
+	n.Plan.add(Effect{Action: "send update notification", Target: deployment.Filename})
+	return nil
+}
+
+func (n Notifier) SendCleanupNotification(deployment burnin.Deployment) error {
+	n.Plan.add(Effect{Action: "send cleanup notification", Target: deployment.Filename})
+	return nil
+}
+
+func (n Notifier) SendRollbackNotification(deployment burnin.Deployment) error {
+	n.Plan.add(Effect{Action: "send rollback notification", Target: deployment.Filename})
+	return nil
+}
+
+func (n Notifier) SendErrorNotification(err error) error {
+	n.Plan.add(Effect{Action: "send error notification", Target: firstLine(err.Error())})
+	return nil
+}
+
+func (n Notifier) SendDigestNotification(digest burnin.Digest) error {
+	n.Plan.add(Effect{Action: "send digest notification", Target: fmt.Sprintf("%d burn-in(s)", len(digest.BurnIns))})
+	return nil
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package dryrun
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+type fakeGitlab struct {
+	burnin.Gitlab
+}
+
+func (fakeGitlab) GetFile(path, branch string) ([]byte, error) {
+	return []byte("content of " + path), nil
+}
+
+type fakeAlertmanagers struct {
+	burnin.Alertmanager
+	silences []burnin.Silence
+}
+
+func (a *fakeAlertmanagers) ForNetwork(network string) burnin.Alertmanager {
+	return a
+}
+
+func (a *fakeAlertmanagers) GetSilences() ([]burnin.Silence, error) {
+	return a.silences, nil
+}
+
+type fakeDeploymentDriver struct {
+	runs []string
+}
+
+func (d *fakeDeploymentDriver) RunPlaybook(run burnin.PlaybookRun) error {
+	d.runs = append(d.runs, run.Playbook+"/"+run.NodePublicName)
+	return nil
+}
+
+func TestWrappers(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	plan := NewPlan("deploy")
+
+	gitlab := Gitlab{Gitlab: fakeGitlab{}, Plan: plan}
+	content, err := gitlab.GetFile("runs/run-kusama-fullnode-0-1.toml", "master")
+	require.NoError(t, err)
+	require.Equal(t, "content of runs/run-kusama-fullnode-0-1.toml", string(content), "reads are passed on")
+	require.NoError(t, gitlab.UpdateFile("runs/run-kusama-fullnode-0-1.toml", "master", "[skip ci] deployed", []byte("a=1")))
+	require.NoError(t, gitlab.PauseRunner("kusama-fullnode-uw1-0"))
+
+	silences := []burnin.Silence{{ID: "existing"}}
+	alertmanager := Alertmanagers{Alertmanagers: &fakeAlertmanagers{silences: silences}, Plan: plan}.ForNetwork("kusama")
+	existing, err := alertmanager.GetSilences()
+	require.NoError(t, err)
+	require.Equal(t, silences, existing)
+	id, err := alertmanager.CreateSilence(
+		[]burnin.AlertMatcher{{Name: "instance", Value: ".*kusama-fullnode-uw1-0.*", IsRegex: true}},
+		time.Now(),
+		time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		"Burn-in Automator",
+		"Deploying burn-in",
+	)
+	require.NoError(t, err)
+	require.Equal(t, SilenceID, id)
+	require.NoError(t, alertmanager.DeleteSilence(id))
+
+	driver := &fakeDeploymentDriver{}
+	binary, _ := url.Parse("https://example.com/polkadot")
+	require.NoError(t, DeploymentDriver{DeploymentDriver: driver, Plan: plan}.RunPlaybook(burnin.PlaybookRun{
+		Playbook:       "kusama-nodes.yml",
+		RunOn:          "localhost",
+		NodePublicName: "kusama-fullnode-uw1-0",
+		NodeBinary:     binary,
+		WipeChainDB:    true,
+	}))
+	require.Equal(t, []string{"kusama-nodes.yml/kusama-fullnode-uw1-0"}, driver.runs, "playbooks run in check mode")
+
+	notifier := Notifier{Plan: plan}
+	require.NoError(t, notifier.SendErrorNotification(errors.New("playbook failed\nstdout: ...")))
+
+	require.Equal(t, []Effect{
+		{
+			Action:  "update file",
+			Target:  "runs/run-kusama-fullnode-0-1.toml on branch 'master'",
+			Details: "[skip ci] deployed",
+			Content: "a=1",
+		},
+		{Action: "pause runner", Target: "kusama-fullnode-uw1-0"},
+		{
+			Action:  "create silence",
+			Target:  `{instance=~".*kusama-fullnode-uw1-0.*"} on kusama`,
+			Details: "until 2022-01-02T03:04:05Z: Deploying burn-in",
+		},
+		{Action: "expire silence", Target: "dry-run", Details: "kusama"},
+		{
+			Action:  "run playbook",
+			Target:  "kusama-nodes.yml for kusama-fullnode-uw1-0",
+			Details: "on localhost, binary https://example.com/polkadot, wiping the chain database",
+		},
+		{Action: "send error notification", Target: "playbook failed"},
+	}, plan.Effects)
+}
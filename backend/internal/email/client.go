@@ -0,0 +1,168 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+)
+
+// Client sends notifications as plain text emails through an SMTP server.
+type Client struct {
+	addr string // host:port of the SMTP server
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewClient returns a client which authenticates with PLAIN auth if username is not empty. Go's PLAIN auth refuses to
+// send credentials over unencrypted connections to anything but localhost, so the server has to support STARTTLS.
+func NewClient(addr, username, password, from string, to []string) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(to) == 0 {
+		return nil, fmt.Errorf("no recipients for emails via %s", addr)
+	}
+
+	c := &Client{
+		addr: addr,
+		from: from,
+		to:   to,
+	}
+
+	if username != "" {
+		c.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return c, nil
+}
+
+func (c *Client) Send(event notify.Event) error {
+	tmpl, ok := templates[event.Name]
+	if !ok {
+		return fmt.Errorf("unknown event '%s'", event.Name)
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(subject, "subject", event); err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(body, "body", event); err != nil {
+		return err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", c.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(c.to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+
+	return smtp.SendMail(c.addr, c.auth, c.from, c.to, msg.Bytes())
+}
+
+func parse(name, subject, body string) *template.Template {
+	funcs := template.FuncMap{"short": notify.ShortPullRequest}
+	tmpl := template.Must(template.New(name).Funcs(funcs).Parse(`{{define "subject"}}` + subject + `{{end}}`))
+	return template.Must(tmpl.Parse(`{{define "body"}}` + body + `{{end}}`))
+}
+
+const footer = `
+Pull request: {{.PullRequestURL}}
+Requested by: {{.RequestedBy}}
+CI job: {{.JobURL}}
+`
+
+const deploymentDetails = `
+Node: {{.Deployment.Network}} {{.Deployment.NodeType}} on {{.Deployment.DeployedOn}}
+Binary: {{.Deployment.CustomBinary}}
+{{- with .Deployment.LogViewer}}
+Logs: {{.}}{{end}}
+{{- with .Deployment.Health}}
+Health check: {{.Summary}}{{end}}
+`
+
+var templates = map[string]*template.Template{
+	"request": parse("request",
+		`[burn-in] Request for {{.PullRequest}}`,
+		`The burn-in request for {{.PullRequest}} has been processed.
+`+footer),
+	"deployment": parse("deployment",
+		`[burn-in] Deployed {{.PullRequest}} on {{.Deployment.DeployedOn}}`,
+		`The burn-in for {{.PullRequest}} has been deployed.
+`+deploymentDetails+footer),
+	"update": parse("update",
+		`[burn-in] Updated {{.PullRequest}} on {{.Deployment.DeployedOn}}`,
+		`The burn-in for {{.PullRequest}} has been updated.
+`+deploymentDetails+footer),
+	"rollback": parse("rollback",
+		`[burn-in] Rolled back {{.PullRequest}} on {{.Deployment.DeployedOn}}`,
+		`The update of the burn-in for {{.PullRequest}} has been rolled back to the binary it ran before.
+
+Reason: {{.Deployment.RollbackReason}}
+Rolled back from: {{.Deployment.RolledBackFrom}}
+`+deploymentDetails+footer),
+	"cleanup": parse("cleanup",
+		`[burn-in] Removed {{.PullRequest}} from {{.Deployment.DeployedOn}}`,
+		`The burn-in for {{.PullRequest}} has been removed.
+`+footer),
+	"error": parse("error",
+		`[burn-in] CI job failed{{with .PullRequest}} for {{.}}{{end}}`,
+		`The burn-in CI job failed with the following error:
+
+{{.Error}}
+{{with .Deployment}}
+Node: {{.Network}} {{.NodeType}}{{with .DeployedOn}} on {{.}}{{end}}
+{{- end}}
+`+footer),
+	"digest": parse("digest",
+		`[burn-in] {{len .Digest.BurnIns}} active burn-in(s)`,
+		`{{$digest := .Digest}}Active burn-ins:
+{{range .Digest.BurnIns}}
+{{short .PullRequest}} (request {{.RequestID}}, requested by {{.RequestedBy}}){{if .Overdue}} - OVERDUE{{end}}
+{{- with $digest.Age .DeployedAt}}
+  Deployed: {{.}} ago{{end}}
+{{- with $digest.Age .UpdatedAt}}
+  Updated: {{.}} ago{{end}}
+{{- range .Deployments}}
+  - {{.Network}} {{.NodeType}}{{with .DeployedOn}} on {{.}}{{else}} (not deployed yet){{end}}
+{{- range $name, $url := .Dashboards}}
+    {{$name}}: {{$url}}{{end}}
+{{- end}}
+{{else}}
+None.
+{{end}}
+{{- with .Digest.OrphanedHosts}}
+Paused runners without a "run" file:
+{{range .}}  - {{.}}
+{{end}}{{end}}
+CI job: {{.JobURL}}
+`),
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package email
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+)
+
+type message struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer accepts mails without authentication and sends them to the returned channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan message) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	messages := make(chan message, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(textproto.NewConn(conn), messages)
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func serveSMTP(conn *textproto.Conn, messages chan<- message) {
+	defer conn.Close()
+	_ = conn.PrintfLine("220 localhost ESMTP")
+
+	var msg message
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			_ = conn.PrintfLine("250-localhost")
+			_ = conn.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			msg.from = addressOf(line)
+			_ = conn.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, addressOf(line))
+			_ = conn.PrintfLine("250 OK")
+		case "DATA":
+			_ = conn.PrintfLine("354 Go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			messages <- msg
+			msg = message{}
+			_ = conn.PrintfLine("250 OK")
+		case "QUIT":
+			_ = conn.PrintfLine("221 Bye")
+			return
+		default:
+			_ = conn.PrintfLine("250 OK")
+		}
+	}
+}
+
+// addressOf returns the address in e.g. "MAIL FROM:<burnin@example.com> BODY=8BITMIME".
+func addressOf(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func TestClient(t *testing.T) {
+	addr, messages := fakeSMTPServer(t)
+
+	client, err := NewClient(addr, "", "", "burnin@example.com", []string{"dev@example.com", "ops@example.com"})
+	require.NoError(t, err)
+	notifier := notify.Events{Sender: client}
+
+	deployment := burnin.Deployment{
+		PullRequest:  "https://github.com/paritytech/polkadot/pull/2013",
+		RequestedBy:  "mxinden",
+		CustomBinary: "https://gitlab.example.com/parity/polkadot/-/jobs/5/artifacts/raw/artifacts/polkadot",
+		Network:      "kusama",
+		NodeType:     burnin.FullNode,
+		DeployedOn:   "kusama-fullnode-uw1-0",
+	}
+
+	require.NoError(t, notifier.SendDeploymentNotification(deployment))
+	msg := <-messages
+	require.Equal(t, "burnin@example.com", msg.from)
+	require.Equal(t, []string{"dev@example.com", "ops@example.com"}, msg.to)
+	require.Contains(t, msg.data, "Subject: [burn-in] Deployed polkadot#2013 on kusama-fullnode-uw1-0\n")
+	require.Contains(t, msg.data, "To: dev@example.com, ops@example.com\n")
+	require.Contains(t, msg.data, "Node: kusama fullnode on kusama-fullnode-uw1-0\n")
+	require.Contains(t, msg.data, "Requested by: mxinden\n")
+
+	require.NoError(t, notifier.SendErrorNotification(&burnin.JobError{Deployment: deployment, Err: errors.New("playbook failed")}))
+	msg = <-messages
+	require.Contains(t, msg.data, "Subject: [burn-in] CI job failed for polkadot#2013\n")
+	require.Contains(t, msg.data, "playbook failed\n")
+	require.Contains(t, msg.data, "Node: kusama fullnode on kusama-fullnode-uw1-0\n")
+
+	require.NoError(t, notifier.SendErrorNotification(errors.New("invalid request")))
+	msg = <-messages
+	require.Contains(t, msg.data, "Subject: [burn-in] CI job failed\n")
+
+	deployment.Dashboards = map[string]string{"substrate_networking": "https://grafana.example.com/d/networking"}
+	require.NoError(t, notifier.SendDigestNotification(burnin.Digest{
+		CreatedAt: time.Date(2021, 1, 12, 20, 0, 0, 0, time.UTC),
+		BurnIns: []burnin.BurnIn{{
+			RequestID:   "1610469388",
+			PullRequest: deployment.PullRequest,
+			RequestedBy: "mxinden",
+			DeployedAt:  time.Date(2021, 1, 12, 16, 0, 0, 0, time.UTC),
+			Deployments: []burnin.Deployment{deployment},
+		}},
+	}))
+	msg = <-messages
+	require.Contains(t, msg.data, "Subject: [burn-in] 1 active burn-in(s)\n")
+	require.Contains(t, msg.data, `polkadot#2013 (request 1610469388, requested by mxinden)
+  Deployed: 4h ago
+  - kusama fullnode on kusama-fullnode-uw1-0
+    substrate_networking: https://grafana.example.com/d/networking
+`)
+	require.NotContains(t, msg.data, "Paused runners")
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient("smtp.example.com", "", "", "burnin@example.com", []string{"dev@example.com"})
+	require.Error(t, err, "port is missing")
+
+	_, err = NewClient("smtp.example.com:587", "", "", "burnin@example.com", nil)
+	require.Error(t, err)
+}
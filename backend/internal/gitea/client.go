@@ -0,0 +1,709 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package gitea
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/job"
+)
+
+const (
+	CommitAuthorName  = "Burn-in Automator"
+	CommitAuthorEmail = "bot@example.com"
+)
+
+// Client implements burnin.Repo and burnin.CI for a Gitea or Forgejo repository. CI support relies on the Actions API
+// (Gitea 1.24 or later). Runners are not managed through this client, combine it with another burnin.RunnerPool using
+// burnin.Forge.
+type Client struct {
+	serverURL   *url.URL
+	repoURL     *url.URL // API URL to the repository (e.g. https://gitea.example.com/api/v1/repos/owner/name)
+	repository  Repository
+	accessToken string
+	httpClient  *http.Client
+}
+
+type Repository struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+// NewClient expects repository in the form "owner/name".
+func NewClient(serverURL *url.URL, repository string, accessToken string) (*Client, error) {
+	parts := strings.Split(repository, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid repository '%s' (must be '<owner>/<name>')", repository)
+	}
+
+	repoURL, err := job.AddPathsToURL(serverURL, "api/v1/repos", parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		serverURL:   serverURL,
+		repoURL:     repoURL,
+		repository:  Repository{Name: parts[1], FullName: repository},
+		accessToken: accessToken,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+func (c *Client) Authenticate() error {
+	return c.doJSON(http.MethodGet, c.repoURL, nil, http.StatusOK, &c.repository)
+}
+
+// GetLastCommitDiffs parses the raw git diff of the given commit (or branch head) into the format returned by the GitLab
+// API, so that the jobs can treat both alike.
+func (c *Client) GetLastCommitDiffs(ref string) ([]burnin.CommitDiff, error) {
+	sha, err := c.commitSHA(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := c.addPathsToRepoURL("git/commits", sha+".diff")
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if err := errorIfNot(http.StatusOK, response.Request, nil, response, false); err != nil {
+		return nil, err
+	}
+
+	rawDiff, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := parseGitDiff(string(rawDiff))
+	if len(diffs) < 1 {
+		return diffs, fmt.Errorf("Gitea API returned no diffs for '%s'", ref)
+	}
+
+	return diffs, nil
+}
+
+var commitSHARegexp = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// commitSHA returns ref if it is a commit SHA and the head of the branch otherwise, as the diff endpoint only takes
+// SHAs.
+func (c *Client) commitSHA(ref string) (string, error) {
+	if commitSHARegexp.MatchString(ref) {
+		return ref, nil
+	}
+
+	u, err := c.addPathsToRepoURL("branches", ref)
+	if err != nil {
+		return "", err
+	}
+
+	var branch struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &branch); err != nil {
+		return "", err
+	}
+
+	if branch.Commit.ID == "" {
+		return "", fmt.Errorf("Gitea API returned no head commit for branch '%s'", ref)
+	}
+	return branch.Commit.ID, nil
+}
+
+func (c *Client) CreateBranch(name, fromBranch string) error {
+	u, err := c.addPathsToRepoURL("branches")
+	if err != nil {
+		return err
+	}
+
+	payload := struct {
+		NewBranchName string `json:"new_branch_name"`
+		OldRefName    string `json:"old_ref_name"`
+	}{name, fromBranch}
+
+	return c.doJSON(http.MethodPost, u, payload, http.StatusCreated, nil)
+}
+
+func (c *Client) ListDirectory(path, branch string) ([]burnin.FileInfo, error) {
+	var contents []contentsResponse
+	u, err := c.contentsURL(path, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &contents); err != nil {
+		return nil, err
+	}
+
+	// The jobs expect the GitLab names for entry types.
+	items := make([]burnin.FileInfo, len(contents))
+	for i, entry := range contents {
+		entryType := entry.Type
+		if entryType == "file" {
+			entryType = "blob"
+		} else if entryType == "dir" {
+			entryType = "tree"
+		}
+
+		items[i] = burnin.FileInfo{
+			ID:   entry.SHA,
+			Name: entry.Name,
+			Type: entryType,
+			Path: entry.Path,
+		}
+	}
+
+	return items, nil
+}
+
+// GetFile returns the content of a file on the given branch.
+func (c *Client) GetFile(path, branch string) ([]byte, error) {
+	content, err := c.file(path, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(content.Content)
+}
+
+func (c *Client) CreateFile(path, branch, commitMsg string, content []byte) error {
+	u, err := c.contentsURL(path, "")
+	if err != nil {
+		return err
+	}
+
+	payload := c.newFileOptions(branch, commitMsg)
+	payload.Content = base64.StdEncoding.EncodeToString(content)
+
+	return c.doJSON(http.MethodPost, u, payload, http.StatusCreated, nil)
+}
+
+// UpdateFile looks up the blob SHA of the current file first, because the Gitea API requires it for updates.
+func (c *Client) UpdateFile(path, branch, commitMsg string, content []byte) error {
+	sha, err := c.fileSHA(path, branch)
+	if err != nil {
+		return err
+	}
+
+	u, err := c.contentsURL(path, "")
+	if err != nil {
+		return err
+	}
+
+	payload := c.newFileOptions(branch, commitMsg)
+	payload.Content = base64.StdEncoding.EncodeToString(content)
+	payload.SHA = sha
+
+	return c.doJSON(http.MethodPut, u, payload, http.StatusOK, nil)
+}
+
+// DeleteFile looks up the blob SHA of the current file first, because the Gitea API requires it for deletions.
+func (c *Client) DeleteFile(path, branch, commitMsg string) error {
+	sha, err := c.fileSHA(path, branch)
+	if err != nil {
+		return err
+	}
+
+	u, err := c.contentsURL(path, "")
+	if err != nil {
+		return err
+	}
+
+	payload := c.newFileOptions(branch, commitMsg)
+	payload.SHA = sha
+
+	return c.doJSON(http.MethodDelete, u, payload, http.StatusOK, nil)
+}
+
+func (c *Client) CreateMergeRequest(title, sourceBranch, targetBranch string) (burnin.MergeRequest, error) {
+	var mr burnin.MergeRequest
+	u, err := c.addPathsToRepoURL("pulls")
+	if err != nil {
+		return mr, err
+	}
+
+	payload := struct {
+		Title string `json:"title"`
+		Head  string `json:"head"`
+		Base  string `json:"base"`
+	}{title, sourceBranch, targetBranch}
+
+	var pull struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+
+	if err := c.doJSON(http.MethodPost, u, payload, http.StatusCreated, &pull); err != nil {
+		return mr, err
+	}
+
+	return burnin.MergeRequest{
+		ID:        pull.Number,
+		ProjectID: c.repository.ID,
+		WebURL:    pull.HTMLURL,
+	}, nil
+}
+
+func (c *Client) WebURLForBranch(branch string) (*url.URL, error) {
+	return job.AddPathsToURL(c.serverURL, c.repository.FullName, "src/branch", branch)
+}
+
+func (c *Client) PrefixSkipCI(s string) string {
+	return fmt.Sprintf("[skip ci] %s", s)
+}
+
+func (c *Client) PrefixDeploy(nw string, nt burnin.NodeType, s string) string {
+	return fmt.Sprintf("[deploy-%s-%v] %s", nw, nt, s)
+}
+
+func (c *Client) PrefixUpdateDeployment(s string) string {
+	return fmt.Sprintf("[update-deployment] %s", s)
+}
+
+func (c *Client) PrefixCleanup(s string) string {
+	return fmt.Sprintf("[cleanup] %s", s)
+}
+
+// GetPipelinesForBranch returns the workflow runs of the branch, most recent first.
+func (c *Client) GetPipelinesForBranch(branch string) ([]burnin.Pipeline, error) {
+	return c.listRuns(url.Values{"branch": {branch}})
+}
+
+// GetPipelineForCommit returns ErrPipelineNotFound when there is no workflow run for the given commit SHA (yet).
+func (c *Client) GetPipelineForCommit(sha string) (burnin.Pipeline, error) {
+	pipelines, err := c.listRuns(url.Values{"head_sha": {sha}})
+	if err != nil {
+		return burnin.Pipeline{}, err
+	}
+
+	if len(pipelines) < 1 {
+		return burnin.Pipeline{}, burnin.ErrPipelineNotFound
+	}
+
+	return pipelines[0], nil
+}
+
+func (c *Client) GetPipeline(pipelineID int) (burnin.Pipeline, error) {
+	var run workflowRun
+	u, err := c.addPathsToRepoURL("actions/runs", strconv.Itoa(pipelineID))
+	if err != nil {
+		return burnin.Pipeline{}, err
+	}
+
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &run); err != nil {
+		return burnin.Pipeline{}, err
+	}
+
+	return run.toPipeline(), nil
+}
+
+func (c *Client) GetPipelineJobs(pipelineID int) ([]burnin.Job, error) {
+	var payload struct {
+		Jobs []workflowJob `json:"jobs"`
+	}
+
+	u, err := c.addPathsToRepoURL("actions/runs", strconv.Itoa(pipelineID), "jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("limit", "100")
+	u.RawQuery = q.Encode()
+
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &payload); err != nil {
+		return nil, err
+	}
+
+	if len(payload.Jobs) < 1 {
+		return nil, fmt.Errorf("Gitea API returned no jobs for workflow run '%d'", pipelineID)
+	}
+
+	jobs := make([]burnin.Job, len(payload.Jobs))
+	for i, j := range payload.Jobs {
+		jobs[i] = j.toJob()
+	}
+
+	return jobs, nil
+}
+
+func (c *Client) GetJob(jobID int) (burnin.Job, error) {
+	var j workflowJob
+	u, err := c.addPathsToRepoURL("actions/jobs", strconv.Itoa(jobID))
+	if err != nil {
+		return burnin.Job{}, err
+	}
+
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &j); err != nil {
+		return burnin.Job{}, err
+	}
+
+	return j.toJob(), nil
+}
+
+// StartJob is not supported, because Gitea Actions has no equivalent to manual GitLab jobs.
+func (c *Client) StartJob(jobID int) error {
+	return fmt.Errorf("cannot start job %d: Gitea Actions does not support starting individual jobs", jobID)
+}
+
+// WebURLForJob needs to look up the job, because the web URL of a job in Gitea contains the ID of its workflow run.
+func (c *Client) WebURLForJob(id int) (*url.URL, error) {
+	j, err := c.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.WebURL == "" {
+		return nil, fmt.Errorf("Gitea API returned no web URL for job %d", id)
+	}
+
+	return url.Parse(j.WebURL)
+}
+
+type contentsResponse struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	SHA     string `json:"sha"`
+	Type    string `json:"type"`
+	Content string `json:"content"` // base64 encoded, only set for single files
+}
+
+type identity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type fileOptions struct {
+	Branch  string   `json:"branch"`
+	Message string   `json:"message"`
+	Author  identity `json:"author"`
+	Content string   `json:"content,omitempty"`
+	SHA     string   `json:"sha,omitempty"`
+}
+
+func (c *Client) newFileOptions(branch, commitMsg string) fileOptions {
+	return fileOptions{
+		Branch:  branch,
+		Message: commitMsg,
+		Author:  identity{CommitAuthorName, CommitAuthorEmail},
+	}
+}
+
+func (c *Client) fileSHA(path, branch string) (string, error) {
+	content, err := c.file(path, branch)
+	if err != nil {
+		return "", err
+	}
+
+	return content.SHA, nil
+}
+
+func (c *Client) file(path, branch string) (contentsResponse, error) {
+	var content contentsResponse
+	u, err := c.contentsURL(path, branch)
+	if err != nil {
+		return content, err
+	}
+
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &content); err != nil {
+		return content, err
+	}
+
+	if content.Type != "file" {
+		return content, fmt.Errorf("'%s' on branch '%s' is not a file", path, branch)
+	}
+
+	return content, nil
+}
+
+func (c *Client) contentsURL(path, ref string) (*url.URL, error) {
+	u, err := c.addPathsToRepoURL("contents", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref != "" {
+		q := u.Query()
+		q.Set("ref", ref)
+		u.RawQuery = q.Encode()
+	}
+
+	return u, nil
+}
+
+type workflowRun struct {
+	ID         int    `json:"id"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	HeadBranch string `json:"head_branch"`
+	HeadSHA    string `json:"head_sha"`
+	HTMLURL    string `json:"html_url"`
+	StartedAt  string `json:"started_at"`
+}
+
+func (r workflowRun) toPipeline() burnin.Pipeline {
+	return burnin.Pipeline{
+		ID:        r.ID,
+		Status:    gitlabStatus(r.Status, r.Conclusion),
+		Ref:       r.HeadBranch,
+		SHA:       r.HeadSHA,
+		WebURL:    r.HTMLURL,
+		CreatedAt: r.StartedAt,
+	}
+}
+
+type workflowJob struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	HTMLURL    string `json:"html_url"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func (j workflowJob) toJob() burnin.Job {
+	return burnin.Job{
+		ID:        j.ID,
+		Name:      j.Name,
+		Status:    gitlabStatus(j.Status, j.Conclusion),
+		WebURL:    j.HTMLURL,
+		CreatedAt: j.CreatedAt,
+	}
+}
+
+// gitlabStatus maps the GitHub-style status and conclusion of workflow runs and jobs to the GitLab status values the
+// jobs know how to handle.
+func gitlabStatus(status, conclusion string) string {
+	switch status {
+	case "queued", "waiting", "blocked":
+		return "pending"
+	case "in_progress":
+		return "running"
+	case "completed":
+		switch conclusion {
+		case "success":
+			return "success"
+		case "cancelled":
+			return "canceled"
+		case "skipped":
+			return "skipped"
+		default:
+			return "failed"
+		}
+	}
+
+	return status
+}
+
+func (c *Client) listRuns(query url.Values) ([]burnin.Pipeline, error) {
+	var payload struct {
+		WorkflowRuns []workflowRun `json:"workflow_runs"`
+	}
+
+	u, err := c.addPathsToRepoURL("actions/runs")
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+
+	if err := c.doJSON(http.MethodGet, u, nil, http.StatusOK, &payload); err != nil {
+		return nil, err
+	}
+
+	pipelines := make([]burnin.Pipeline, len(payload.WorkflowRuns))
+	for i, run := range payload.WorkflowRuns {
+		pipelines[i] = run.toPipeline()
+	}
+
+	return pipelines, nil
+}
+
+// parseGitDiff splits the output of "git diff" into one burnin.CommitDiff per file. Like in the GitLab API, the "Diff"
+// field starts with the first hunk header, and "OldPath" and "NewPath" are both set for added and deleted files.
+func parseGitDiff(raw string) []burnin.CommitDiff {
+	var diffs []burnin.CommitDiff
+	var current *burnin.CommitDiff
+	var hunks []string
+	inHunks := false
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+		if len(hunks) > 0 {
+			current.Diff = strings.Join(hunks, "\n") + "\n"
+		}
+		if current.OldPath == nil {
+			current.OldPath = current.NewPath
+		}
+		if current.NewPath == nil {
+			current.NewPath = current.OldPath
+		}
+		diffs = append(diffs, *current)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(raw, "\n"), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			finish()
+			current = new(burnin.CommitDiff)
+			hunks = nil
+			inHunks = false
+
+			if parts := strings.SplitN(strings.TrimPrefix(line, "diff --git a/"), " b/", 2); len(parts) == 2 {
+				oldPath, newPath := parts[0], parts[1]
+				current.OldPath, current.NewPath = &oldPath, &newPath
+			}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if inHunks || strings.HasPrefix(line, "@@") {
+			inHunks = true
+			hunks = append(hunks, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "new file mode "):
+			current.NewFile = true
+			mode := strings.TrimPrefix(line, "new file mode ")
+			current.BMode = &mode
+		case strings.HasPrefix(line, "deleted file mode "):
+			current.DeletedFile = true
+			mode := strings.TrimPrefix(line, "deleted file mode ")
+			current.AMode = &mode
+		case strings.HasPrefix(line, "rename from "):
+			current.RenamedFile = true
+			oldPath := strings.TrimPrefix(line, "rename from ")
+			current.OldPath = &oldPath
+		case strings.HasPrefix(line, "rename to "):
+			newPath := strings.TrimPrefix(line, "rename to ")
+			current.NewPath = &newPath
+		case strings.HasPrefix(line, "index "):
+			if fields := strings.Fields(line); len(fields) == 3 {
+				mode := fields[2]
+				current.AMode, current.BMode = &mode, &mode
+			}
+		}
+	}
+	finish()
+
+	return diffs
+}
+
+func (c *Client) doJSON(method string, u *url.URL, payload interface{}, status int, result interface{}) error {
+	var buf []byte
+	if payload != nil {
+		var err error
+		if buf, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	response, err := c.do(method, u, buf)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := errorIfNot(status, response.Request, buf, response, false); err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(response.Body).Decode(result)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("Gitea API returned an empty response for %s %s", method, u)
+	}
+	return err
+}
+
+func (c *Client) do(method string, u *url.URL, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewBuffer(payload)
+	}
+
+	request, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Authorization", fmt.Sprintf("token %s", c.accessToken))
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.httpClient.Do(request)
+}
+
+func (c *Client) addPathsToRepoURL(paths ...string) (*url.URL, error) {
+	return job.AddPathsToURL(c.repoURL, paths...)
+}
+
+func errorIfNot(status int, request *http.Request, payload []byte, response *http.Response, closeResponse bool) error {
+	if closeResponse {
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode == status {
+		return nil
+	}
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf(`HTTP request to Gitea API failed.
+Request: %s %s
+Body: %s
+
+Response: %s
+Body: %s`, request.Method, request.URL.String(), payload, response.Status, string(responseBody))
+}
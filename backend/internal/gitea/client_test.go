@@ -0,0 +1,187 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package gitea
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+const rawDiff = `diff --git a/runs/run-kusama-fullnode-0-1602856340.toml b/runs/run-kusama-fullnode-0-1602856340.toml
+deleted file mode 100644
+index 3f2a1b4..0000000
+--- a/runs/run-kusama-fullnode-0-1602856340.toml
++++ /dev/null
+@@ -1,2 +0,0 @@
+-pull_request="https://github.com/paritytech/polkadot/pull/2013"
+-deployed_on="kusama-unit-test-hostname"
+diff --git a/requests/request-1602856340.toml b/requests/request-1602856340.toml
+new file mode 100644
+index 0000000..1a2b3c4
+--- /dev/null
++++ b/requests/request-1602856340.toml
+@@ -0,0 +1 @@
++pull_request="https://github.com/paritytech/polkadot/pull/2013"
+diff --git a/runs/run-kusama-fullnode-0-1610469388.toml b/runs/run-kusama-fullnode-0-1610469388.toml
+index 1111111..2222222 100644
+--- a/runs/run-kusama-fullnode-0-1610469388.toml
++++ b/runs/run-kusama-fullnode-0-1610469388.toml
+@@ -1,2 +1,2 @@
+ pull_request="https://github.com/paritytech/polkadot/pull/2013"
+-commit_sha="f52b0b01d8f27fdb387667de5a56da2754ce77a1"
++commit_sha="a7810560c0f62dd6d347e710a5e2a64da465c109"
+`
+
+func Test_parseGitDiff(t *testing.T) {
+	diffs := parseGitDiff(rawDiff)
+	require.Len(t, diffs, 3)
+
+	deleted := diffs[0]
+	require.True(t, deleted.DeletedFile)
+	require.False(t, deleted.NewFile)
+	require.Equal(t, "runs/run-kusama-fullnode-0-1602856340.toml", *deleted.OldPath)
+	require.Equal(t, "runs/run-kusama-fullnode-0-1602856340.toml", *deleted.NewPath)
+	require.Equal(
+		t,
+		"@@ -1,2 +0,0 @@\n-pull_request=\"https://github.com/paritytech/polkadot/pull/2013\"\n-deployed_on=\"kusama-unit-test-hostname\"\n",
+		deleted.Diff,
+	)
+
+	added := diffs[1]
+	require.True(t, added.NewFile)
+	require.False(t, added.DeletedFile)
+	require.Equal(t, "requests/request-1602856340.toml", *added.NewPath)
+
+	updated := diffs[2]
+	require.False(t, updated.NewFile)
+	require.False(t, updated.DeletedFile)
+	require.False(t, updated.RenamedFile)
+	require.Equal(t, "100644", *updated.BMode)
+	require.Contains(t, updated.Diff, "+commit_sha=")
+	require.Contains(t, updated.Diff, "-commit_sha=")
+}
+
+func Test_Client(t *testing.T) {
+	const headSHA = "0123456789abcdef0123456789abcdef01234567"
+	files := map[string]string{"runs/run-kusama-fullnode-0-1602856340.toml": "sha-1"}
+	var commits []fileOptions
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/repos/burn-in-tests/deployments", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "token s3cr3t", r.Header.Get("Authorization"))
+		_, _ = fmt.Fprint(w, `{"id": 7, "name": "deployments", "full_name": "burn-in-tests/deployments"}`)
+	})
+	mux.HandleFunc("/api/v1/repos/burn-in-tests/deployments/branches/master", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"name": "master", "commit": {"id": "%s"}}`, headSHA)
+	})
+	mux.HandleFunc("/api/v1/repos/burn-in-tests/deployments/git/commits/"+headSHA+".diff", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, rawDiff)
+	})
+	mux.HandleFunc("/api/v1/repos/burn-in-tests/deployments/contents/runs", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "master", r.URL.Query().Get("ref"))
+		_, _ = fmt.Fprint(w, `[{"name": "run-kusama-fullnode-0-1602856340.toml", "path": "runs/run-kusama-fullnode-0-1602856340.toml", "sha": "sha-1", "type": "file"}]`)
+	})
+	mux.HandleFunc("/api/v1/repos/burn-in-tests/deployments/contents/runs/", func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path[len("/api/v1/repos/burn-in-tests/deployments/contents/"):]
+		if r.Method == http.MethodGet {
+			content := base64.StdEncoding.EncodeToString([]byte("a = 1\n"))
+			_, _ = fmt.Fprintf(w, `{"name": "x", "path": "%s", "sha": "%s", "type": "file", "content": "%s"}`, path, files[path], content)
+			return
+		}
+
+		var opts fileOptions
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+		commits = append(commits, opts)
+
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_, _ = fmt.Fprint(w, `{}`)
+	})
+	mux.HandleFunc("/api/v1/repos/burn-in-tests/deployments/actions/runs", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "2013", r.URL.Query().Get("branch"))
+		_, _ = fmt.Fprint(w, `{"workflow_runs": [{"id": 3, "status": "completed", "conclusion": "failure", "head_branch": "2013", "head_sha": "abc"}]}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	client, err := NewClient(serverURL, "burn-in-tests/deployments", "s3cr3t")
+	require.NoError(t, err)
+	require.NoError(t, client.Authenticate())
+	require.Equal(t, 7, client.repository.ID)
+
+	diffs, err := client.GetLastCommitDiffs("master")
+	require.NoError(t, err)
+	require.Len(t, diffs, 3)
+
+	// commits are not resolved again
+	diffs, err = client.GetLastCommitDiffs(headSHA)
+	require.NoError(t, err)
+	require.Len(t, diffs, 3)
+
+	_, err = client.GetLastCommitDiffs("missing")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "404")
+
+	items, err := client.ListDirectory("runs", "master")
+	require.NoError(t, err)
+	require.Equal(t, []burnin.FileInfo{{
+		ID:   "sha-1",
+		Name: "run-kusama-fullnode-0-1602856340.toml",
+		Type: "blob",
+		Path: "runs/run-kusama-fullnode-0-1602856340.toml",
+	}}, items)
+
+	content, err := client.GetFile("runs/run-kusama-fullnode-0-1602856340.toml", "master")
+	require.NoError(t, err)
+	require.Equal(t, "a = 1\n", string(content))
+
+	err = client.CreateFile("runs/run-polkadot-fullnode-0-1602856340.toml", "master", "[deploy-polkadot-fullnode] x", []byte("foo"))
+	require.NoError(t, err)
+	err = client.UpdateFile("runs/run-kusama-fullnode-0-1602856340.toml", "master", client.PrefixSkipCI("y"), []byte("bar"))
+	require.NoError(t, err)
+	err = client.DeleteFile("runs/run-kusama-fullnode-0-1602856340.toml", "master", "[cleanup] z")
+	require.NoError(t, err)
+
+	require.Len(t, commits, 3)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("foo")), commits[0].Content)
+	require.Empty(t, commits[0].SHA)
+	require.Equal(t, "sha-1", commits[1].SHA)
+	require.Equal(t, "[skip ci] y", commits[1].Message)
+	require.Equal(t, "sha-1", commits[2].SHA)
+	require.Equal(t, CommitAuthorName, commits[2].Author.Name)
+
+	pipelines, err := client.GetPipelinesForBranch("2013")
+	require.NoError(t, err)
+	require.Len(t, pipelines, 1)
+	require.Equal(t, "failed", pipelines[0].Status)
+
+	branchURL, err := client.WebURLForBranch("master")
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/burn-in-tests/deployments/src/branch/master", branchURL.String())
+}
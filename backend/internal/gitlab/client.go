@@ -39,7 +39,7 @@ type Client struct {
 	serverURL   *url.URL
 	projectURL  *url.URL // API URL to the project (e.g. https://gitlab.example.com/api/v4/project/42)
 	project     Project
-	accessToken string
+	credentials Credentials
 	httpClient  *http.Client
 }
 
@@ -49,7 +49,7 @@ type Project struct {
 	PathWithNamespace string `json:"path_with_namespace"`
 }
 
-func NewClient(serverURL *url.URL, projectID int, accessToken string) (*Client, error) {
+func NewClient(serverURL *url.URL, projectID int, credentials Credentials) (*Client, error) {
 	projectURL, err := url.Parse(fmt.Sprintf("api/v4/projects/%d", projectID))
 	if err != nil {
 		return nil, err
@@ -59,7 +59,7 @@ func NewClient(serverURL *url.URL, projectID int, accessToken string) (*Client,
 		serverURL:   serverURL,
 		projectURL:  serverURL.ResolveReference(projectURL),
 		project:     Project{ID: projectID},
-		accessToken: accessToken,
+		credentials: credentials,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -72,7 +72,9 @@ func (c *Client) Authenticate() error {
 		return err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeRead); err != nil {
+		return err
+	}
 
 	response, err := c.httpClient.Do(request)
 	if err != nil {
@@ -100,7 +102,10 @@ func (c *Client) GetLastCommitDiffs(branch string) ([]burnin.CommitDiff, error)
 		return diffs, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeRead); err != nil {
+		return diffs, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return diffs, err
@@ -142,7 +147,10 @@ func (c *Client) GetPipelinesForBranch(branch string) ([]burnin.Pipeline, error)
 		return pipelines, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopePipelines); err != nil {
+		return pipelines, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return pipelines, err
@@ -181,7 +189,10 @@ func (c *Client) GetPipelineForCommit(sha string) (burnin.Pipeline, error) {
 		return burnin.Pipeline{}, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopePipelines); err != nil {
+		return burnin.Pipeline{}, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return burnin.Pipeline{}, err
@@ -217,7 +228,10 @@ func (c *Client) GetPipeline(pipelineID int) (burnin.Pipeline, error) {
 		return pipeline, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopePipelines); err != nil {
+		return pipeline, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return pipeline, err
@@ -253,7 +267,10 @@ func (c *Client) GetPipelineJobs(pipelineID int) ([]burnin.Job, error) {
 		return jobs, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopePipelines); err != nil {
+		return jobs, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return jobs, err
@@ -289,7 +306,10 @@ func (c *Client) GetJob(jobID int) (burnin.Job, error) {
 		return glJob, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopePipelines); err != nil {
+		return glJob, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return glJob, err
@@ -320,7 +340,10 @@ func (c *Client) StartJob(jobID int) error {
 		return err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeWrite); err != nil {
+		return err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return err
@@ -345,7 +368,10 @@ func (c *Client) CreateBranch(name, fromBranch string) error {
 		return err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeWrite); err != nil {
+		return err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return err
@@ -373,7 +399,10 @@ func (c *Client) ListDirectory(path, branch string) ([]burnin.FileInfo, error) {
 		return items, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeRead); err != nil {
+		return items, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return items, err
@@ -393,6 +422,39 @@ func (c *Client) ListDirectory(path, branch string) ([]burnin.FileInfo, error) {
 	return items, nil
 }
 
+// GetFile returns the raw content of a file on the given branch.
+func (c *Client) GetFile(path, branch string) ([]byte, error) {
+	u, err := c.addPathsToProjectURL("repository/files", url.PathEscape(path), "raw")
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("ref", branch)
+	u.RawQuery = q.Encode()
+
+	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.authorize(request, scopeRead); err != nil {
+		return nil, err
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if err := errorIfNot(http.StatusOK, request, nil, response, false); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
 // CreateFile commits a new file to the given branch. The commit message is prepended with the prefix defined in the
 // constant SkipCI, if the parameter skipCI is set to true. This is useful to avoid triggering CI jobs from commits
 // added within CI jobs.
@@ -449,7 +511,10 @@ func (c *Client) CreateMergeRequest(title, sourceBranch, targetBranch string) (b
 		return mr, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeWrite); err != nil {
+		return mr, err
+	}
+
 	request.Header.Set("Content-Type", "application/json")
 	response, err := c.httpClient.Do(request)
 	if err != nil {
@@ -513,7 +578,10 @@ func (c *Client) GetRunners() ([]burnin.Runner, error) {
 			return runners, err
 		}
 
-		request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+		if err := c.authorize(request, scopeRunners); err != nil {
+			return runners, err
+		}
+
 		response, err := c.httpClient.Do(request)
 		if err != nil {
 			return runners, err
@@ -567,7 +635,10 @@ func (c *Client) GetRunnerTags(id int) ([]string, error) {
 		return nil, err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeRunners); err != nil {
+		return nil, err
+	}
+
 	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return nil, err
@@ -629,7 +700,10 @@ func (c *Client) setRunnerActiveFlag(hostname string, active bool) error {
 	if err != nil {
 		return err
 	}
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeRunners); err != nil {
+		return err
+	}
+
 	request.Header.Set("Content-Type", "application/json")
 
 	response, err := c.httpClient.Do(request)
@@ -687,7 +761,10 @@ func (c *Client) commitFile(action commitAction, path, branch, commitMsg, conten
 		return err
 	}
 
-	request.Header.Set("PRIVATE-TOKEN", c.accessToken)
+	if err := c.authorize(request, scopeWrite); err != nil {
+		return err
+	}
+
 	request.Header.Set("Content-Type", "application/json")
 	response, err := c.httpClient.Do(request)
 	if err != nil {
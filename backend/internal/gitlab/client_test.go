@@ -20,6 +20,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/gitlab/gitlabtest"
 )
 
 func TestWebURLHelpers(t *testing.T) {
@@ -43,3 +45,111 @@ func TestWebURLHelpers(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, "https://gitlab.example.com/mocks/mockproject/-/jobs/23", jobURL.String())
 }
+
+func TestClientAgainstFakeServer(t *testing.T) {
+	server := gitlabtest.NewServer()
+	defer server.Close()
+	server.Tokens = map[string]gitlabtest.Access{"s3cr3t": gitlabtest.Admin}
+	project := server.AddProject("burn-in-tests/deployments")
+	server.AddRunner("kusama-fullnode-uw1-0", "kusama-fullnode")
+
+	client, err := NewClient(server.URL(), project.ID, CredentialsFromToken(Token{Value: "wrong"}))
+	require.Nil(t, err)
+	require.Error(t, client.Authenticate())
+
+	client, err = NewClient(server.URL(), project.ID, CredentialsFromToken(Token{Value: "s3cr3t"}))
+	require.Nil(t, err)
+	require.Nil(t, client.Authenticate())
+	require.Equal(t, "burn-in-tests/deployments", client.project.PathWithNamespace)
+
+	require.Nil(t, client.CreateFile("runs/run-kusama-fullnode-0-1.toml", "master", "[deploy-kusama-fullnode] x", []byte("a = 1\n")))
+	require.Error(t, client.CreateFile("runs/run-kusama-fullnode-0-1.toml", "master", "again", []byte("a = 1\n")))
+	require.Nil(t, client.UpdateFile("runs/run-kusama-fullnode-0-1.toml", "master", "[skip ci] y", []byte("a = 2\n")))
+
+	diffs, err := client.GetLastCommitDiffs("master")
+	require.Nil(t, err)
+	require.Len(t, diffs, 1)
+	require.Equal(t, "@@ -1,1 +1,1 @@\n-a = 1\n+a = 2\n", diffs[0].Diff)
+
+	items, err := client.ListDirectory("runs", "master")
+	require.Nil(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "blob", items[0].Type)
+
+	content, err := client.GetFile("runs/run-kusama-fullnode-0-1.toml", "master")
+	require.Nil(t, err)
+	require.Equal(t, "a = 2\n", string(content))
+	_, err = client.GetFile("runs/unknown.toml", "master")
+	require.Error(t, err)
+
+	require.Nil(t, client.PauseRunner("kusama-fullnode-uw1-0"))
+	runner, _ := server.Runner("kusama-fullnode-uw1-0")
+	require.False(t, runner.Active)
+	require.Error(t, client.PauseRunner("unknown-host"))
+
+	require.Nil(t, client.DeleteFile("runs/run-kusama-fullnode-0-1.toml", "master", "[cleanup] z"))
+	_, exists := project.File("master", "runs/run-kusama-fullnode-0-1.toml")
+	require.False(t, exists)
+}
+
+func TestClientUsesLeastPrivilegedToken(t *testing.T) {
+	server := gitlabtest.NewServer()
+	defer server.Close()
+	server.Tokens = map[string]gitlabtest.Access{
+		"job":    gitlabtest.ReadOnly,
+		"repo":   gitlabtest.ReadWrite,
+		"admin":  gitlabtest.Admin,
+		"oauth2": gitlabtest.ReadWrite,
+	}
+	project := server.AddProject("burn-in-tests/deployments")
+	pipeline := project.AddPipeline("master", "abc", "success", burnin.Job{Name: "build", Status: "success"})
+	server.AddRunner("kusama-fullnode-uw1-0", "kusama-fullnode")
+
+	client, err := NewClient(server.URL(), project.ID, Credentials{
+		Job:         Token{Type: JobToken, Value: "job"},
+		Read:        Token{Value: "repo"},
+		Write:       Token{Type: OAuthToken, Value: "oauth2"},
+		RunnerAdmin: Token{Type: PrivateToken, Value: "admin"},
+	})
+	require.Nil(t, err)
+	require.Nil(t, client.Authenticate())
+	require.Nil(t, client.CreateFile("runs/run-kusama-fullnode-0-1.toml", "master", "[deploy-kusama-fullnode] x", []byte("a = 1\n")))
+	_, err = client.ListDirectory("runs", "master")
+	require.Nil(t, err)
+	_, err = client.GetPipelineJobs(pipeline.ID)
+	require.Nil(t, err)
+	require.Nil(t, client.PauseRunner("kusama-fullnode-uw1-0"))
+
+	var used []string
+	for _, r := range server.Requests() {
+		used = append(used, r.Method+" "+r.TokenType+" "+r.Token)
+	}
+	require.Equal(t, []string{
+		"GET PRIVATE-TOKEN repo",    // Authenticate
+		"POST Authorization oauth2", // CreateFile
+		"GET PRIVATE-TOKEN repo",    // ListDirectory
+		"GET JOB-TOKEN job",         // GetPipelineJobs
+		"GET PRIVATE-TOKEN admin",   // GetRunners
+		"GET PRIVATE-TOKEN admin",   // GetRunnerTags
+		"PUT PRIVATE-TOKEN admin",   // setRunnerActiveFlag
+	}, used)
+
+	// GitLab rejects job tokens for the repository, so they are never the fallback for reads.
+	client, err = NewClient(server.URL(), project.ID, Credentials{Job: Token{Type: JobToken, Value: "job"}})
+	require.Nil(t, err)
+	_, err = client.GetPipeline(pipeline.ID)
+	require.Nil(t, err)
+	_, err = client.GetFile("runs/run-kusama-fullnode-0-1.toml", "master")
+	require.EqualError(t, err, "no GitLab token configured for read access")
+
+	// Without a dedicated token, writes fall back to the runner admin but runner operations never fall back to a
+	// token with fewer privileges.
+	client, err = NewClient(server.URL(), project.ID, Credentials{RunnerAdmin: Token{Value: "admin"}})
+	require.Nil(t, err)
+	require.Nil(t, client.UpdateFile("runs/run-kusama-fullnode-0-1.toml", "master", "[skip ci] y", []byte("a = 2\n")))
+
+	client, err = NewClient(server.URL(), project.ID, Credentials{Read: Token{Value: "repo"}, Write: Token{Value: "repo"}})
+	require.Nil(t, err)
+	_, err = client.GetRunners()
+	require.EqualError(t, err, "no GitLab token configured for runner admin access")
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TokenType determines how a token is sent to the GitLab API.
+type TokenType int
+
+const (
+	PrivateToken TokenType = iota // personal, group or project access token, sent as PRIVATE-TOKEN header
+	JobToken                      // $CI_JOB_TOKEN of a running CI job, sent as JOB-TOKEN header
+	OAuthToken                    // OAuth 2.0 access token, sent as "Authorization: Bearer" header
+)
+
+type Token struct {
+	Type  TokenType
+	Value string
+}
+
+func (t Token) isSet() bool {
+	return t.Value != ""
+}
+
+func (t Token) apply(request *http.Request) {
+	switch t.Type {
+	case JobToken:
+		request.Header.Set("JOB-TOKEN", t.Value)
+	case OAuthToken:
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.Value))
+	default:
+		request.Header.Set("PRIVATE-TOKEN", t.Value)
+	}
+}
+
+// Credentials holds one token per capability the client needs. Tokens that are not set fall back to a token with
+// more privileges, so a single administrator token in RunnerAdmin is enough to do everything.
+type Credentials struct {
+	// Job is only sent to the endpoints GitLab accepts a CI job token for, i.e. reading pipelines and jobs (which
+	// needs the fine-grained job token permissions). Everything else uses Read.
+	Job         Token
+	Read        Token // reading the project, its repository, pipelines and jobs
+	Write       Token // committing files, creating branches and merge requests, starting jobs
+	RunnerAdmin Token // listing all runners of the instance and pausing them, requires an administrator
+}
+
+// CredentialsFromToken returns credentials which use the same token for everything.
+func CredentialsFromToken(token Token) Credentials {
+	return Credentials{Read: token, Write: token, RunnerAdmin: token}
+}
+
+type scope int
+
+const (
+	scopeRead scope = iota
+	scopePipelines
+	scopeWrite
+	scopeRunners
+)
+
+func (s scope) String() string {
+	switch s {
+	case scopeRead:
+		return "read"
+	case scopePipelines:
+		return "pipeline read"
+	case scopeWrite:
+		return "write"
+	case scopeRunners:
+		return "runner admin"
+	default:
+		return "unknown"
+	}
+}
+
+// tokenFor returns the least privileged token which is able to perform operations of the given scope.
+func (c Credentials) tokenFor(s scope) (Token, error) {
+	var candidates []Token
+	switch s {
+	case scopeRead:
+		candidates = []Token{c.Read, c.Write, c.RunnerAdmin}
+	case scopePipelines:
+		candidates = []Token{c.Job, c.Read, c.Write, c.RunnerAdmin}
+	case scopeWrite:
+		candidates = []Token{c.Write, c.RunnerAdmin}
+	case scopeRunners:
+		candidates = []Token{c.RunnerAdmin}
+	}
+
+	for _, t := range candidates {
+		if t.isSet() {
+			return t, nil
+		}
+	}
+
+	return Token{}, fmt.Errorf("no GitLab token configured for %s access", s)
+}
+
+func (c *Client) authorize(request *http.Request, s scope) error {
+	token, err := c.credentials.tokenFor(s)
+	if err != nil {
+		return err
+	}
+
+	token.apply(request)
+	return nil
+}
@@ -0,0 +1,776 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package gitlabtest provides an in-process fake of the parts of the GitLab REST API used by gitlab.Client. It keeps
+// repositories, pipelines, jobs and runners in memory, so that tests can run the real HTTP client and the jobs against
+// it and inspect the resulting state afterwards.
+package gitlabtest
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// Server is a fake GitLab instance. All methods are safe for concurrent use.
+type Server struct {
+	// Tokens maps the accepted access tokens to what they may do. Requests are not authenticated if it is empty.
+	Tokens map[string]Access
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	requests []Request
+	projects map[int]*Project
+	runners  []*runner
+	nextID   int
+}
+
+// Access is the level of access an access token grants.
+type Access int
+
+const (
+	ReadOnly  Access = iota // GET requests to projects
+	ReadWrite               // every request to projects
+	Admin                   // everything, including the runners of the instance
+)
+
+// Request is a request the server received.
+type Request struct {
+	Method    string
+	Path      string
+	TokenType string // name of the header the token was sent in, empty for unauthenticated requests
+	Token     string
+}
+
+type runner struct {
+	burnin.Runner
+	tags []string
+}
+
+// Commit is a commit in the fake repository of a project.
+type Commit struct {
+	SHA         string
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	Diffs       []burnin.CommitDiff
+
+	files map[string]string // snapshot of all files after this commit
+}
+
+// Project is a GitLab project with a repository, pipelines and merge requests.
+type Project struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+
+	// JobStatusAfterPlay is the status a job changes to when it is started through the API. Defaults to "success".
+	JobStatusAfterPlay string
+
+	server        *Server
+	branches      map[string][]*Commit // commit history per branch, most recent last
+	pipelines     []*burnin.Pipeline
+	pipelineJobs  map[int][]*burnin.Job
+	mergeRequests []burnin.MergeRequest
+}
+
+// NewServer starts a fake GitLab instance without any projects. It needs to be stopped with Close.
+func NewServer() *Server {
+	s := &Server{
+		projects: make(map[int]*Project),
+		nextID:   1,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL returns the server URL, which is what gitlab.NewClient expects.
+func (s *Server) URL() *url.URL {
+	u, _ := url.Parse(s.server.URL) // safe to ignore, httptest always returns a valid URL
+	return u
+}
+
+// AddProject creates a project with an empty "master" branch.
+func (s *Server) AddProject(pathWithNamespace string) *Project {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(pathWithNamespace, "/")
+	p := &Project{
+		ID:                 s.newID(),
+		Name:               parts[len(parts)-1],
+		PathWithNamespace:  pathWithNamespace,
+		JobStatusAfterPlay: "success",
+		server:             s,
+		branches:           map[string][]*Commit{"master": {{SHA: fakeSHA("initial"), files: map[string]string{}}}},
+		pipelineJobs:       make(map[int][]*burnin.Job),
+	}
+	s.projects[p.ID] = p
+	return p
+}
+
+// AddRunner registers an active runner. By convention, the description of burn-in runners is the hostname.
+func (s *Server) AddRunner(description string, tags ...string) burnin.Runner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &runner{
+		Runner: burnin.Runner{
+			ID:          s.newID(),
+			Description: description,
+			Active:      true,
+			Online:      true,
+			Status:      "online",
+		},
+		tags: tags,
+	}
+	s.runners = append(s.runners, r)
+	return r.Runner
+}
+
+// Runner returns the current state of the runner with the given description.
+func (s *Server) Runner(description string) (burnin.Runner, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.runners {
+		if r.Description == description {
+			return r.Runner, true
+		}
+	}
+	return burnin.Runner{}, false
+}
+
+// CommitFile adds or updates a file on the given branch, as if someone pushed a commit.
+func (p *Project) CommitFile(branch, filePath, content, message string) (*Commit, error) {
+	action := "create"
+	if _, exists := p.File(branch, filePath); exists {
+		action = "update"
+	}
+
+	return p.commit(branch, message, "Test User", "test@example.com", []commitAction{{action, filePath, content}})
+}
+
+// DeleteFile removes a file from the given branch, as if someone pushed a commit.
+func (p *Project) DeleteFile(branch, filePath, message string) (*Commit, error) {
+	return p.commit(branch, message, "Test User", "test@example.com", []commitAction{{"delete", filePath, ""}})
+}
+
+// File returns the content of a file on the given branch.
+func (p *Project) File(branch, filePath string) (string, bool) {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	head := p.head(branch)
+	if head == nil {
+		return "", false
+	}
+
+	content, exists := head.files[filePath]
+	return content, exists
+}
+
+// Commits returns the history of the given branch, most recent last.
+func (p *Project) Commits(branch string) []*Commit {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	return append([]*Commit(nil), p.branches[branch]...)
+}
+
+// LastCommit returns the most recent commit on the given branch.
+func (p *Project) LastCommit(branch string) *Commit {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	return p.head(branch)
+}
+
+// Checkout writes all files on the given branch to dir, which is how CI jobs see the repository.
+func (p *Project) Checkout(branch, dir string) error {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	head := p.head(branch)
+	if head == nil {
+		return fmt.Errorf("branch '%s' does not exist", branch)
+	}
+
+	for _, sub := range []string{"requests", "runs"} {
+		if err := os.RemoveAll(filepath.Join(dir, sub)); err != nil {
+			return err
+		}
+	}
+
+	for filePath, content := range head.files {
+		localPath := filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(localPath, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddPipeline creates a pipeline with the given jobs. IDs and web URLs are filled in.
+func (p *Project) AddPipeline(ref, sha, status string, jobs ...burnin.Job) burnin.Pipeline {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	pipeline := &burnin.Pipeline{
+		ID:        p.server.newID(),
+		Status:    status,
+		Ref:       ref,
+		SHA:       sha,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	pipeline.WebURL = p.webURL("-/pipelines", strconv.Itoa(pipeline.ID))
+
+	for i := range jobs {
+		j := jobs[i]
+		j.ID = p.server.newID()
+		j.WebURL = p.webURL("-/jobs", strconv.Itoa(j.ID))
+		j.CreatedAt = pipeline.CreatedAt
+		p.pipelineJobs[pipeline.ID] = append(p.pipelineJobs[pipeline.ID], &j)
+	}
+
+	// The API orders pipelines by "updated_at", most recent first.
+	p.pipelines = append([]*burnin.Pipeline{pipeline}, p.pipelines...)
+	return *pipeline
+}
+
+// Jobs returns the current state of the jobs in a pipeline.
+func (p *Project) Jobs(pipelineID int) []burnin.Job {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	var jobs []burnin.Job
+	for _, j := range p.pipelineJobs[pipelineID] {
+		jobs = append(jobs, *j)
+	}
+	return jobs
+}
+
+// MergeRequests returns all merge requests created through the API.
+func (p *Project) MergeRequests() []burnin.MergeRequest {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	return append([]burnin.MergeRequest(nil), p.mergeRequests...)
+}
+
+type commitAction struct {
+	Action   string `json:"action"`
+	FilePath string `json:"file_path"`
+	Content  string `json:"content"`
+}
+
+func (p *Project) commit(branch, message, authorName, authorEmail string, actions []commitAction) (*Commit, error) {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+
+	head := p.head(branch)
+	if head == nil {
+		return nil, fmt.Errorf("branch '%s' does not exist", branch)
+	}
+
+	files := make(map[string]string, len(head.files))
+	for k, v := range head.files {
+		files[k] = v
+	}
+
+	c := &Commit{
+		Message:     message,
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+	}
+
+	for _, a := range actions {
+		old, exists := files[a.FilePath]
+		switch a.Action {
+		case "create":
+			if exists {
+				return nil, fmt.Errorf("a file with this name already exists: %s", a.FilePath)
+			}
+			files[a.FilePath] = a.Content
+			c.Diffs = append(c.Diffs, mkDiff(a.FilePath, "", a.Content, true, false))
+		case "update":
+			if !exists {
+				return nil, fmt.Errorf("a file with this name doesn't exist: %s", a.FilePath)
+			}
+			files[a.FilePath] = a.Content
+			c.Diffs = append(c.Diffs, mkDiff(a.FilePath, old, a.Content, false, false))
+		case "delete":
+			if !exists {
+				return nil, fmt.Errorf("a file with this name doesn't exist: %s", a.FilePath)
+			}
+			delete(files, a.FilePath)
+			c.Diffs = append(c.Diffs, mkDiff(a.FilePath, old, "", false, true))
+		default:
+			return nil, fmt.Errorf("unsupported action '%s'", a.Action)
+		}
+	}
+
+	c.files = files
+	c.SHA = fakeSHA(fmt.Sprintf("%s %s %d", head.SHA, message, len(p.branches[branch])))
+	p.branches[branch] = append(p.branches[branch], c)
+	return c, nil
+}
+
+// head must be called with the server mutex held.
+func (p *Project) head(ref string) *Commit {
+	if commits, exists := p.branches[ref]; exists {
+		return commits[len(commits)-1]
+	}
+
+	for _, commits := range p.branches {
+		for _, c := range commits {
+			if c.SHA == ref {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Project) webURL(paths ...string) string {
+	u := p.server.URL()
+	u.Path = path.Join(append([]string{"/", p.PathWithNamespace}, paths...)...)
+	return u.String()
+}
+
+// newID must be called with the server mutex held.
+func (s *Server) newID() int {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// mkDiff creates a diff in the format returned by GET /projects/:id/repository/commits/:sha/diff. Changed files are
+// diffed as a whole, which is good enough for the jobs.
+func mkDiff(filePath, oldContent, newContent string, newFile, deletedFile bool) burnin.CommitDiff {
+	oldLines, newLines := splitLines(oldContent), splitLines(newContent)
+	oldStart, newStart := 1, 1
+	if len(oldLines) == 0 {
+		oldStart = 0
+	}
+	if len(newLines) == 0 {
+		newStart = 0
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, len(oldLines), newStart, len(newLines))
+	for _, l := range oldLines {
+		fmt.Fprintf(&b, "-%s\n", l)
+	}
+	for _, l := range newLines {
+		fmt.Fprintf(&b, "+%s\n", l)
+	}
+
+	oldPath, newPath := filePath, filePath
+	mode := "100644"
+	return burnin.CommitDiff{
+		Diff:        b.String(),
+		NewPath:     &newPath,
+		OldPath:     &oldPath,
+		AMode:       &mode,
+		BMode:       &mode,
+		NewFile:     newFile,
+		DeletedFile: deletedFile,
+	}
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+func fakeSHA(seed string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(seed)))
+}
+
+// Requests returns all requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// tokenFromRequest returns the header name and the token of the request.
+func tokenFromRequest(r *http.Request) (header, token string) {
+	switch {
+	case r.Header.Get("PRIVATE-TOKEN") != "":
+		return "PRIVATE-TOKEN", r.Header.Get("PRIVATE-TOKEN")
+	case r.Header.Get("JOB-TOKEN") != "":
+		return "JOB-TOKEN", r.Header.Get("JOB-TOKEN")
+	case strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+		return "Authorization", strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	default:
+		return "", ""
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	header, token := tokenFromRequest(r)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, TokenType: header, Token: token})
+	access, known := s.Tokens[token]
+	authenticate := len(s.Tokens) > 0
+	s.mu.Unlock()
+
+	if authenticate && !known {
+		writeError(w, http.StatusUnauthorized, "401 Unauthorized")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) < 3 || segments[0] != "api" || segments[1] != "v4" {
+		writeError(w, http.StatusNotFound, "404 Not Found")
+		return
+	}
+	segments = segments[2:]
+
+	// Like GitLab, job tokens are only accepted for reading pipelines and jobs, not for the repository or the project.
+	if header == "JOB-TOKEN" {
+		if r.Method != http.MethodGet || len(segments) < 3 || segments[0] != "projects" ||
+			(segments[2] != "pipelines" && segments[2] != "jobs") {
+			writeError(w, http.StatusUnauthorized, "401 Unauthorized")
+			return
+		}
+		access = ReadOnly
+	}
+
+	if authenticate {
+		required := ReadOnly
+		if segments[0] == "runners" {
+			required = Admin
+		} else if r.Method != http.MethodGet {
+			required = ReadWrite
+		}
+
+		if access < required {
+			writeError(w, http.StatusForbidden, "403 Forbidden")
+			return
+		}
+	}
+
+	switch segments[0] {
+	case "projects":
+		s.serveProject(w, r, segments[1:])
+	case "runners":
+		s.serveRunners(w, r, segments[1:])
+	default:
+		writeError(w, http.StatusNotFound, "404 Not Found")
+	}
+}
+
+func (s *Server) serveProject(w http.ResponseWriter, r *http.Request, segments []string) {
+	id, err := strconv.Atoi(segments[0])
+	s.mu.Lock()
+	p, exists := s.projects[id]
+	s.mu.Unlock()
+	if err != nil || !exists {
+		writeError(w, http.StatusNotFound, "404 Project Not Found")
+		return
+	}
+
+	route := strings.Join(segments[1:], "/")
+	q := r.URL.Query()
+
+	switch {
+	case route == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, p)
+
+	case strings.HasPrefix(route, "repository/commits/") && strings.HasSuffix(route, "/diff"):
+		ref := strings.TrimSuffix(strings.TrimPrefix(route, "repository/commits/"), "/diff")
+		s.mu.Lock()
+		c := p.head(ref)
+		s.mu.Unlock()
+		if c == nil {
+			writeError(w, http.StatusNotFound, "404 Commit Not Found")
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Diffs)
+
+	case route == "repository/commits" && r.Method == http.MethodPost:
+		var payload struct {
+			Branch        string         `json:"branch"`
+			CommitMessage string         `json:"commit_message"`
+			AuthorName    string         `json:"author_name"`
+			AuthorEmail   string         `json:"author_email"`
+			Actions       []commitAction `json:"actions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		c, err := p.commit(payload.Branch, payload.CommitMessage, payload.AuthorName, payload.AuthorEmail, payload.Actions)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": c.SHA, "message": c.Message})
+
+	case route == "repository/branches" && r.Method == http.MethodPost:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		from := p.head(q.Get("ref"))
+		if from == nil {
+			writeError(w, http.StatusBadRequest, "Invalid reference name")
+			return
+		}
+		if _, exists := p.branches[q.Get("branch")]; exists {
+			writeError(w, http.StatusBadRequest, "Branch already exists")
+			return
+		}
+		p.branches[q.Get("branch")] = []*Commit{from}
+		writeJSON(w, http.StatusCreated, map[string]string{"name": q.Get("branch")})
+
+	case route == "repository/tree" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		head := p.head(q.Get("ref"))
+		if head == nil {
+			writeError(w, http.StatusNotFound, "404 Tree Not Found")
+			return
+		}
+		writeJSON(w, http.StatusOK, listTree(head.files, q.Get("path")))
+
+	case strings.HasPrefix(route, "repository/files/") && strings.HasSuffix(route, "/raw") && r.Method == http.MethodGet:
+		filePath := strings.TrimSuffix(strings.TrimPrefix(route, "repository/files/"), "/raw")
+		content, exists := p.File(q.Get("ref"), filePath)
+		if !exists {
+			writeError(w, http.StatusNotFound, "404 File Not Found")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(content))
+
+	case route == "merge_requests" && r.Method == http.MethodPost:
+		var payload struct {
+			SourceBranch string `json:"source_branch"`
+			TargetBranch string `json:"target_branch"`
+			Title        string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		iid := len(p.mergeRequests) + 1
+		mr := burnin.MergeRequest{
+			ID:        iid,
+			ProjectID: p.ID,
+			WebURL:    p.webURL("-/merge_requests", strconv.Itoa(iid)),
+		}
+		p.mergeRequests = append(p.mergeRequests, mr)
+		writeJSON(w, http.StatusCreated, mr)
+
+	case route == "pipelines" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		pipelines := make([]burnin.Pipeline, 0)
+		for _, pl := range p.pipelines {
+			if (q.Get("ref") == "" || pl.Ref == q.Get("ref")) && (q.Get("sha") == "" || pl.SHA == q.Get("sha")) {
+				pipelines = append(pipelines, *pl)
+			}
+		}
+		writeJSON(w, http.StatusOK, pipelines)
+
+	case strings.HasPrefix(route, "pipelines/") && r.Method == http.MethodGet:
+		parts := strings.Split(route, "/")
+		pipelineID, _ := strconv.Atoi(parts[1])
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, pl := range p.pipelines {
+			if pl.ID != pipelineID {
+				continue
+			}
+			if len(parts) == 3 && parts[2] == "jobs" {
+				jobs := make([]burnin.Job, 0)
+				for _, j := range p.pipelineJobs[pipelineID] {
+					jobs = append(jobs, *j)
+				}
+				writeJSON(w, http.StatusOK, jobs)
+			} else {
+				writeJSON(w, http.StatusOK, pl)
+			}
+			return
+		}
+		writeError(w, http.StatusNotFound, "404 Not found")
+
+	case strings.HasPrefix(route, "jobs/"):
+		parts := strings.Split(route, "/")
+		jobID, _ := strconv.Atoi(parts[1])
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		j := p.job(jobID)
+		if j == nil {
+			writeError(w, http.StatusNotFound, "404 Not found")
+			return
+		}
+
+		if len(parts) == 3 && parts[2] == "play" && r.Method == http.MethodPost {
+			if j.Status != "manual" && j.Status != "canceled" && j.Status != "skipped" {
+				writeError(w, http.StatusBadRequest, "400 Unplayable Job")
+				return
+			}
+			j.Status = p.JobStatusAfterPlay
+		}
+		writeJSON(w, http.StatusOK, j)
+
+	default:
+		writeError(w, http.StatusNotFound, "404 Not Found")
+	}
+}
+
+// job must be called with the server mutex held.
+func (p *Project) job(id int) *burnin.Job {
+	for _, jobs := range p.pipelineJobs {
+		for _, j := range jobs {
+			if j.ID == id {
+				return j
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveRunners(w http.ResponseWriter, r *http.Request, segments []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if segments[0] == "all" {
+		runners := make([]burnin.Runner, 0)
+		if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+			for _, rn := range s.runners {
+				runners = append(runners, rn.Runner)
+			}
+		}
+		w.Header().Set("x-total-pages", "1")
+		writeJSON(w, http.StatusOK, runners)
+		return
+	}
+
+	id, _ := strconv.Atoi(segments[0])
+	for _, rn := range s.runners {
+		if rn.ID != id {
+			continue
+		}
+
+		if r.Method == http.MethodPut {
+			var payload struct {
+				Active *bool `json:"active"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if payload.Active != nil {
+				rn.Active = *payload.Active
+				rn.Status = "online"
+				if !rn.Active {
+					rn.Status = "paused"
+				}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			burnin.Runner
+			Tags []string `json:"tag_list"`
+		}{rn.Runner, rn.tags})
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "404 Not found")
+}
+
+func listTree(files map[string]string, dir string) []burnin.FileInfo {
+	dir = strings.Trim(dir, "/")
+	seen := make(map[string]bool)
+	items := make([]burnin.FileInfo, 0)
+
+	for filePath := range files {
+		rel := filePath
+		if dir != "" {
+			if !strings.HasPrefix(filePath, dir+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(filePath, dir+"/")
+		}
+
+		name := strings.Split(rel, "/")[0]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		entryType, mode := "blob", "100644"
+		if strings.Contains(rel, "/") {
+			entryType, mode = "tree", "040000"
+		}
+
+		items = append(items, burnin.FileInfo{
+			ID:   fakeSHA(filePath),
+			Name: name,
+			Type: entryType,
+			Path: path.Join(dir, name),
+			Mode: mode,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
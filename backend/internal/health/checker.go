@@ -0,0 +1,282 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// Options of the checker. Zero values use the defaults.
+type Options struct {
+	Window      time.Duration // how long the node is watched, default 5 minutes
+	Interval    time.Duration // between two polls, default 15 seconds
+	MinPeers    int           // fewer peers at the end of the window make the node unhealthy
+	RPCPort     int           // of the HTTP JSON-RPC server, default 9933
+	MetricsPort int           // of the Prometheus endpoint, default 9615
+}
+
+func (o Options) withDefaults() Options {
+	if o.Window == 0 {
+		o.Window = 5 * time.Minute
+	}
+	if o.Interval == 0 {
+		o.Interval = 15 * time.Second
+	}
+	if o.RPCPort == 0 {
+		o.RPCPort = 9933
+	}
+	if o.MetricsPort == 0 {
+		o.MetricsPort = 9615
+	}
+	return o
+}
+
+// Checker implements burnin.HealthChecker by polling the JSON-RPC server and the Prometheus endpoint of the node.
+// The metrics stand in for the RPC server, which is often only reachable from the host itself.
+type Checker struct {
+	opts       Options
+	httpClient *http.Client
+	// now and sleep default to the real clock, tests replace them to skip the window
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func NewChecker(opts Options) *Checker {
+	return &Checker{
+		opts: opts.withDefaults(),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// status is what a single poll found out about the node.
+type status struct {
+	version string
+	peers   int
+	block   int64
+}
+
+// CheckHealth polls the node until the window is over. The node is healthy if it still responds at the end, runs the
+// version built from commitSHA (if not empty), has enough peers and its best block advanced.
+func (c *Checker) CheckHealth(host, commitSHA string) burnin.HealthCheck {
+	var (
+		first, last *status
+		lastErr     error
+		start       = c.now()
+	)
+
+	for {
+		s, err := c.poll(host)
+		if err != nil {
+			log.Printf("health check of %s: %v\n", host, err)
+		} else if first == nil {
+			first = &s
+		}
+		last, lastErr = &s, err
+
+		elapsed := c.now().Sub(start)
+		if elapsed >= c.opts.Window {
+			break
+		}
+		wait := c.opts.Interval
+		if remaining := c.opts.Window - elapsed; remaining < wait {
+			wait = remaining
+		}
+		c.sleep(wait)
+	}
+
+	check := burnin.HealthCheck{CheckedAt: c.now().UTC()}
+	switch {
+	case first == nil:
+		check.Problems = append(check.Problems, fmt.Sprintf("not responding: %v", lastErr))
+	case lastErr != nil:
+		check.Problems = append(check.Problems, fmt.Sprintf("stopped responding: %v", lastErr))
+		check.Version, check.FirstBlock, check.LastBlock = first.version, first.block, first.block
+	default:
+		check.Version, check.Peers = last.version, last.peers
+		check.FirstBlock, check.LastBlock = first.block, last.block
+		check.Problems = c.problems(first, last, commitSHA)
+	}
+	check.Healthy = len(check.Problems) == 0
+	return check
+}
+
+func (c *Checker) problems(first, last *status, commitSHA string) []string {
+	var problems []string
+	if commitSHA != "" && !versionMatches(last.version, commitSHA) {
+		version := last.version
+		if version == "" {
+			version = "an unknown version"
+		}
+		problems = append(problems, fmt.Sprintf("running %s instead of commit %s", version, commitSHA))
+	}
+	if last.peers < c.opts.MinPeers {
+		problems = append(problems, fmt.Sprintf("%d peers, expected at least %d", last.peers, c.opts.MinPeers))
+	}
+	if last.block <= first.block {
+		problems = append(problems, fmt.Sprintf("best block not advancing (#%d)", last.block))
+	}
+	return problems
+}
+
+// versionHash matches the commit in versions such as "0.9.30-a7810560c0f" or "0.8.26-1-c3f9b0e-x86_64-linux-gnu".
+var versionHash = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// versionMatches tells whether one part of the version is an abbreviation of the commit.
+func versionMatches(version, commitSHA string) bool {
+	for _, part := range strings.Split(version, "-") {
+		if versionHash.MatchString(part) && strings.HasPrefix(strings.ToLower(commitSHA), part) {
+			return true
+		}
+	}
+	return false
+}
+
+// poll asks the RPC server first and fills in what it could not tell from the metrics. It only fails if neither of
+// them knows the best block.
+func (c *Checker) poll(host string) (status, error) {
+	s, rpcErr := c.pollRPC(host)
+	if rpcErr == nil && s.version != "" {
+		return s, nil
+	}
+
+	m, metricsErr := c.pollMetrics(host)
+	if rpcErr == nil {
+		if metricsErr == nil {
+			s.version = m.version
+		}
+		return s, nil
+	}
+	if metricsErr != nil {
+		return status{}, fmt.Errorf("rpc: %v, metrics: %v", rpcErr, metricsErr)
+	}
+	return m, nil
+}
+
+func (c *Checker) pollRPC(host string) (status, error) {
+	var (
+		s      status
+		health struct {
+			Peers int `json:"peers"`
+		}
+		header struct {
+			Number string `json:"number"` // hex encoded, e.g. "0x1a2b"
+		}
+	)
+
+	if err := c.call(host, "system_health", &health); err != nil {
+		return s, err
+	}
+	if err := c.call(host, "chain_getHeader", &header); err != nil {
+		return s, err
+	}
+	block, err := strconv.ParseInt(strings.TrimPrefix(header.Number, "0x"), 16, 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid block number '%s'", header.Number)
+	}
+	s.peers, s.block = health.Peers, block
+
+	// not every node exposes system_version, in which case the metrics have it
+	if err := c.call(host, "system_version", &s.version); err != nil {
+		log.Printf("health check of %s: %v\n", host, err)
+	}
+	return s, nil
+}
+
+func (c *Checker) call(host, method string, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": []string{}})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s:%d", host, c.opts.RPCPort)
+	response, err := c.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", method, response.Status)
+	}
+
+	var rpcResponse struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&rpcResponse); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	if rpcResponse.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, rpcResponse.Error.Message, rpcResponse.Error.Code)
+	}
+	if err := json.Unmarshal(rpcResponse.Result, result); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	return nil
+}
+
+func (c *Checker) pollMetrics(host string) (status, error) {
+	var s status
+
+	response, err := c.httpClient.Get(fmt.Sprintf("http://%s:%d/metrics", host, c.opts.MetricsPort))
+	if err != nil {
+		return s, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return s, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return s, err
+	}
+
+	foundBlock := false
+	for _, m := range parseMetrics(string(body)) {
+		switch {
+		case strings.HasSuffix(m.name, "_block_height") && m.labels["status"] == "best":
+			s.block, foundBlock = int64(m.value), true
+		case strings.HasSuffix(m.name, "_sub_libp2p_peers_count"):
+			s.peers = int(m.value)
+		case strings.HasSuffix(m.name, "_build_info"):
+			s.version = m.labels["version"]
+		}
+	}
+	if !foundBlock {
+		return s, fmt.Errorf("no best block height in metrics")
+	}
+	return s, nil
+}
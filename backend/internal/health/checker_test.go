@@ -0,0 +1,183 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// node serves the RPC and metrics endpoints of a node producing a block per request.
+type node struct {
+	rpc, metrics *httptest.Server
+	block        int64
+	peers        int
+	version      string
+	rpcDown      bool
+	slept        []time.Duration
+}
+
+func newNode(t *testing.T) *node {
+	n := &node{peers: 12, version: "0.9.30-a7810560c0f", block: 1000}
+
+	n.rpc = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.rpcDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct{ Method string }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		var result interface{}
+		switch req.Method {
+		case "system_health":
+			result = map[string]interface{}{"peers": n.peers, "isSyncing": false, "shouldHavePeers": true}
+		case "chain_getHeader":
+			result = map[string]string{"number": fmt.Sprintf("0x%x", atomic.AddInt64(&n.block, 1))}
+		case "system_version":
+			result = n.version
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result}))
+	}))
+	t.Cleanup(n.rpc.Close)
+
+	n.metrics = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/metrics", r.URL.Path)
+		fmt.Fprintf(w, `# HELP substrate_block_height Block height info of the chain
+# TYPE substrate_block_height gauge
+substrate_block_height{status="best",chain="kusama"} %d
+substrate_block_height{status="finalized",chain="kusama"} 900
+substrate_build_info{chain="kusama",name="kusama-fullnode-uw1-0",version="%s"} 1
+substrate_sub_libp2p_peers_count{chain="kusama"} %d
+`, atomic.AddInt64(&n.block, 1), n.version, n.peers)
+	}))
+	t.Cleanup(n.metrics.Close)
+
+	return n
+}
+
+func (n *node) checker(t *testing.T, opts Options) *Checker {
+	port := func(s *httptest.Server) int {
+		_, p, err := net.SplitHostPort(s.Listener.Addr().String())
+		require.NoError(t, err)
+		port, err := strconv.Atoi(p)
+		require.NoError(t, err)
+		return port
+	}
+
+	opts.RPCPort, opts.MetricsPort = port(n.rpc), port(n.metrics)
+	c := NewChecker(opts)
+
+	// a fake clock which only advances while sleeping, the default window passes without waiting
+	now := time.Date(2022, 11, 3, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	c.sleep = func(d time.Duration) {
+		n.slept = append(n.slept, d)
+		now = now.Add(d)
+	}
+	return c
+}
+
+func TestChecker_CheckHealth(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	n := newNode(t)
+	check := n.checker(t, Options{MinPeers: 3}).CheckHealth("127.0.0.1", "a7810560c0f62dd6d347e710a5e2a64da465c109")
+	require.True(t, check.Healthy, "%v", check.Problems)
+	require.Equal(t, "0.9.30-a7810560c0f", check.Version)
+	require.Equal(t, 12, check.Peers)
+	require.Greater(t, check.LastBlock, check.FirstBlock)
+	require.Equal(t, time.Date(2022, 11, 3, 12, 5, 0, 0, time.UTC), check.CheckedAt)
+	require.Len(t, n.slept, 20, "polls every 15 seconds for 5 minutes")
+
+	check = n.checker(t, Options{MinPeers: 20}).CheckHealth("127.0.0.1", "f52b0b01d8f27fdb387667de5a56da2754ce77a1")
+	require.False(t, check.Healthy)
+	require.Equal(t, []string{
+		"running 0.9.30-a7810560c0f instead of commit f52b0b01d8f27fdb387667de5a56da2754ce77a1",
+		"12 peers, expected at least 20",
+	}, check.Problems)
+
+	// the metrics stand in for the RPC server
+	n.rpcDown = true
+	check = n.checker(t, Options{MinPeers: 3}).CheckHealth("127.0.0.1", "a7810560c0f62dd6d347e710a5e2a64da465c109")
+	require.True(t, check.Healthy, "%v", check.Problems)
+	require.Equal(t, 12, check.Peers)
+
+	n.metrics.Close()
+	check = n.checker(t, Options{}).CheckHealth("127.0.0.1", "")
+	require.False(t, check.Healthy)
+	require.Len(t, check.Problems, 1)
+	require.Contains(t, check.Problems[0], "not responding: rpc: system_health: unexpected status 503")
+}
+
+func TestChecker_CheckHealth_stalled(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	n := newNode(t)
+	n.rpcDown = true
+	n.metrics.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `substrate_block_height{status="best"} 1000`)
+	})
+
+	check := n.checker(t, Options{Window: time.Minute, Interval: 25 * time.Second}).
+		CheckHealth("127.0.0.1", "a7810560c0f62dd6d347e710a5e2a64da465c109")
+	require.Equal(t, []time.Duration{25 * time.Second, 25 * time.Second, 10 * time.Second}, n.slept,
+		"the last wait ends with the window")
+	require.False(t, check.Healthy)
+	require.Equal(t, []string{
+		"running an unknown version instead of commit a7810560c0f62dd6d347e710a5e2a64da465c109",
+		"best block not advancing (#1000)",
+	}, check.Problems)
+	require.Equal(t, "unhealthy (running an unknown version instead of commit a7810560c0f62dd6d347e710a5e2a64da465c109; "+
+		"best block not advancing (#1000)): version unknown, 0 peers, best block #1000 to #1000", check.Summary())
+}
+
+func Test_versionMatches(t *testing.T) {
+	sha := "c3f9b0e8a1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6"
+	require.True(t, versionMatches("0.9.30-c3f9b0e8a1d", sha))
+	require.True(t, versionMatches("0.8.26-1-c3f9b0e-x86_64-linux-gnu", sha))
+	require.False(t, versionMatches("0.9.30-a7810560c0f", sha))
+	require.False(t, versionMatches("0.9.30", sha))
+	require.False(t, versionMatches("", sha))
+}
+
+func Test_parseMetrics(t *testing.T) {
+	metrics := parseMetrics(`# TYPE substrate_block_height gauge
+substrate_block_height{status="best",chain="kusama"} 1234 1610469388000
+substrate_build_info{name="a \"quoted\" name",version="0.9.30-a7810560c0f"} 1
+process_start_time_seconds 1.610469388e+09
+broken{status="best" 1
+`)
+	require.Equal(t, []metric{
+		{name: "substrate_block_height", labels: map[string]string{"status": "best", "chain": "kusama"}, value: 1234},
+		{name: "substrate_build_info", labels: map[string]string{"name": `a "quoted" name`, "version": "0.9.30-a7810560c0f"}, value: 1},
+		{name: "process_start_time_seconds", labels: map[string]string{}, value: 1610469388},
+	}, metrics)
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package health
+
+import (
+	"strconv"
+	"strings"
+)
+
+// metric is a sample in the Prometheus text format, e.g.
+//
+//	substrate_block_height{status="best",chain="kusama"} 1234
+type metric struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseMetrics skips comments and lines it does not understand.
+func parseMetrics(text string) []metric {
+	var metrics []metric
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := metric{labels: make(map[string]string)}
+		rest := line
+		if i := strings.IndexAny(line, "{ "); i < 0 {
+			continue
+		} else {
+			m.name, rest = line[:i], line[i:]
+		}
+
+		if strings.HasPrefix(rest, "{") {
+			var ok bool
+			if m.labels, rest, ok = parseLabels(rest[1:]); !ok {
+				continue
+			}
+		}
+
+		// the value may be followed by a timestamp
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		m.value = value
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// parseLabels parses `status="best",chain="kusama"}` and returns what follows the closing brace.
+func parseLabels(s string) (map[string]string, string, bool) {
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], true
+		}
+
+		eq := strings.Index(s, `="`)
+		if eq < 0 {
+			return nil, "", false
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s):
+				i++
+				if s[i] == 'n' {
+					value.WriteByte('\n')
+				} else {
+					value.WriteByte(s[i])
+				}
+			case s[i] == '"':
+				s, closed = s[i+1:], true
+			default:
+				value.WriteByte(s[i])
+			}
+			if closed {
+				break
+			}
+		}
+		if !closed {
+			return nil, "", false
+		}
+		labels[name] = value.String()
+	}
+}
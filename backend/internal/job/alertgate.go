@@ -0,0 +1,59 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"log"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// checkAlerts returns a *burnin.FiringAlertsError if gating alerts are firing for the host. Silences are ignored, as
+// the burn-in's own silence might still be active during the grace period.
+func checkAlerts(alertmanager burnin.Alertmanager, hostname string, gate AlertGate) error {
+	if gate.Disabled {
+		return nil
+	}
+
+	if gate.Delay > 0 {
+		log.Printf("waiting %s before checking alerts of host %s\n", gate.Delay, hostname)
+		sleep := gate.sleep
+		if sleep == nil {
+			sleep = time.Sleep
+		}
+		sleep(gate.Delay)
+	}
+
+	log.Printf("checking alerts of host %s\n", hostname)
+	alerts, err := alertmanager.GetAlerts(hostMatchers(hostname))
+	if err != nil {
+		return err
+	}
+
+	var firing []burnin.Alert
+	for _, a := range alerts {
+		if gate.gating(a) {
+			firing = append(firing, a)
+		}
+	}
+
+	if len(firing) > 0 {
+		return &burnin.FiringAlertsError{Hostname: hostname, Alerts: firing}
+	}
+
+	return nil
+}
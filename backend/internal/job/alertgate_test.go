@@ -0,0 +1,153 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func Test_checkAlerts(t *testing.T) {
+	alerts := []burnin.Alert{
+		{Labels: map[string]string{"alertname": "NodeDown", "severity": "critical"}},
+		{Labels: map[string]string{"alertname": "BlockProductionSlow", "severity": "warning"}},
+		{Labels: map[string]string{"alertname": "DiskFull", "severity": "critical", "team": "devops"}},
+	}
+
+	tests := []struct {
+		name     string
+		gate     AlertGate
+		expected []string
+	}{
+		{"default is critical", AlertGate{}, []string{"NodeDown", "DiskFull"}},
+		{"disabled", AlertGate{Disabled: true}, nil},
+		{"by name", AlertGate{AlertNames: []string{"DiskFull", "Unknown"}}, []string{"DiskFull"}},
+		{"by labels", AlertGate{Labels: map[string]string{"severity": "warning"}}, []string{"BlockProductionSlow"}},
+		{"no labels", AlertGate{Labels: map[string]string{}}, []string{"NodeDown", "BlockProductionSlow", "DiskFull"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alertmanager := &mockAlertManager{alerts: alerts}
+			err := checkAlerts(alertmanager, "kusama-fullnode-uw1-0", tt.gate)
+
+			if tt.expected == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			var firing *burnin.FiringAlertsError
+			require.True(t, errors.As(err, &firing))
+			require.Equal(t, "kusama-fullnode-uw1-0", firing.Hostname)
+			var names []string
+			for _, a := range firing.Alerts {
+				names = append(names, a.Labels["alertname"])
+			}
+			require.Equal(t, tt.expected, names)
+			require.Equal(t, [][]burnin.AlertMatcher{hostMatchers("kusama-fullnode-uw1-0")}, alertmanager.getAlertsCalls)
+		})
+	}
+}
+
+func Test_checkAlerts_delay(t *testing.T) {
+	var slept []time.Duration
+	gate := AlertGate{Delay: 2 * time.Minute, sleep: func(d time.Duration) { slept = append(slept, d) }}
+
+	alertmanager := new(mockAlertManager)
+	require.NoError(t, checkAlerts(alertmanager, "kusama-fullnode-uw1-0", gate))
+	require.Equal(t, []time.Duration{2 * time.Minute}, slept)
+	require.Len(t, alertmanager.getAlertsCalls, 1)
+
+	gate.Disabled = true
+	require.NoError(t, checkAlerts(alertmanager, "kusama-fullnode-uw1-0", gate))
+	require.Len(t, slept, 1, "a disabled gate does not wait")
+}
+
+func Test_ProcessUpdate_firing_alerts(t *testing.T) {
+	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1610469388.toml", false, false, false, validDiffContent)
+	gitlab := newMockGitlabClient(diff)
+	alertmanager := &mockAlertManager{alerts: []burnin.Alert{
+		{Labels: map[string]string{"alertname": "NodeDown", "severity": "critical"}},
+	}}
+	ansible := new(mockDeploymentDriver)
+	notifier := new(mockNotifier)
+
+	err := ProcessUpdate("testdata", "master", gitlab, alertmanager, ansible, notifier, Options{})
+
+	require.EqualError(t, err, "1 alert(s) firing on kusama-fullnode-uw1-0: NodeDown")
+	var jobErr *burnin.JobError
+	require.True(t, errors.As(err, &jobErr), "error should carry the deployment")
+	require.Equal(t, "kusama-fullnode-uw1-0", jobErr.Deployment.DeployedOn)
+	require.Len(t, ansible.runPlaybookCalls, 1)
+	require.Equal(t, []string{"alert-1234"}, alertmanager.deleteSilenceCalls, "silence should expire before the check")
+
+	require.Len(t, gitlab.updateFileCalls, 1)
+	var recorded burnin.Deployment
+	require.NoError(t, toml.Unmarshal(gitlab.updateFileCalls[0].content, &recorded))
+	require.Len(t, recorded.History, 1)
+	require.Equal(t, "failed", recorded.History[0].Outcome)
+	require.Equal(t, "1 alert(s) firing on kusama-fullnode-uw1-0: NodeDown", recorded.History[0].Details)
+	require.Len(t, notifier.updateNotificationCalls, 0)
+
+	// rolled back if there is a previous binary
+	diff = mkCommitDiff("runs/run-kusama-fullnode-0-1610470000.toml", false, false, false, validDiffContent)
+	gitlab = newMockGitlabClient(diff)
+	ansible = new(mockDeploymentDriver)
+	notifier = new(mockNotifier)
+
+	err = ProcessUpdate("testdata", "master", gitlab, alertmanager, ansible, notifier, Options{})
+
+	var firing *burnin.FiringAlertsError
+	require.True(t, errors.As(err, &firing), "%v", err)
+	require.Len(t, ansible.runPlaybookCalls, 2)
+	require.Equal(
+		t,
+		"https://gitlab.example.com/mocks/mockproject/-/jobs/748812/artifacts/raw/artifacts/polkadot",
+		ansible.runPlaybookCalls[1].NodeBinary.String(),
+	)
+	require.Len(t, notifier.rollbackNotificationCalls, 1)
+	rolledBack := notifier.rollbackNotificationCalls[0]
+	require.Equal(t, "1 alert(s) firing on kusama-fullnode-uw1-0: NodeDown", rolledBack.RollbackReason)
+}
+
+func Test_ProcessDeploy_firing_alerts(t *testing.T) {
+	runFile := "runs/run-kusama-fullnode-0-1602856340.toml"
+	gitlab := newMockGitlabClient(mkCommitDiff(runFile, true, false, false, ""))
+	alertmanager := &mockAlertManager{alerts: []burnin.Alert{
+		{Labels: map[string]string{"alertname": "NodeDown", "severity": "critical"}},
+	}}
+	notifier := new(mockNotifier)
+
+	ansible := new(mockDeploymentDriver)
+
+	err := ProcessDeploy("testdata", "master", "kusama-unit-test-hostname", gitlab, alertmanager, ansible, notifier, Options{})
+
+	require.EqualError(t, err, "1 alert(s) firing on kusama-unit-test-hostname: NodeDown")
+	require.Len(t, gitlab.updateFileCalls, 1, "the node runs, so the deployment is recorded")
+	var recorded burnin.Deployment
+	require.NoError(t, toml.Unmarshal(gitlab.updateFileCalls[0].content, &recorded))
+	require.Equal(t, "kusama-unit-test-hostname", recorded.DeployedOn)
+	require.Len(t, recorded.History, 1)
+	require.Equal(t, "deploy", recorded.History[0].Action)
+	require.Equal(t, "failed", recorded.History[0].Outcome)
+	require.Equal(t, "1 alert(s) firing on kusama-unit-test-hostname: NodeDown", recorded.History[0].Details)
+	require.Len(t, notifier.deploymentNotificationCalls, 0)
+}
@@ -0,0 +1,348 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+const chatOpsUsage = `usage:
+!burnin request <pull request> [<commit sha>] <network>:<node type>=<count>...
+!burnin update <request id> <commit sha>
+!burnin stop <request id>
+!burnin list`
+
+var (
+	commitSHARegexp  = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+	nodeSpecRegexp   = regexp.MustCompile(`^([a-z0-9-]+):([a-z]+)=([0-9]+)$`)
+	requestIDRegexp  = regexp.MustCompile(`^[0-9]+$`)
+	commitSHALine    = regexp.MustCompile(`(?m)^[ \t]*commit_sha[ \t]*=.*$`)
+	pullRequestLine  = regexp.MustCompile(`(?m)^[ \t]*pull_request[ \t]*=.*$`)
+	pullRequestShort = regexp.MustCompile(`^(?:polkadot#|#)?([0-9]+)$`)
+)
+
+// ChatOps turns chat commands into commits of "request" and "run" files, just like the frontend does. The CI jobs
+// triggered by these commits do the actual work.
+type ChatOps struct {
+	Repo         burnin.Repo
+	Branch       string
+	AllowedUsers []string // IDs of the chat users who may use the commands
+
+	now func() time.Time // only set in tests
+}
+
+// Handle runs the command in the message. It returns false for messages which aren't commands, otherwise the reply to
+// the sender.
+func (o *ChatOps) Handle(sender, message string) (string, bool) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 || fields[0] != "!burnin" {
+		return "", false
+	}
+
+	if !o.allowed(sender) {
+		log.Printf("ignoring command from %s, who is not allowed to use the bot\n", sender)
+		return fmt.Sprintf("%s is not allowed to manage burn-ins", sender), true
+	}
+
+	if len(fields) < 2 {
+		return chatOpsUsage, true
+	}
+
+	var (
+		reply string
+		err   error
+	)
+
+	switch fields[1] {
+	case "request":
+		reply, err = o.request(sender, fields[2:])
+	case "update":
+		reply, err = o.update(sender, fields[2:])
+	case "stop":
+		reply, err = o.stop(sender, fields[2:])
+	case "list":
+		reply, err = o.list()
+	default:
+		return chatOpsUsage, true
+	}
+
+	if err != nil {
+		log.Printf("command '%s' from %s failed: %v\n", message, sender, err)
+		return fmt.Sprintf("Command failed: %v", err), true
+	}
+
+	return reply, true
+}
+
+func (o *ChatOps) allowed(sender string) bool {
+	for _, u := range o.AllowedUsers {
+		if u == sender {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ChatOps) request(sender string, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("a pull request and at least one <network>:<node type>=<count> are required")
+	}
+
+	pullRequest, err := parsePullRequest(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	request := burnin.Request{
+		PullRequest: pullRequest,
+		RequestedBy: sender,
+		Nodes:       burnin.NodesPerNetworkMap{},
+	}
+
+	for _, arg := range args[1:] {
+		if commitSHARegexp.MatchString(arg) && request.CommitSHA == "" {
+			request.CommitSHA = arg
+			continue
+		}
+
+		m := nodeSpecRegexp.FindStringSubmatch(arg)
+		if m == nil {
+			return "", fmt.Errorf("unexpected argument '%s' (must be a commit SHA or <network>:<node type>=<count>)", arg)
+		}
+
+		network, nodeType := m[1], burnin.NodeType(m[2])
+		if nodeType != burnin.FullNode && nodeType != burnin.Sentry && nodeType != burnin.Validator {
+			return "", fmt.Errorf("unknown node type '%s' (must be 'fullnode', 'sentry' or 'validator')", nodeType)
+		}
+
+		count, _ := strconv.Atoi(m[3]) // safe to ignore, the regexp only matches digits
+		if count < 1 || count > 5 {
+			return "", fmt.Errorf("the number of %s nodes on %s must be between 1 and 5", nodeType, network)
+		}
+
+		if request.Nodes[network] == nil {
+			request.Nodes[network] = map[burnin.NodeType]int{}
+		}
+		request.Nodes[network][nodeType] = count
+	}
+
+	if len(request.Nodes) == 0 {
+		return "", errors.New("at least one <network>:<node type>=<count> is required")
+	}
+
+	content, err := toml.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	requestID := strconv.FormatInt(o.currentTime().Unix(), 10)
+	requestPath := fmt.Sprintf("requests/request-%s.toml", requestID)
+	commitMsg := fmt.Sprintf("Request %s (by %s)", pullRequest, sender)
+	log.Printf("committing file %s on branch '%s'\n", requestPath, o.Branch)
+	if err := o.Repo.CreateFile(requestPath, o.Branch, commitMsg, content); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Requested burn-in %s for %s", requestID, pullRequest), nil
+}
+
+func (o *ChatOps) update(sender string, args []string) (string, error) {
+	if len(args) != 2 || !requestIDRegexp.MatchString(args[0]) || !commitSHARegexp.MatchString(args[1]) {
+		return "", errors.New("a request ID and a commit SHA are required")
+	}
+	requestID, commitSHA := args[0], args[1]
+
+	requestPath := fmt.Sprintf("requests/request-%s.toml", requestID)
+	content, err := o.Repo.GetFile(requestPath, o.Branch)
+	if err != nil {
+		return "", err
+	}
+
+	// The file is edited instead of re-encoded, so that the commit only changes commit_sha. Otherwise the "request"
+	// job can't tell what has been updated.
+	content, err = setCommitSHA(content, commitSHA)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", requestPath, err)
+	}
+
+	commitMsg := fmt.Sprintf("Update commit_sha of request %s (by %s)", requestID, sender)
+	log.Printf("updating file %s on branch '%s'\n", requestPath, o.Branch)
+	if err := o.Repo.UpdateFile(requestPath, o.Branch, commitMsg, content); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Updating burn-in %s to %s", requestID, commitSHA), nil
+}
+
+func (o *ChatOps) stop(sender string, args []string) (string, error) {
+	if len(args) != 1 || !requestIDRegexp.MatchString(args[0]) {
+		return "", errors.New("a request ID is required")
+	}
+	requestID := args[0]
+
+	deployments, err := o.runFiles()
+	if err != nil {
+		return "", err
+	}
+
+	stopped := 0
+	for _, deployment := range deployments {
+		if requestIDOfRunFile(deployment.Filename) != requestID {
+			continue
+		}
+
+		host := deployment.DeployedOn
+		if host == "" {
+			host = deployment.Filename
+		}
+
+		runPath := path.Join("runs", deployment.Filename)
+		commitMsg := o.Repo.PrefixCleanup(fmt.Sprintf("%s (by %s)", host, sender))
+		log.Printf("deleting file %s on branch '%s'\n", runPath, o.Branch)
+		if err := o.Repo.DeleteFile(runPath, o.Branch, commitMsg); err != nil {
+			return "", err
+		}
+		stopped++
+	}
+
+	if stopped == 0 {
+		return "", fmt.Errorf("no \"run\" files found for burn-in request '%s'", requestID)
+	}
+
+	return fmt.Sprintf("Stopping %d deployment(s) of burn-in %s", stopped, requestID), nil
+}
+
+func (o *ChatOps) list() (string, error) {
+	deployments, err := o.runFiles()
+	if err != nil {
+		return "", err
+	}
+
+	if len(deployments) == 0 {
+		return "No burn-ins running", nil
+	}
+
+	lines := make([]string, len(deployments))
+	for i, d := range deployments {
+		where := "not deployed yet"
+		if d.DeployedOn != "" {
+			where = "on " + d.DeployedOn
+		}
+		lines[i] = fmt.Sprintf(
+			"%s: %s (requested by %s) %s %s %s",
+			requestIDOfRunFile(d.Filename),
+			d.PullRequest,
+			d.RequestedBy,
+			d.Network,
+			d.NodeType,
+			where,
+		)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// runFiles returns the deployments in the "run" files, sorted by request ID.
+func (o *ChatOps) runFiles() ([]burnin.Deployment, error) {
+	items, err := o.Repo.ListDirectory("runs", o.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	var deployments []burnin.Deployment
+	for _, item := range items {
+		if item.Type != "blob" || !strings.HasPrefix(item.Name, "run-") || !strings.HasSuffix(item.Name, ".toml") {
+			continue
+		}
+
+		content, err := o.Repo.GetFile(path.Join("runs", item.Name), o.Branch)
+		if err != nil {
+			return nil, err
+		}
+
+		var deployment burnin.Deployment
+		if err := toml.Unmarshal(content, &deployment); err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %w", item.Name, err)
+		}
+		deployment.Filename = item.Name
+		deployments = append(deployments, deployment)
+	}
+
+	sort.SliceStable(deployments, func(i, j int) bool {
+		return requestIDOfRunFile(deployments[i].Filename) < requestIDOfRunFile(deployments[j].Filename)
+	})
+
+	return deployments, nil
+}
+
+func (o *ChatOps) currentTime() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+// requestIDOfRunFile returns the ID of the request from the name of a "run" file, e.g. "1610469388" for
+// "run-kusama-fullnode-0-1610469388.toml".
+func requestIDOfRunFile(name string) string {
+	name = strings.TrimSuffix(path.Base(name), ".toml")
+	return name[strings.LastIndex(name, "-")+1:]
+}
+
+// parsePullRequest accepts the URL of a Polkadot pull request, its number or "polkadot#<number>".
+func parsePullRequest(s string) (string, error) {
+	if m := pullRequestShort.FindStringSubmatch(s); m != nil {
+		return fmt.Sprintf("https://github.com/paritytech/polkadot/pull/%s", m[1]), nil
+	}
+
+	if !strings.HasPrefix(s, "https://github.com/paritytech/polkadot/pull/") {
+		return "", fmt.Errorf(
+			"invalid pull request '%s'. only https://github.com/paritytech/polkadot/ is currently supported",
+			s,
+		)
+	}
+
+	return s, nil
+}
+
+func setCommitSHA(content []byte, commitSHA string) ([]byte, error) {
+	line := []byte(fmt.Sprintf("commit_sha = %q", commitSHA))
+
+	if commitSHALine.Match(content) {
+		return commitSHALine.ReplaceAllLiteral(content, line), nil
+	}
+
+	loc := pullRequestLine.FindIndex(content)
+	if loc == nil {
+		return nil, errors.New("no pull_request found")
+	}
+
+	updated := append([]byte{}, content[:loc[1]]...)
+	updated = append(updated, '\n')
+	updated = append(updated, line...)
+	return append(updated, content[loc[1]:]...), nil
+}
@@ -0,0 +1,141 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+const chatOpsUser = "@alice:matrix.example.com"
+
+func newChatOps(files map[string]string) (*ChatOps, *mockGitlabClient) {
+	gitlab := &mockGitlabClient{files: files}
+	return &ChatOps{
+		Repo:         gitlab,
+		Branch:       "master",
+		AllowedUsers: []string{chatOpsUser},
+		now:          func() time.Time { return time.Unix(1610469388, 0) },
+	}, gitlab
+}
+
+func TestChatOps_Handle(t *testing.T) {
+	chatOps, gitlab := newChatOps(nil)
+
+	_, ok := chatOps.Handle(chatOpsUser, "good morning")
+	require.False(t, ok)
+
+	reply, ok := chatOps.Handle("@mallory:matrix.example.com", "!burnin list")
+	require.True(t, ok)
+	require.Contains(t, reply, "not allowed")
+
+	reply, ok = chatOps.Handle(chatOpsUser, "!burnin")
+	require.True(t, ok)
+	require.Equal(t, chatOpsUsage, reply)
+
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin request 2013 kusama:archive=1")
+	require.Contains(t, reply, "unknown node type 'archive'")
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin request 2013 kusama:fullnode=6")
+	require.Contains(t, reply, "between 1 and 5")
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin request https://github.com/paritytech/substrate/pull/1 kusama:fullnode=1")
+	require.Contains(t, reply, "invalid pull request")
+	require.Empty(t, gitlab.createFileCalls)
+}
+
+func TestChatOps_request(t *testing.T) {
+	chatOps, gitlab := newChatOps(nil)
+
+	reply, ok := chatOps.Handle(
+		chatOpsUser,
+		"!burnin request polkadot#2013 a7810560c0f62dd6d347e710a5e2a64da465c109 kusama:fullnode=1 westend:validator=2",
+	)
+	require.True(t, ok)
+	require.Equal(t, "Requested burn-in 1610469388 for https://github.com/paritytech/polkadot/pull/2013", reply)
+
+	require.Len(t, gitlab.createFileCalls, 1)
+	call := gitlab.createFileCalls[0]
+	require.Equal(t, "requests/request-1610469388.toml", call.path)
+	require.Equal(t, "master", call.branch)
+	require.Equal(t, "Request https://github.com/paritytech/polkadot/pull/2013 (by @alice:matrix.example.com)", call.commitMsg)
+
+	var request burnin.Request
+	require.NoError(t, toml.Unmarshal(call.content, &request))
+	require.Equal(t, burnin.Request{
+		PullRequest: "https://github.com/paritytech/polkadot/pull/2013",
+		CommitSHA:   "a7810560c0f62dd6d347e710a5e2a64da465c109",
+		RequestedBy: chatOpsUser,
+		Nodes: burnin.NodesPerNetworkMap{
+			"kusama":  {burnin.FullNode: 1},
+			"westend": {burnin.Validator: 2},
+		},
+	}, request)
+}
+
+func TestChatOps_update(t *testing.T) {
+	chatOps, gitlab := newChatOps(map[string]string{
+		"requests/request-1.toml": "pull_request = \"https://github.com/paritytech/polkadot/pull/2013\"\ncommit_sha = \"f52b0b01\"\nrequested_by = \"mxinden\"\n",
+		"requests/request-2.toml": "pull_request = \"https://github.com/paritytech/polkadot/pull/2013\"\nrequested_by = \"mxinden\"\n",
+	})
+
+	reply, _ := chatOps.Handle(chatOpsUser, "!burnin update 1 a7810560c0f62dd6d347e710a5e2a64da465c109")
+	require.Equal(t, "Updating burn-in 1 to a7810560c0f62dd6d347e710a5e2a64da465c109", reply)
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin update 2 a7810560")
+	require.Equal(t, "Updating burn-in 2 to a7810560", reply)
+
+	require.Len(t, gitlab.updateFileCalls, 2)
+	require.Equal(t, "requests/request-1.toml", gitlab.updateFileCalls[0].path)
+	require.Equal(
+		t,
+		"pull_request = \"https://github.com/paritytech/polkadot/pull/2013\"\ncommit_sha = \"a7810560c0f62dd6d347e710a5e2a64da465c109\"\nrequested_by = \"mxinden\"\n",
+		string(gitlab.updateFileCalls[0].content),
+	)
+	require.Equal(
+		t,
+		"pull_request = \"https://github.com/paritytech/polkadot/pull/2013\"\ncommit_sha = \"a7810560\"\nrequested_by = \"mxinden\"\n",
+		string(gitlab.updateFileCalls[1].content),
+	)
+
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin update 3 a7810560")
+	require.Contains(t, reply, "Command failed")
+	require.Len(t, gitlab.updateFileCalls, 2)
+}
+
+func TestChatOps_stopAndList(t *testing.T) {
+	chatOps, gitlab := newChatOps(map[string]string{
+		"runs/run-kusama-fullnode-0-1.toml": "pull_request = \"https://github.com/paritytech/polkadot/pull/2013\"\nrequested_by = \"mxinden\"\nnetwork = \"kusama\"\nnode_type = \"fullnode\"\ndeployed_on = \"kusama-fullnode-uw1-0\"\n",
+		"runs/run-kusama-fullnode-1-1.toml": "pull_request = \"https://github.com/paritytech/polkadot/pull/2013\"\nrequested_by = \"mxinden\"\nnetwork = \"kusama\"\nnode_type = \"fullnode\"\n",
+		"runs/run-westend-sentry-0-2.toml":  "pull_request = \"https://github.com/paritytech/polkadot/pull/2014\"\nrequested_by = \"haiko\"\nnetwork = \"westend\"\nnode_type = \"sentry\"\ndeployed_on = \"westend-sentry-uw1-0\"\n",
+	})
+
+	reply, _ := chatOps.Handle(chatOpsUser, "!burnin list")
+	require.Equal(t, `1: https://github.com/paritytech/polkadot/pull/2013 (requested by mxinden) kusama fullnode on kusama-fullnode-uw1-0
+1: https://github.com/paritytech/polkadot/pull/2013 (requested by mxinden) kusama fullnode not deployed yet
+2: https://github.com/paritytech/polkadot/pull/2014 (requested by haiko) westend sentry on westend-sentry-uw1-0`, reply)
+
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin stop 1")
+	require.Equal(t, "Stopping 2 deployment(s) of burn-in 1", reply)
+	require.Equal(t, []commitFileArgs{
+		{path: "runs/run-kusama-fullnode-0-1.toml", branch: "master", commitMsg: "[cleanup] kusama-fullnode-uw1-0 (by @alice:matrix.example.com)"},
+		{path: "runs/run-kusama-fullnode-1-1.toml", branch: "master", commitMsg: "[cleanup] run-kusama-fullnode-1-1.toml (by @alice:matrix.example.com)"},
+	}, gitlab.deleteFileCalls)
+
+	reply, _ = chatOps.Handle(chatOpsUser, "!burnin stop 3")
+	require.Contains(t, reply, "no \"run\" files found for burn-in request '3'")
+}
@@ -30,10 +30,11 @@ import (
 func ProcessCleanup(
 	baseBranch string,
 	gitlab burnin.Gitlab,
-	alertmanager burnin.Alertmanager,
-	ansible burnin.AnsibleDriver,
-	matrix burnin.Matrix,
-) error {
+	alertmanagers burnin.Alertmanagers,
+	driver burnin.DeploymentDriver,
+	notifier burnin.Notifier,
+	opts Options,
+) (err error) {
 	diffs, err := diffsToCurrentCommit(baseBranch, gitlab)
 	if err != nil {
 		return err
@@ -50,15 +51,17 @@ func ProcessCleanup(
 	if err != nil {
 		return err
 	}
+	defer func() { err = withDeployment(deployment, err) }()
 
 	if deployment.DeployedOn != "" {
+		alertmanager := alertmanagers.ForNetwork(deployment.Network)
 		log.Printf("creating silence for host %s\n", deployment.DeployedOn)
 		comment := fmt.Sprintf("Cleaning up burn-in test for %s on %s", deployment.PullRequest, deployment.DeployedOn)
-		silenceID, err := createSilence(alertmanager, deployment.DeployedOn, comment)
+		s, err := startSilence(alertmanager, hostMatchers(deployment.DeployedOn), comment, opts)
 		if err != nil {
 			return err
 		}
-		log.Printf("silence id: %s\n", silenceID)
+		log.Printf("silence id: %s\n", s.silence.ID)
 
 		playbook := fmt.Sprintf("%s-nodes.yml", deployment.Network)
 		customBinaryURL, _ := url.Parse(polkadotNightlyBuildURL) // safe to ignore errors as the input is a constant
@@ -69,8 +72,16 @@ func ProcessCleanup(
 			fqdn,
 			customBinaryURL,
 		)
-		if err := ansible.RunPlaybook(playbook, fqdn, customBinaryURL, false, deployment.DeployedOn, nil); err != nil {
-			return err
+		err = driver.RunPlaybook(burnin.PlaybookRun{
+			Playbook:       playbook,
+			Network:        deployment.Network,
+			RunOn:          fqdn,
+			NodePublicName: deployment.DeployedOn,
+			NodeBinary:     customBinaryURL,
+		})
+		finishSilence(s, opts)
+		if err != nil {
+			return playbookFailed(playbook, err)
 		}
 
 		log.Printf("unpausing gitlab runner on %s\n", deployment.DeployedOn)
@@ -105,7 +116,7 @@ func ProcessCleanup(
 	}
 
 	if deployment.DeployedOn != "" {
-		return matrix.SendCleanupNotification(deployment)
+		return notifier.SendCleanupNotification(deployment)
 	}
 
 	return nil
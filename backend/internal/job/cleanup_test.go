@@ -28,10 +28,10 @@ func Test_ProcessCleanup(t *testing.T) {
 	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1602856341.toml", false, false, true, "@@ -1,8 +0,0 @@\n-pull_request=\"https://github.com/paritytech/polkadot/pull/2013\"\n-requested_by=\"mxinden\"\n-deployed_at=2020-11-10T20:27:11.605929Z\n-network=\"kusama\"\n-deployed_on=\"kusama-unit-test-hostname\"\n-public_fqdn=\"kusama-unit-test-hostname.example.com\"\n-internal_fqdn=\"kusama-unit-test-hostname-int.example.com\"\n-custom_binary=\"https://gitlab.example.com/parity/polkadot/-/jobs/752482/artifacts/raw/artifacts/polkadot\"")
 	gitlab := newMockGitlabClient(diff)
 	alertmanager := new(mockAlertManager)
-	ansible := new(mockAnsibleDriver)
-	matrix := new(mockMatrix)
+	ansible := new(mockDeploymentDriver)
+	notifier := new(mockNotifier)
 
-	err := ProcessCleanup("master", gitlab, alertmanager, ansible, matrix)
+	err := ProcessCleanup("master", gitlab, alertmanager, ansible, notifier, Options{})
 
 	require.NoError(t, err)
 
@@ -51,11 +51,11 @@ func Test_ProcessCleanup(t *testing.T) {
 
 	require.Len(t, ansible.runPlaybookCalls, 1)
 	playbookCall := ansible.runPlaybookCalls[0]
-	require.Equal(t, "kusama-nodes.yml", playbookCall.name)
-	require.Equal(t, "kusama-unit-test-hostname.example.com", playbookCall.runOn)
-	require.Equal(t, "kusama-unit-test-hostname", playbookCall.nodePublicName)
-	require.NotNil(t, playbookCall.nodeBinary)
-	require.Equal(t, polkadotNightlyBuildURL, playbookCall.nodeBinary.String())
+	require.Equal(t, "kusama-nodes.yml", playbookCall.Playbook)
+	require.Equal(t, "kusama-unit-test-hostname.example.com", playbookCall.RunOn)
+	require.Equal(t, "kusama-unit-test-hostname", playbookCall.NodePublicName)
+	require.NotNil(t, playbookCall.NodeBinary)
+	require.Equal(t, polkadotNightlyBuildURL, playbookCall.NodeBinary.String())
 
 	require.Len(t, gitlab.deleteFileCalls, 1)
 	dfc := gitlab.deleteFileCalls[0]
@@ -63,24 +63,24 @@ func Test_ProcessCleanup(t *testing.T) {
 	require.True(t, strings.HasPrefix(dfc.commitMsg, gitlab.PrefixSkipCI("")))
 	require.Equal(t, "master", dfc.branch)
 
-	require.Len(t, matrix.requestNotificationCalls, 0)
-	require.Len(t, matrix.updateNotificationCalls, 0)
-	require.Len(t, matrix.errorNotificationCalls, 0)
-	require.Len(t, matrix.deploymentNotificationCalls, 0)
-	require.Len(t, matrix.cleanupNotificationCalls, 1)
-	matrixCall := matrix.cleanupNotificationCalls[0]
-	require.NotNil(t, matrixCall.DeployedOn)
-	require.Equal(t, "kusama-unit-test-hostname", matrixCall.DeployedOn)
+	require.Len(t, notifier.requestNotificationCalls, 0)
+	require.Len(t, notifier.updateNotificationCalls, 0)
+	require.Len(t, notifier.errorNotificationCalls, 0)
+	require.Len(t, notifier.deploymentNotificationCalls, 0)
+	require.Len(t, notifier.cleanupNotificationCalls, 1)
+	notificationCall := notifier.cleanupNotificationCalls[0]
+	require.NotNil(t, notificationCall.DeployedOn)
+	require.Equal(t, "kusama-unit-test-hostname", notificationCall.DeployedOn)
 }
 
 func Test_ProcessPendingCleanup(t *testing.T) {
 	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1631538021.toml", false, false, true, "@@ -1,8 +0,0 @@\n-pull_request=\"https://github.com/paritytech/polkadot/pull/2013\"\n-requested_by=\"mxinden\"\n-network=\"kusama\"\n-\n-custom_binary=\"https://gitlab.example.com/parity/polkadot/-/jobs/752482/artifacts/raw/artifacts/polkadot\"")
 	gitlab := newMockGitlabClient(diff)
 	alertmanager := new(mockAlertManager)
-	ansible := new(mockAnsibleDriver)
-	matrix := new(mockMatrix)
+	ansible := new(mockDeploymentDriver)
+	notifier := new(mockNotifier)
 
-	err := ProcessCleanup("master", gitlab, alertmanager, ansible, matrix)
+	err := ProcessCleanup("master", gitlab, alertmanager, ansible, notifier, Options{})
 
 	require.NoError(t, err)
 
@@ -93,11 +93,11 @@ func Test_ProcessPendingCleanup(t *testing.T) {
 	require.True(t, strings.HasPrefix(dfc.commitMsg, gitlab.PrefixSkipCI("")))
 	require.Equal(t, "master", dfc.branch)
 
-	require.Len(t, matrix.requestNotificationCalls, 0)
-	require.Len(t, matrix.updateNotificationCalls, 0)
-	require.Len(t, matrix.errorNotificationCalls, 0)
-	require.Len(t, matrix.deploymentNotificationCalls, 0)
-	require.Len(t, matrix.cleanupNotificationCalls, 0)
+	require.Len(t, notifier.requestNotificationCalls, 0)
+	require.Len(t, notifier.updateNotificationCalls, 0)
+	require.Len(t, notifier.errorNotificationCalls, 0)
+	require.Len(t, notifier.deploymentNotificationCalls, 0)
+	require.Len(t, notifier.cleanupNotificationCalls, 0)
 }
 
 func Test_parseDeletedDeploymentDiff(t *testing.T) {
@@ -16,13 +16,13 @@
 package job
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"path"
 	"strings"
-	"time"
 
 	burnin "gitlab.example.com/burn-in-tests/backend"
 )
@@ -54,18 +54,33 @@ func AddPathsToURL(u *url.URL, paths ...string) (*url.URL, error) {
 	return u, nil
 }
 
-func createSilence(alertmanager burnin.Alertmanager, targetHostname, comment string) (string, error) {
-	matchers := []burnin.AlertMatcher{
-		{
-			Name:    "instance",
-			Value:   fmt.Sprintf(".*%s.*", targetHostname),
-			IsRegex: true,
-		},
+// withDeployment attaches the deployment to a non-nil err.
+func withDeployment(deployment burnin.Deployment, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &burnin.JobError{Deployment: deployment, Err: err}
+}
+
+// playbookFailed names the playbook in errors of the Ansible driver. Failed tasks (see burnin.PlaybookError) make for
+// a short message like "playbook kusama-nodes.yml failed: task 'Restart node' failed on kusama-fullnode-uw1-0: ...",
+// which is what notifications show. Timeouts (see burnin.PlaybookTimeoutError) point out that the host may be left
+// half-deployed.
+func playbookFailed(playbook string, err error) error {
+	if err == nil {
+		return nil
 	}
 
-	startsAt := time.Now()
-	endsAt := startsAt.Add(time.Minute * 5)
-	return alertmanager.CreateSilence(matchers, startsAt, endsAt, "Burn-in Automator", comment)
+	var timeoutErr *burnin.PlaybookTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return fmt.Errorf(
+			"playbook %s timed out after %s and was killed, the host may be left half-deployed: %w",
+			playbook,
+			timeoutErr.Timeout,
+			err,
+		)
+	}
+	return fmt.Errorf("playbook %s failed: %w", playbook, err)
 }
 
 func diffsToCurrentCommit(baseBranch string, gitlab burnin.Gitlab) ([]burnin.CommitDiff, error) {
@@ -33,10 +33,11 @@ func ProcessDeploy(
 	baseBranch string,
 	targetHostname string,
 	gitlab burnin.Gitlab,
-	alertmanager burnin.Alertmanager,
-	ansible burnin.AnsibleDriver,
-	matrix burnin.Matrix,
-) error {
+	alertmanagers burnin.Alertmanagers,
+	driver burnin.DeploymentDriver,
+	notifier burnin.Notifier,
+	opts Options,
+) (err error) {
 	diffs, err := diffsToCurrentCommit(baseBranch, gitlab)
 	if err != nil {
 		return err
@@ -56,19 +57,21 @@ func ProcessDeploy(
 	if err != nil {
 		return err
 	}
+	defer func() { err = withDeployment(deployment, err) }()
 
 	customBinaryURL, err := url.Parse(deployment.CustomBinary)
 	if err != nil {
 		return err
 	}
 
+	alertmanager := alertmanagers.ForNetwork(deployment.Network)
 	log.Printf("creating silence for host %s\n", targetHostname)
 	comment := fmt.Sprintf("Deploying burn-in test for %s on %s", deployment.PullRequest, targetHostname)
-	silenceID, err := createSilence(alertmanager, targetHostname, comment)
+	s, err := startSilence(alertmanager, hostMatchers(targetHostname), comment, opts)
 	if err != nil {
 		return err
 	}
-	log.Printf("silence id: %s\n", silenceID)
+	log.Printf("silence id: %s\n", s.silence.ID)
 
 	playbook := fmt.Sprintf("%s-nodes.yml", deployment.Network)
 	wipeChainDb := deployment.NodeType == burnin.FullNode && deployment.SyncFromScratch
@@ -78,23 +81,38 @@ func ProcessDeploy(
 	}
 
 	log.Printf(
-		"running ansible playbook %s on host %s with 'node_binary' %v %s\n",
+		"running ansible playbook %s on host %s with 'node_binary' %s %s\n",
 		playbook,
 		targetHostname,
-		customBinaryURL,
+		burnin.RedactURL(customBinaryURL),
 		wipeChainDbLog,
 	)
-	if err := ansible.RunPlaybook(
-		playbook,
-		"localhost",
-		customBinaryURL,
-		wipeChainDb,
-		targetHostname,
-		deployment.CustomOptions,
-	); err != nil {
-		return err
+	err = driver.RunPlaybook(burnin.PlaybookRun{
+		Playbook:       playbook,
+		Network:        deployment.Network,
+		RunOn:          "localhost",
+		NodePublicName: targetHostname,
+		NodeBinary:     customBinaryURL,
+		BinaryChecksum: deployment.BinaryChecksum,
+		WipeChainDB:    wipeChainDb,
+		CustomOptions:  deployment.CustomOptions,
+		ExtraVars:      deployment.ExtraVars,
+	})
+	finishSilence(s, opts)
+	if err != nil {
+		failure := playbookFailed(playbook, err)
+		return commitFailure(repoRunFilePath, deployment, "deploy", targetHostname, failure, gitlab, baseBranch, opts)
 	}
 
+	deployment.Health = checkHealth(opts.HealthChecker, deployment, targetHostname)
+	alertsErr := checkAlerts(alertmanager, targetHostname, opts.AlertGate)
+	outcome, details := healthOutcome(deployment.Health)
+	if alertsErr != nil {
+		outcome, details = "failed", alertsErr.Error()
+	}
+	deployment = addHistory(deployment, "deploy", targetHostname, outcome, details, opts)
+
+	// The node runs even if the alert gate failed, so the host is taken like after any other deployment.
 	log.Printf("adding 'deployed_at' and 'deployed_on' to file %s\n", repoRunFilePath)
 	deployment, err = addDeploymentInfo(repoRunFilePath, deployment, targetHostname, gitlab, baseBranch)
 	if err != nil {
@@ -106,7 +124,11 @@ func ProcessDeploy(
 		return err
 	}
 
-	return matrix.SendDeploymentNotification(deployment)
+	if alertsErr != nil {
+		return alertsErr
+	}
+
+	return notifier.SendDeploymentNotification(deployment)
 }
 
 func addDeploymentInfo(
@@ -153,11 +175,10 @@ func addLogViewerURL(deployment burnin.Deployment) burnin.Deployment {
 
 func addDashboardURLs(deployment burnin.Deployment) burnin.Deployment {
 	dashFmt := `http://grafana.example.com/d/%s?orgId=1&refresh=1m&var-nodename=%s:9615`
-	fqdn := deployment.InternalFQDN
+	fqdn := metricsHost(deployment.Network, deployment.DeployedOn)
 
 	if deployment.Network == "westend" {
 		dashFmt += "&var-data_source=prometheus"
-		fqdn = deployment.DeployedOn
 	}
 
 	deployment.Dashboards = map[string]string{
@@ -170,6 +191,27 @@ func addDashboardURLs(deployment burnin.Deployment) burnin.Deployment {
 	return deployment
 }
 
+// metricsHost is the host Prometheus scrapes the metrics of the node from.
+func metricsHost(network, hostname string) string {
+	if network == "westend" {
+		return hostname
+	}
+	_, internalFQDN := hostnameToFQDNs(hostname)
+	return internalFQDN
+}
+
+// checkHealth watches the node once its playbook has finished. It returns nil if no checker is configured.
+func checkHealth(checker burnin.HealthChecker, deployment burnin.Deployment, hostname string) *burnin.HealthCheck {
+	if checker == nil {
+		return nil
+	}
+
+	log.Printf("checking health of %s\n", hostname)
+	check := checker.CheckHealth(metricsHost(deployment.Network, hostname), deployment.CommitSHA)
+	log.Printf("%s is %s\n", hostname, check.Summary())
+	return &check
+}
+
 func validDeployment(diffs []burnin.CommitDiff) bool {
 	return len(diffs) == 1 &&
 		diffs[0].NewFile &&
@@ -16,8 +16,10 @@
 package job
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/pelletier/go-toml"
 	"github.com/stretchr/testify/require"
 	burnin "gitlab.example.com/burn-in-tests/backend"
 )
@@ -26,10 +28,10 @@ func Test_ProcessDeploy(t *testing.T) {
 	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1602856340.toml", true, false, false, "")
 	gitlab := newMockGitlabClient(diff)
 	alertmanager := new(mockAlertManager)
-	ansible := new(mockAnsibleDriver)
-	matrix := new(mockMatrix)
+	ansible := new(mockDeploymentDriver)
+	notifier := new(mockNotifier)
 
-	err := ProcessDeploy("testdata", "master", "kusama-unit-test-hostname", gitlab, alertmanager, ansible, matrix)
+	err := ProcessDeploy("testdata", "master", "kusama-unit-test-hostname", gitlab, alertmanager, ansible, notifier, Options{})
 
 	require.NoError(t, err)
 
@@ -50,43 +52,43 @@ func Test_ProcessDeploy(t *testing.T) {
 	require.Len(t, ansible.runPlaybookCalls, 1)
 
 	ansibleRefreshCall := ansible.runPlaybookCalls[0]
-	require.Equal(t, "kusama-nodes.yml", ansibleRefreshCall.name)
-	require.Equal(t, "localhost", ansibleRefreshCall.runOn)
-	require.Equal(t, "kusama-unit-test-hostname", ansibleRefreshCall.nodePublicName)
+	require.Equal(t, "kusama-nodes.yml", ansibleRefreshCall.Playbook)
+	require.Equal(t, "localhost", ansibleRefreshCall.RunOn)
+	require.Equal(t, "kusama-unit-test-hostname", ansibleRefreshCall.NodePublicName)
 	require.Equal(
 		t,
 		"https://gitlab.example.com/parity/polkadot/-/jobs/752482/artifacts/raw/artifacts/polkadot",
-		ansibleRefreshCall.nodeBinary.String(),
+		ansibleRefreshCall.NodeBinary.String(),
 	)
-	require.Len(t, ansibleRefreshCall.customOptions, 2)
-	require.Equal(t, "--wasm-execution Compiled", ansibleRefreshCall.customOptions[0])
-	require.Equal(t, "--rpc-methods Unsafe", ansibleRefreshCall.customOptions[1])
+	require.Len(t, ansibleRefreshCall.CustomOptions, 2)
+	require.Equal(t, "--wasm-execution Compiled", ansibleRefreshCall.CustomOptions[0])
+	require.Equal(t, "--rpc-methods Unsafe", ansibleRefreshCall.CustomOptions[1])
 
 	require.Len(t, gitlab.createBranchCalls, 0)
 	require.Len(t, gitlab.createMergeRequestCalls, 0)
 	require.Len(t, gitlab.deleteFileCalls, 0)
 
-	require.Len(t, matrix.requestNotificationCalls, 0)
-	require.Len(t, matrix.updateNotificationCalls, 0)
-	require.Len(t, matrix.cleanupNotificationCalls, 0)
-	require.Len(t, matrix.errorNotificationCalls, 0)
-	require.Len(t, matrix.deploymentNotificationCalls, 1)
-	matrixCall := matrix.deploymentNotificationCalls[0]
-	require.NotNil(t, matrixCall.DeployedOn)
-	require.Equal(t, "kusama-unit-test-hostname", matrixCall.DeployedOn)
-	require.NotNil(t, matrixCall.PublicFQDN)
-	require.Equal(t, "kusama-unit-test-hostname.example.com", matrixCall.PublicFQDN)
-	require.NotNil(t, matrixCall.InternalFQDN)
-	require.Equal(t, "kusama-unit-test-hostname-int.example.com", matrixCall.InternalFQDN)
+	require.Len(t, notifier.requestNotificationCalls, 0)
+	require.Len(t, notifier.updateNotificationCalls, 0)
+	require.Len(t, notifier.cleanupNotificationCalls, 0)
+	require.Len(t, notifier.errorNotificationCalls, 0)
+	require.Len(t, notifier.deploymentNotificationCalls, 1)
+	notificationCall := notifier.deploymentNotificationCalls[0]
+	require.NotNil(t, notificationCall.DeployedOn)
+	require.Equal(t, "kusama-unit-test-hostname", notificationCall.DeployedOn)
+	require.NotNil(t, notificationCall.PublicFQDN)
+	require.Equal(t, "kusama-unit-test-hostname.example.com", notificationCall.PublicFQDN)
+	require.NotNil(t, notificationCall.InternalFQDN)
+	require.Equal(t, "kusama-unit-test-hostname-int.example.com", notificationCall.InternalFQDN)
 
 	require.Equal(
 		t,
 		"http://grafana.example.com/explore?orgId=1&left=%5B%22now-1h%22%2C%22now%22%2C%22loki%22%2C%7B%22expr%22%3A%22%7Bhost%3D%5C%22kusama-unit-test-hostname%5C%22%7D%22%7D%5D",
-		matrixCall.LogViewer,
+		notificationCall.LogViewer,
 	)
 
-	require.Len(t, matrixCall.Dashboards, 4)
-	svcTasksURL, ok := matrixCall.Dashboards["substrate_service_tasks"]
+	require.Len(t, notificationCall.Dashboards, 4)
+	svcTasksURL, ok := notificationCall.Dashboards["substrate_service_tasks"]
 	require.True(t, ok)
 	require.Equal(
 		t,
@@ -95,6 +97,39 @@ func Test_ProcessDeploy(t *testing.T) {
 	)
 }
 
+func Test_ProcessDeploy_playbookFailed(t *testing.T) {
+	runFile := "runs/run-kusama-fullnode-0-1602856340.toml"
+	gitlab := newMockGitlabClient(mkCommitDiff(runFile, true, false, false, ""))
+	alertmanager := new(mockAlertManager)
+	ansible := &mockDeploymentDriver{err: errors.New("exit status 2")}
+	notifier := new(mockNotifier)
+
+	err := ProcessDeploy("testdata", "master", "kusama-unit-test-hostname", gitlab, alertmanager, ansible, notifier, Options{
+		JobURL: "https://gitlab.example.com/parity/burn-in/-/jobs/1",
+	})
+
+	require.EqualError(t, err, "playbook kusama-nodes.yml failed: exit status 2")
+	var jobErr *burnin.JobError
+	require.True(t, errors.As(err, &jobErr))
+
+	require.Len(t, gitlab.updateFileCalls, 1, "the failure should be recorded in the history")
+	updateCall := gitlab.updateFileCalls[0]
+	require.Equal(t, runFile, updateCall.path)
+	require.Equal(t, "[skip ci] Record failed deploy on kusama-unit-test-hostname", updateCall.commitMsg)
+	var recorded burnin.Deployment
+	require.NoError(t, toml.Unmarshal(updateCall.content, &recorded))
+	require.Empty(t, recorded.DeployedOn)
+	require.Len(t, recorded.History, 1)
+	entry := recorded.History[0]
+	require.Equal(t, "deploy", entry.Action)
+	require.Equal(t, "kusama-unit-test-hostname", entry.Host)
+	require.Equal(t, "failed", entry.Outcome)
+	require.Equal(t, "playbook kusama-nodes.yml failed: exit status 2", entry.Details)
+	require.Equal(t, "https://gitlab.example.com/parity/burn-in/-/jobs/1", entry.JobURL)
+
+	require.Len(t, notifier.deploymentNotificationCalls, 0)
+}
+
 func Test_validDeployment(t *testing.T) {
 	cases := []struct {
 		description    string
@@ -0,0 +1,208 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// ProcessDigest sends a summary of the burn-ins in the "run" files of the checkout to the notifier.
+func ProcessDigest(
+	baseDirectory string,
+	runners burnin.RunnerPool,
+	notifier burnin.Notifier,
+	opts Options,
+) error {
+	digest, err := buildDigest(baseDirectory, runners, opts, time.Now())
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"sending digest of %d burn-in(s) and %d orphaned host(s)\n",
+		len(digest.BurnIns),
+		len(digest.OrphanedHosts),
+	)
+	return notifier.SendDigestNotification(digest)
+}
+
+func buildDigest(
+	baseDirectory string,
+	runners burnin.RunnerPool,
+	opts Options,
+	now time.Time,
+) (burnin.Digest, error) {
+	digest := burnin.Digest{CreatedAt: now}
+
+	deployments, err := readDeployments(path.Join(baseDirectory, "runs"), "run-*.toml")
+	if err != nil {
+		return digest, err
+	}
+
+	busy := make(map[string]bool) // hosts with a "run" file
+	byRequest := make(map[string]*burnin.BurnIn)
+	var requestIDs []string
+
+	for _, deployment := range deployments {
+		requestID := requestIDOfRunFile(deployment.Filename)
+		b, exists := byRequest[requestID]
+		if !exists {
+			b = &burnin.BurnIn{
+				RequestID:   requestID,
+				PullRequest: deployment.PullRequest,
+				RequestedBy: deployment.RequestedBy,
+			}
+			byRequest[requestID] = b
+			requestIDs = append(requestIDs, requestID)
+		}
+
+		b.Deployments = append(b.Deployments, deployment)
+		if !deployment.DeployedAt.IsZero() && (b.DeployedAt.IsZero() || deployment.DeployedAt.Before(b.DeployedAt)) {
+			b.DeployedAt = deployment.DeployedAt
+		}
+		if deployment.UpdatedAt.After(b.UpdatedAt) {
+			b.UpdatedAt = deployment.UpdatedAt
+		}
+		if deployment.DeployedOn != "" {
+			busy[deployment.DeployedOn] = true
+		}
+	}
+
+	sort.Strings(requestIDs)
+	for _, requestID := range requestIDs {
+		b := byRequest[requestID]
+		b.Overdue = !b.DeployedAt.IsZero() && now.Sub(b.DeployedAt) > opts.digestMaxAge()
+		digest.BurnIns = append(digest.BurnIns, *b)
+	}
+
+	// Hosts can also be taken by hand through the frontend, which records them in the "manual" folder.
+	manualHosts, err := readManualHosts(path.Join(baseDirectory, "manual"))
+	if err != nil {
+		return digest, err
+	}
+	for _, host := range manualHosts {
+		busy[host] = true
+	}
+
+	digest.OrphanedHosts, err = pausedHosts(runners, busy)
+	if err != nil {
+		return digest, err
+	}
+
+	return digest, nil
+}
+
+// readDeployments parses the "run" files in dir which match the pattern, sorted by file name.
+func readDeployments(dir, pattern string) ([]burnin.Deployment, error) {
+	runFiles, err := filepath.Glob(path.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := make([]burnin.Deployment, len(runFiles))
+	for i, runFile := range runFiles {
+		content, err := ioutil.ReadFile(runFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var deployment burnin.Deployment
+		if err := toml.Unmarshal(content, &deployment); err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %w", runFile, err)
+		}
+
+		deployment.Filename = filepath.Base(runFile)
+		deployments[i] = deployment
+	}
+
+	return deployments, nil
+}
+
+func readManualHosts(dir string) ([]string, error) {
+	files, err := filepath.Glob(path.Join(dir, "run-*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(files))
+	for _, file := range files {
+		var manual struct {
+			DeployedOn string `toml:"deployed_on"`
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := toml.Unmarshal(content, &manual); err != nil {
+			return nil, fmt.Errorf("parsing %s failed: %w", file, err)
+		}
+
+		hosts = append(hosts, manual.DeployedOn)
+	}
+
+	return hosts, nil
+}
+
+// pausedHosts returns the burn-in hosts with a paused runner which are not busy, sorted by name.
+func pausedHosts(runners burnin.RunnerPool, busy map[string]bool) ([]string, error) {
+	all, err := runners.GetRunners()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var hosts []string
+
+	for _, runner := range all {
+		if runner.Active || busy[runner.Description] || seen[runner.Description] {
+			continue
+		}
+
+		tags, err := runners.GetRunnerTags(runner.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only runners with a tag such as "kusama-fullnode" belong to burn-in hosts.
+		for _, tag := range tags {
+			parts := strings.Split(tag, "-")
+			if len(parts) != 2 {
+				continue
+			}
+
+			nodeType := burnin.NodeType(parts[1])
+			if nodeType == burnin.FullNode || nodeType == burnin.Sentry || nodeType == burnin.Validator {
+				hosts = append(hosts, runner.Description)
+				seen[runner.Description] = true
+				break
+			}
+		}
+	}
+
+	sort.Strings(hosts)
+	return hosts, nil
+}
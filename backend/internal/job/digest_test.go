@@ -0,0 +1,118 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func Test_buildDigest(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"runs/run-kusama-fullnode-0-1610469388.toml": `pull_request = "https://github.com/paritytech/polkadot/pull/2013"
+requested_by = "mxinden"
+network = "kusama"
+node_type = "fullnode"
+deployed_on = "kusama-fullnode-uw1-0"
+deployed_at = 2021-01-12T16:00:00Z
+updated_at = 2021-01-14T09:00:00Z
+`,
+		"runs/run-kusama-sentry-0-1610469388.toml": `pull_request = "https://github.com/paritytech/polkadot/pull/2013"
+requested_by = "mxinden"
+network = "kusama"
+node_type = "sentry"
+deployed_on = "kusama-sentry-uw1-0"
+deployed_at = 2021-01-12T15:00:00Z
+`,
+		"runs/run-westend-fullnode-0-1610900000.toml": `pull_request = "https://github.com/paritytech/polkadot/pull/2398"
+requested_by = "haiko@example.com"
+network = "westend"
+node_type = "fullnode"
+`,
+		"manual/run-1610000000.toml": `deployed_on = "polkadot-fullnode-uw1-0"
+deployed_at = "2021-01-07"
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(path.Join(dir, path.Dir(name)), 0755))
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644))
+	}
+
+	gitlab := &mockGitlabClient{
+		runners: []burnin.Runner{
+			{ID: 1, Description: "kusama-fullnode-uw1-0", Active: false},
+			{ID: 2, Description: "kusama-sentry-uw1-0", Active: false},
+			{ID: 3, Description: "polkadot-fullnode-uw1-0", Active: false},
+			{ID: 4, Description: "westend-sentry-uw1-0", Active: false},
+			{ID: 4, Description: "westend-sentry-uw1-0", Active: false},
+			{ID: 5, Description: "westend-fullnode-uw1-0", Active: true},
+			{ID: 6, Description: "docker-builder-0", Active: false},
+		},
+		runnerTags: map[int][]string{
+			1: {"kusama-fullnode"},
+			2: {"kusama-sentry"},
+			3: {"polkadot-fullnode"},
+			4: {"westend-sentry"},
+			5: {"westend-fullnode"},
+			6: {"docker"},
+		},
+	}
+
+	now := time.Date(2021, 1, 20, 16, 0, 0, 0, time.UTC)
+	digest, err := buildDigest(dir, gitlab, Options{}, now)
+	require.NoError(t, err)
+
+	require.Equal(t, now, digest.CreatedAt)
+	require.Len(t, digest.BurnIns, 2)
+
+	first := digest.BurnIns[0]
+	require.Equal(t, "1610469388", first.RequestID)
+	require.Equal(t, "https://github.com/paritytech/polkadot/pull/2013", first.PullRequest)
+	require.Equal(t, "mxinden", first.RequestedBy)
+	require.Equal(t, time.Date(2021, 1, 12, 15, 0, 0, 0, time.UTC), first.DeployedAt.UTC())
+	require.Equal(t, time.Date(2021, 1, 14, 9, 0, 0, 0, time.UTC), first.UpdatedAt.UTC())
+	require.True(t, first.Overdue, "running for more than a week")
+	require.Len(t, first.Deployments, 2)
+	require.Equal(t, "run-kusama-fullnode-0-1610469388.toml", first.Deployments[0].Filename)
+
+	second := digest.BurnIns[1]
+	require.Equal(t, "1610900000", second.RequestID)
+	require.True(t, second.DeployedAt.IsZero())
+	require.False(t, second.Overdue, "not deployed yet")
+
+	require.Equal(t, []string{"westend-sentry-uw1-0"}, digest.OrphanedHosts)
+
+	digest, err = buildDigest(dir, gitlab, Options{DigestMaxAge: 30 * 24 * time.Hour}, now)
+	require.NoError(t, err)
+	require.False(t, digest.BurnIns[0].Overdue)
+}
+
+func TestDigest_Age(t *testing.T) {
+	now := time.Date(2021, 1, 20, 16, 0, 0, 0, time.UTC)
+	digest := burnin.Digest{CreatedAt: now}
+
+	require.Equal(t, "", digest.Age(time.Time{}))
+	require.Equal(t, "12m", digest.Age(now.Add(-12*time.Minute)))
+	require.Equal(t, "5h", digest.Age(now.Add(-5*time.Hour-12*time.Minute)))
+	require.Equal(t, "3d 1h", digest.Age(now.Add(-73*time.Hour)))
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// addHistory appends an entry about what the deployment runs now to its history and drops the oldest entries beyond
+// the limit.
+func addHistory(deployment burnin.Deployment, action, host, outcome, details string, opts Options) burnin.Deployment {
+	entry := burnin.HistoryEntry{
+		At:             time.Now().UTC(),
+		Action:         action,
+		Host:           host,
+		Binary:         deployment.CustomBinary,
+		CommitSHA:      deployment.CommitSHA,
+		BinaryChecksum: deployment.BinaryChecksum,
+		CustomOptions:  deployment.CustomOptions,
+		JobURL:         opts.JobURL,
+		Outcome:        outcome,
+		Details:        details,
+	}
+
+	// copied, so that deployments sharing the history are not changed
+	history := append(append([]burnin.HistoryEntry(nil), deployment.History...), entry)
+	if limit := opts.historyLimit(); len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	deployment.History = history
+	return deployment
+}
+
+// healthOutcome returns the outcome and details of a playbook run which succeeded, depending on the health of the node
+// afterwards (nil if it was not checked).
+func healthOutcome(health *burnin.HealthCheck) (string, string) {
+	if health != nil && !health.Healthy {
+		return "unhealthy", strings.Join(health.Problems, "; ")
+	}
+	return "succeeded", ""
+}
+
+// maxFailureDetails is the length beyond which the error of a failed action is cut in the history.
+const maxFailureDetails = 500
+
+// commitFailure records that the action failed with failure in the history of the deployment and commits it to the
+// "run" file. It returns failure, which names the commit's error as well if that fails, too.
+func commitFailure(
+	path string,
+	deployment burnin.Deployment,
+	action string,
+	host string,
+	failure error,
+	gitlab burnin.Gitlab,
+	branch string,
+	opts Options,
+) error {
+	details := failure.Error()
+	if len(details) > maxFailureDetails {
+		details = strings.ToValidUTF8(details[:maxFailureDetails], "") + "..."
+	}
+	deployment = addHistory(deployment, action, host, "failed", details, opts)
+
+	runFileContent, err := toml.Marshal(deployment)
+	if err == nil {
+		commitMsg := gitlab.PrefixSkipCI(fmt.Sprintf("Record failed %s on %s", action, host))
+		err = gitlab.UpdateFile(path, branch, commitMsg, runFileContent)
+	}
+	if err != nil {
+		return fmt.Errorf("%w, recording it in the \"run\" file failed: %v", failure, err)
+	}
+	return failure
+}
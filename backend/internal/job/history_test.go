@@ -0,0 +1,57 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func Test_addHistory(t *testing.T) {
+	deployment := burnin.Deployment{
+		CommitSHA:     "a7810560c0f62dd6d347e710a5e2a64da465c109",
+		CustomBinary:  "https://gitlab.example.com/mocks/mockproject/-/jobs/752482/artifacts/raw/artifacts/polkadot",
+		CustomOptions: []string{"--pruning=archive"},
+	}
+	opts := Options{HistoryLimit: 3, JobURL: "https://gitlab.example.com/burn-in-tests/deployments/-/jobs/1/"}
+
+	deployment = addHistory(deployment, "deploy", "kusama-fullnode-uw1-0", "succeeded", "", opts)
+	require.Len(t, deployment.History, 1)
+	entry := deployment.History[0]
+	require.False(t, entry.At.IsZero())
+	require.Equal(t, "deploy", entry.Action)
+	require.Equal(t, "kusama-fullnode-uw1-0", entry.Host)
+	require.Equal(t, deployment.CustomBinary, entry.Binary)
+	require.Equal(t, deployment.CommitSHA, entry.CommitSHA)
+	require.Equal(t, []string{"--pruning=archive"}, entry.CustomOptions)
+	require.Equal(t, opts.JobURL, entry.JobURL)
+	require.Equal(t, "succeeded", entry.Outcome)
+
+	shared := deployment
+	for i := 0; i < 4; i++ {
+		deployment = addHistory(deployment, "update", "kusama-fullnode-uw1-0", "unhealthy", fmt.Sprint(i), opts)
+	}
+	require.Len(t, deployment.History, 3, "only the most recent entries are kept")
+	require.Equal(t, "1", deployment.History[0].Details)
+	require.Equal(t, "3", deployment.History[2].Details)
+	require.Len(t, shared.History, 1)
+	require.Equal(t, "deploy", shared.History[0].Action)
+
+	require.Len(t, addHistory(burnin.Deployment{}, "deploy", "", "succeeded", "", Options{}).History, 1)
+}
@@ -0,0 +1,281 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/gitlab"
+	"gitlab.example.com/burn-in-tests/backend/internal/gitlab/gitlabtest"
+	"gitlab.example.com/burn-in-tests/backend/internal/job"
+	"gitlab.example.com/burn-in-tests/backend/internal/localnode"
+)
+
+// Test_Lifecycle runs the jobs against the real GitLab client talking to a fake GitLab server, the way the CI jobs in
+// the "deployments" repository would run them one after another.
+func Test_Lifecycle(t *testing.T) {
+	const (
+		hostname    = "kusama-fullnode-uw1-0"
+		requestPath = "requests/request-1610000000.toml"
+		runPath     = "runs/run-kusama-fullnode-0-1610000000.toml"
+		firstSHA    = "a7810560c0f62dd6d347e710a5e2a64da465c109"
+		secondSHA   = "6c7d5ffe7c9b88e1c8d3ffbea5f93f2387cca110"
+	)
+
+	server := gitlabtest.NewServer()
+	defer server.Close()
+	server.Tokens = map[string]gitlabtest.Access{"s3cr3t": gitlabtest.Admin}
+
+	deployments := server.AddProject("burn-in-tests/deployments")
+	polkadot := server.AddProject("parity/polkadot")
+	server.AddRunner(hostname, "kusama-fullnode")
+
+	firstPipeline := polkadot.AddPipeline("2013", firstSHA, "running", burnin.Job{Name: "build-linux-stable", Status: "manual"})
+
+	burninGitlab := newClient(t, server, deployments.ID)
+	buildGitlab := newClient(t, server, polkadot.ID)
+	alertmanager := new(fakeAlertmanager)
+	// The nodes run locally as stub processes.
+	stub := filepath.Join(t.TempDir(), "polkadot")
+	require.NoError(t, ioutil.WriteFile(stub, []byte("#!/bin/sh\nexec sleep 60\n"), 0755))
+	nodes := localnode.NewDriver(localnode.Options{Dir: t.TempDir(), Binary: stub, StartupGrace: 10 * time.Millisecond})
+	defer func() { require.NoError(t, nodes.StopAll()) }()
+	driver := &fakeDriver{nodes: nodes}
+	notifier := new(fakeNotifier)
+	dir := t.TempDir()
+	opts := job.Options{AllowedExtraVars: []string{"node_pruning"}}
+
+	runJob := func(name string, fn func() error) {
+		t.Helper()
+		require.NoError(t, deployments.Checkout("master", dir), name)
+		require.NoError(t, fn(), name)
+	}
+
+	// 1. Someone commits a request file, the "request" job creates the run file.
+	request := `pull_request = "https://github.com/paritytech/polkadot/pull/2013"
+requested_by = "mxinden"
+
+[nodes.kusama]
+fullnode = 1
+
+[extra_vars]
+node_pruning = 1000
+`
+	_, err := deployments.CommitFile("master", requestPath, request, "Request https://github.com/paritytech/polkadot/pull/2013")
+	require.NoError(t, err)
+
+	runJob("request", func() error {
+		return job.ProcessRequest(dir, "master", burninGitlab, buildGitlab, job.Poller{}, notifier, opts)
+	})
+
+	require.Equal(t, "success", polkadot.Jobs(firstPipeline.ID)[0].Status, "manual build job should have been started")
+	require.True(t, strings.HasPrefix(deployments.LastCommit("master").Message, "[deploy-kusama-fullnode] "))
+	deployment := readRunFile(t, deployments, runPath)
+	require.Equal(t, firstSHA, deployment.CommitSHA)
+	require.Equal(t, "$root:matrix.example.com", deployment.MatrixThread)
+	require.Equal(t, polkadot.Jobs(firstPipeline.ID)[0].WebURL+"/artifacts/raw/artifacts/polkadot", deployment.CustomBinary)
+	require.Equal(t, map[string]interface{}{"node_pruning": int64(1000)}, deployment.ExtraVars)
+	require.Len(t, notifier.events, 1)
+
+	// 2. The "deploy" job runs on the burn-in host.
+	runJob("deploy", func() error {
+		return job.ProcessDeploy(dir, "master", hostname, burninGitlab, alertmanager, driver, notifier, job.Options{})
+	})
+
+	runner, _ := server.Runner(hostname)
+	require.False(t, runner.Active)
+	require.True(t, strings.HasPrefix(deployments.LastCommit("master").Message, "[skip ci] "))
+	deployment = readRunFile(t, deployments, runPath)
+	require.Equal(t, hostname, deployment.DeployedOn)
+	require.False(t, deployment.DeployedAt.IsZero())
+	require.Len(t, driver.binaries, 1)
+	require.Equal(t, deployment.CustomBinary, driver.binaries[0])
+	deployedPID := nodes.PIDs()[hostname]
+	require.NotZero(t, deployedPID)
+	require.Equal(t, map[string]interface{}{"node_pruning": int64(1000)}, driver.extraVars[0])
+
+	// 3. The request is updated to a newer commit, which updates the run file and triggers the "update" job.
+	secondPipeline := polkadot.AddPipeline("2013", secondSHA, "success", burnin.Job{Name: "build-linux-stable", Status: "success"})
+	_, err = deployments.CommitFile("master", requestPath, strings.Replace(
+		request,
+		"requested_by",
+		`commit_sha = "`+secondSHA+`"
+requested_by`,
+		1,
+	), "Update commit_sha")
+	require.NoError(t, err)
+
+	runJob("request (update)", func() error {
+		return job.ProcessRequest(dir, "master", burninGitlab, buildGitlab, job.Poller{}, notifier, opts)
+	})
+
+	require.True(t, strings.HasPrefix(deployments.LastCommit("master").Message, "[update-deployment] "))
+
+	runJob("update", func() error {
+		return job.ProcessUpdate(dir, "master", burninGitlab, alertmanager, driver, notifier, job.Options{})
+	})
+
+	deployment = readRunFile(t, deployments, runPath)
+	require.Equal(t, secondSHA, deployment.CommitSHA)
+	require.Equal(t, "$root:matrix.example.com", deployment.MatrixThread)
+	require.Equal(t, polkadot.Jobs(secondPipeline.ID)[0].WebURL+"/artifacts/raw/artifacts/polkadot", deployment.CustomBinary)
+	require.False(t, deployment.UpdatedAt.IsZero())
+	require.Len(t, driver.binaries, 2)
+	require.Equal(t, deployment.CustomBinary, driver.binaries[1])
+	updatedPID := nodes.PIDs()[hostname]
+	require.NotZero(t, updatedPID)
+	require.NotEqual(t, deployedPID, updatedPID, "the update should have restarted the node")
+	require.Equal(t, map[string]interface{}{"node_pruning": int64(1000)}, driver.extraVars[1])
+
+	// The digest lists the burn-in, the paused runner of its host is accounted for.
+	runJob("digest", func() error {
+		return job.ProcessDigest(dir, burninGitlab, notifier, job.Options{})
+	})
+
+	require.Len(t, notifier.digests, 1)
+	digest := notifier.digests[0]
+	require.Len(t, digest.BurnIns, 1)
+	require.Equal(t, "mxinden", digest.BurnIns[0].RequestedBy)
+	require.Equal(t, hostname, digest.BurnIns[0].Deployments[0].DeployedOn)
+	require.False(t, digest.BurnIns[0].Overdue)
+	require.Empty(t, digest.OrphanedHosts)
+
+	// 4. Removing the run file triggers the "cleanup" job, which also removes the request file.
+	_, err = deployments.DeleteFile("master", runPath, "[cleanup] "+hostname)
+	require.NoError(t, err)
+
+	runJob("cleanup", func() error {
+		return job.ProcessCleanup("master", burninGitlab, alertmanager, driver, notifier, job.Options{})
+	})
+
+	runner, _ = server.Runner(hostname)
+	require.True(t, runner.Active)
+	_, exists := deployments.File("master", requestPath)
+	require.False(t, exists)
+	require.Len(t, driver.binaries, 3)
+	require.NotContains(t, []int{0, updatedPID}, nodes.PIDs()[hostname], "the node should run the nightly binary again")
+	require.Len(t, alertmanager.silences, 3)
+	require.Equal(t, 3, alertmanager.expired)
+	require.Equal(t, []string{"request", "deployment", "update", "digest", "cleanup"}, notifier.events)
+}
+
+func newClient(t *testing.T, server *gitlabtest.Server, projectID int) *gitlab.Client {
+	client, err := gitlab.NewClient(server.URL(), projectID, gitlab.CredentialsFromToken(gitlab.Token{Value: "s3cr3t"}))
+	require.NoError(t, err)
+	require.NoError(t, client.Authenticate())
+	return client
+}
+
+func readRunFile(t *testing.T, project *gitlabtest.Project, path string) burnin.Deployment {
+	content, exists := project.File("master", path)
+	require.True(t, exists, path)
+
+	var deployment burnin.Deployment
+	require.NoError(t, toml.Unmarshal([]byte(content), &deployment))
+	return deployment
+}
+
+type fakeAlertmanager struct {
+	silences []string
+	expired  int
+}
+
+func (a *fakeAlertmanager) CreateSilence(_ []burnin.AlertMatcher, _, _ time.Time, _, comment string) (string, error) {
+	a.silences = append(a.silences, comment)
+	return "silence", nil
+}
+
+func (a *fakeAlertmanager) ForNetwork(string) burnin.Alertmanager {
+	return a
+}
+
+func (a *fakeAlertmanager) GetSilences() ([]burnin.Silence, error) {
+	return nil, nil
+}
+
+func (a *fakeAlertmanager) UpdateSilence(s burnin.Silence) (string, error) {
+	return s.ID, nil
+}
+
+func (a *fakeAlertmanager) GetAlerts([]burnin.AlertMatcher) ([]burnin.Alert, error) {
+	return nil, nil
+}
+
+func (a *fakeAlertmanager) DeleteSilence(string) error {
+	a.expired++
+	return nil
+}
+
+// fakeDriver records the runs before passing them to the local nodes.
+type fakeDriver struct {
+	nodes     *localnode.Driver
+	binaries  []string
+	extraVars []map[string]interface{}
+}
+
+func (d *fakeDriver) RunPlaybook(run burnin.PlaybookRun) error {
+	d.binaries = append(d.binaries, run.NodeBinary.String())
+	d.extraVars = append(d.extraVars, run.ExtraVars)
+	return d.nodes.RunPlaybook(run)
+}
+
+type fakeNotifier struct {
+	events  []string
+	digests []burnin.Digest
+}
+
+func (n *fakeNotifier) SendRequestNotification(burnin.Request) (string, error) {
+	n.events = append(n.events, "request")
+	return "$root:matrix.example.com", nil
+}
+
+func (n *fakeNotifier) SendDeploymentNotification(burnin.Deployment) error {
+	n.events = append(n.events, "deployment")
+	return nil
+}
+
+func (n *fakeNotifier) SendUpdateNotification(burnin.Deployment) error {
+	n.events = append(n.events, "update")
+	return nil
+}
+
+func (n *fakeNotifier) SendCleanupNotification(burnin.Deployment) error {
+	n.events = append(n.events, "cleanup")
+	return nil
+}
+
+func (n *fakeNotifier) SendRollbackNotification(burnin.Deployment) error {
+	n.events = append(n.events, "rollback")
+	return nil
+}
+
+func (n *fakeNotifier) SendErrorNotification(err error) error {
+	n.events = append(n.events, "error")
+	return nil
+}
+
+func (n *fakeNotifier) SendDigestNotification(digest burnin.Digest) error {
+	n.events = append(n.events, "digest")
+	n.digests = append(n.digests, digest)
+	return nil
+}
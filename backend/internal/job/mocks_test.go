@@ -19,6 +19,8 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"path"
+	"sort"
 	"strconv"
 	"time"
 
@@ -53,6 +55,7 @@ type mockGitlabClient struct {
 	createMergeRequestCalls []createMergeRequestArgs
 	runners                 []burnin.Runner
 	runnerTags              map[int][]string
+	files                   map[string]string // returned by ListDirectory and GetFile
 
 	getLastCommitDiffs    getLastCommitDiffsFn
 	getPipelinesForBranch func(string) ([]burnin.Pipeline, error)
@@ -131,8 +134,23 @@ func (c *mockGitlabClient) CreateBranch(name, fromBranch string) error {
 	return nil
 }
 
-func (c *mockGitlabClient) ListDirectory(string, string) ([]burnin.FileInfo, error) {
-	return []burnin.FileInfo{}, nil
+func (c *mockGitlabClient) ListDirectory(dir, _ string) ([]burnin.FileInfo, error) {
+	items := []burnin.FileInfo{}
+	for p := range c.files {
+		if path.Dir(p) == dir {
+			items = append(items, burnin.FileInfo{Name: path.Base(p), Type: "blob", Path: p})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return items, nil
+}
+
+func (c *mockGitlabClient) GetFile(p, _ string) ([]byte, error) {
+	content, exists := c.files[p]
+	if !exists {
+		return nil, fmt.Errorf("file %s not found", p)
+	}
+	return []byte(content), nil
 }
 
 func (c *mockGitlabClient) CreateFile(path, branch, commitMsg string, content []byte) error {
@@ -230,7 +248,18 @@ func (a *createSilenceArgs) GetMatcher(name string) *burnin.AlertMatcher {
 }
 
 type mockAlertManager struct {
+	silences           []burnin.Silence // returned by GetSilences
 	createSilenceCalls []createSilenceArgs
+	updateSilenceCalls []burnin.Silence
+	deleteSilenceCalls []string
+	alerts             []burnin.Alert // returned by GetAlerts
+	getAlertsCalls     [][]burnin.AlertMatcher
+	networks           []string // passed to ForNetwork
+}
+
+func (a *mockAlertManager) ForNetwork(network string) burnin.Alertmanager {
+	a.networks = append(a.networks, network)
+	return a
 }
 
 func (a *mockAlertManager) CreateSilence(
@@ -246,73 +275,97 @@ func (a *mockAlertManager) CreateSilence(
 	return "alert-1234", nil
 }
 
-func (a *mockAlertManager) DeleteSilence(string) error {
-	return nil
+func (a *mockAlertManager) GetSilences() ([]burnin.Silence, error) {
+	return a.silences, nil
+}
+
+func (a *mockAlertManager) UpdateSilence(silence burnin.Silence) (string, error) {
+	a.updateSilenceCalls = append(a.updateSilenceCalls, silence)
+	return silence.ID, nil
+}
+
+func (a *mockAlertManager) GetAlerts(matchers []burnin.AlertMatcher) ([]burnin.Alert, error) {
+	a.getAlertsCalls = append(a.getAlertsCalls, matchers)
+	return a.alerts, nil
 }
 
-type runPlaybookArgs struct {
-	name           string
-	runOn          string
-	nodeBinary     *url.URL
-	wipeChainDB    bool
-	nodePublicName string
-	customOptions  []string
-}
-
-type mockAnsibleDriver struct {
-	runPlaybookCalls []runPlaybookArgs
-}
-
-func (d *mockAnsibleDriver) RunPlaybook(
-	name string,
-	runOn string,
-	nodeBinary *url.URL,
-	wipeChainDB bool,
-	nodePublicName string,
-	customOptions []string,
-) error {
-	d.runPlaybookCalls = append(
-		d.runPlaybookCalls,
-		runPlaybookArgs{
-			name,
-			runOn,
-			nodeBinary,
-			wipeChainDB,
-			nodePublicName,
-			customOptions,
-		})
+func (a *mockAlertManager) DeleteSilence(id string) error {
+	a.deleteSilenceCalls = append(a.deleteSilenceCalls, id)
 	return nil
 }
 
-type mockMatrix struct {
+type mockDeploymentDriver struct {
+	runPlaybookCalls []burnin.PlaybookRun
+	err              error // returned by RunPlaybook
+}
+
+func (d *mockDeploymentDriver) RunPlaybook(run burnin.PlaybookRun) error {
+	d.runPlaybookCalls = append(d.runPlaybookCalls, run)
+	return d.err
+}
+
+type checkHealthArgs struct {
+	host, commitSHA string
+}
+
+type mockHealthChecker struct {
+	checkHealthCalls []checkHealthArgs
+	checks           []burnin.HealthCheck // returned by CheckHealth one after the other, the last one repeatedly
+}
+
+func (c *mockHealthChecker) CheckHealth(host, commitSHA string) burnin.HealthCheck {
+	c.checkHealthCalls = append(c.checkHealthCalls, checkHealthArgs{host, commitSHA})
+	check := c.checks[0]
+	if len(c.checks) > 1 {
+		c.checks = c.checks[1:]
+	}
+	return check
+}
+
+type mockNotifier struct {
 	requestNotificationCalls    []burnin.Request
 	deploymentNotificationCalls []burnin.Deployment
 	updateNotificationCalls     []burnin.Deployment
 	cleanupNotificationCalls    []burnin.Deployment
+	rollbackNotificationCalls   []burnin.Deployment
 	errorNotificationCalls      []error
+	digestNotificationCalls     []burnin.Digest
+
+	thread     string // returned by SendRequestNotification
+	requestErr error  // returned by SendRequestNotification
 }
 
-func (c *mockMatrix) SendRequestNotification(request burnin.Request) error {
+func (c *mockNotifier) SendRequestNotification(request burnin.Request) (string, error) {
 	c.requestNotificationCalls = append(c.requestNotificationCalls, request)
-	return nil
+	return c.thread, c.requestErr
 }
 
-func (c *mockMatrix) SendDeploymentNotification(deployment burnin.Deployment) error {
+func (c *mockNotifier) SendDeploymentNotification(deployment burnin.Deployment) error {
 	c.deploymentNotificationCalls = append(c.deploymentNotificationCalls, deployment)
 	return nil
 }
 
-func (c *mockMatrix) SendUpdateNotification(deployment burnin.Deployment) error {
+func (c *mockNotifier) SendUpdateNotification(deployment burnin.Deployment) error {
 	c.updateNotificationCalls = append(c.updateNotificationCalls, deployment)
 	return nil
 }
 
-func (c *mockMatrix) SendCleanupNotification(deployment burnin.Deployment) error {
+func (c *mockNotifier) SendCleanupNotification(deployment burnin.Deployment) error {
 	c.cleanupNotificationCalls = append(c.cleanupNotificationCalls, deployment)
 	return nil
 }
 
-func (c *mockMatrix) SendErrorNotification(err error) error {
+func (c *mockNotifier) SendRollbackNotification(deployment burnin.Deployment) error {
+	c.rollbackNotificationCalls = append(c.rollbackNotificationCalls, deployment)
+	return nil
+}
+
+func (c *mockNotifier) SendErrorNotification(err error) error {
 	c.errorNotificationCalls = append(c.errorNotificationCalls, err)
 	return nil
 }
+
+func (c *mockNotifier) SendDigestNotification(digest burnin.Digest) error {
+	c.digestNotificationCalls = append(c.digestNotificationCalls, digest)
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+const (
+	defaultSilenceDuration = time.Hour
+	defaultDigestMaxAge    = 7 * 24 * time.Hour
+	defaultHistoryLimit    = 50
+)
+
+// Options tunes the behaviour of the jobs. The zero value uses the defaults.
+type Options struct {
+	// SilenceDuration is how long alerts of a host are silenced while a playbook runs on it. The silence is expired
+	// as soon as the playbook has finished, so this only has to be longer than the slowest playbook run.
+	SilenceDuration time.Duration
+	// SilenceGrace keeps the silence for a while after the playbook has finished, e.g. while the node is catching up.
+	// Zero expires it immediately.
+	SilenceGrace time.Duration
+	// AlertGate decides which alerts fail a deployment or update.
+	AlertGate AlertGate
+	// DigestMaxAge is how long a burn-in may run before the digest flags it.
+	DigestMaxAge time.Duration
+	// AllowedExtraVars are the names of the variables which requests may set in their "extra_vars" table. Requests
+	// with other extra vars are rejected.
+	AllowedExtraVars []string
+	// HealthChecker watches nodes after deployments and updates. The outcome is recorded in the "run" file and the
+	// notification. Nil skips the check.
+	HealthChecker burnin.HealthChecker
+	// HistoryLimit is how many entries the history of a "run" file keeps. Older ones are dropped.
+	HistoryLimit int
+	// JobURL is the CI job running the job, as recorded in the history.
+	JobURL string
+}
+
+// AlertGate checks the alerts of a host once its playbook has finished. An alert fails the job if its name is in
+// AlertNames (or AlertNames is empty) and it has all the Labels.
+type AlertGate struct {
+	Disabled   bool
+	AlertNames []string
+	Labels     map[string]string // defaults to severity=critical if nil
+	// Delay is how long to wait before checking, to give alerts with a "for" clause the chance to fire.
+	Delay time.Duration
+
+	sleep func(time.Duration) // defaults to time.Sleep, only replaced in tests
+}
+
+func (g AlertGate) gating(alert burnin.Alert) bool {
+	if len(g.AlertNames) > 0 {
+		found := false
+		for _, name := range g.AlertNames {
+			if alert.Labels["alertname"] == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	labels := g.Labels
+	if labels == nil {
+		labels = map[string]string{"severity": "critical"}
+	}
+
+	for k, v := range labels {
+		if alert.Labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (o Options) silenceDuration() time.Duration {
+	if o.SilenceDuration <= 0 {
+		return defaultSilenceDuration
+	}
+	return o.SilenceDuration
+}
+
+func (o Options) digestMaxAge() time.Duration {
+	if o.DigestMaxAge <= 0 {
+		return defaultDigestMaxAge
+	}
+	return o.DigestMaxAge
+}
+
+func (o Options) historyLimit() int {
+	if o.HistoryLimit <= 0 {
+		return defaultHistoryLimit
+	}
+	return o.HistoryLimit
+}
@@ -20,7 +20,6 @@ import (
 	"log"
 	"net/url"
 	"strings"
-	"time"
 
 	burnin "gitlab.example.com/burn-in-tests/backend"
 )
@@ -30,7 +29,12 @@ const silenceComment = "Deploying nightly Polkadot build to idle burn-in nodes"
 // ProcessRefresh relies on the convention that the "description" fields as returned by GET /api/v4/runners/all contains
 // the hostname (and nothing else). It also relies on the runners having tags such as "polkadot-fullnode" to determine
 // the blockchain network they are connected to.
-func ProcessRefresh(gitlab burnin.Gitlab, alertmanager burnin.Alertmanager, ansible burnin.AnsibleDriver) error {
+func ProcessRefresh(
+	gitlab burnin.Gitlab,
+	alertmanagers burnin.Alertmanagers,
+	driver burnin.DeploymentDriver,
+	opts Options,
+) error {
 	hostnamesByNetwork, err := getRunnerHostnamesByNetwork(gitlab, true)
 	if err != nil {
 		return err
@@ -39,15 +43,17 @@ func ProcessRefresh(gitlab burnin.Gitlab, alertmanager burnin.Alertmanager, ansi
 	for network, hostnames := range hostnamesByNetwork {
 		playbook := fmt.Sprintf("%s-nodes.yml", network)
 		customBinaryURL, _ := url.Parse(polkadotNightlyBuildURL) // safe to ignore errors as the input is a constant
+		alertmanager := alertmanagers.ForNetwork(network)
 
 		// Run the playbook separately for each hostname to avoid edge cases where it fails on some of them.
 		for _, hostname := range hostnames {
 			log.Printf("creating silence for %s host %v\n", network, hostname)
-			sid, err := createRefreshSilence(alertmanager, hostname, network, silenceComment)
+			matchers := append(hostMatchers(hostname), burnin.AlertMatcher{Name: "chain", Value: network})
+			s, err := startSilence(alertmanager, matchers, silenceComment, opts)
 			if err != nil {
 				return err
 			}
-			log.Printf("silence id: %s\n", sid)
+			log.Printf("silence id: %s\n", s.silence.ID)
 
 			fqdn, _ := hostnameToFQDNs(hostname)
 
@@ -58,8 +64,16 @@ func ProcessRefresh(gitlab burnin.Gitlab, alertmanager burnin.Alertmanager, ansi
 				customBinaryURL,
 			)
 
-			if err := ansible.RunPlaybook(playbook, fqdn, customBinaryURL, false, hostname, nil); err != nil {
-				return err
+			err = driver.RunPlaybook(burnin.PlaybookRun{
+				Playbook:       playbook,
+				Network:        network,
+				RunOn:          fqdn,
+				NodePublicName: hostname,
+				NodeBinary:     customBinaryURL,
+			})
+			finishSilence(s, opts)
+			if err != nil {
+				return playbookFailed(playbook, err)
 			}
 		}
 	}
@@ -108,23 +122,3 @@ func getRunnerHostnamesByNetwork(gitlab burnin.Gitlab, skipValidators bool) (map
 
 	return hostnamesByNetwork, nil
 }
-
-// createRefreshSilence adds a "chain" matcher and sets the silence duration to 20min instead of 5
-func createRefreshSilence(alertmanager burnin.Alertmanager, hostname, network, comment string) (string, error) {
-	matchers := []burnin.AlertMatcher{
-		{
-			Name:    "instance",
-			Value:   fmt.Sprintf(".*%s.*", hostname),
-			IsRegex: true,
-		},
-		{
-			Name:    "chain",
-			Value:   network,
-			IsRegex: false,
-		},
-	}
-
-	startsAt := time.Now()
-	endsAt := startsAt.Add(time.Minute * 20)
-	return alertmanager.CreateSilence(matchers, startsAt, endsAt, "Burn-in Automator", comment)
-}
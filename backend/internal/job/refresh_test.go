@@ -80,9 +80,9 @@ func Test_ProcessRefresh(t *testing.T) {
 	}
 
 	alertmanager := new(mockAlertManager)
-	ansible := new(mockAnsibleDriver)
+	ansible := new(mockDeploymentDriver)
 
-	err := ProcessRefresh(gitlab, alertmanager, ansible)
+	err := ProcessRefresh(gitlab, alertmanager, ansible, Options{})
 
 	require.NoError(t, err)
 	require.Len(t, alertmanager.createSilenceCalls, 2)
@@ -117,8 +117,8 @@ func Test_ProcessRefresh(t *testing.T) {
 
 	require.Len(t, ansible.runPlaybookCalls, 2)
 	for _, pbCall := range ansible.runPlaybookCalls {
-		require.NotNil(t, pbCall.nodeBinary)
-		require.Equal(t, polkadotNightlyBuildURL, pbCall.nodeBinary.String())
-		require.True(t, pbCall.name == "kusama-nodes.yml" || pbCall.name == "polkadot-nodes.yml")
+		require.NotNil(t, pbCall.NodeBinary)
+		require.Equal(t, polkadotNightlyBuildURL, pbCall.NodeBinary.String())
+		require.True(t, pbCall.Playbook == "kusama-nodes.yml" || pbCall.Playbook == "polkadot-nodes.yml")
 	}
 }
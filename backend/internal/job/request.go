@@ -16,13 +16,14 @@
 package job
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/url"
-	"os"
 	"path"
-	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/pelletier/go-toml"
@@ -46,7 +47,8 @@ func ProcessRequest(
 	burninGitlab burnin.Gitlab,
 	buildGitlab burnin.Gitlab,
 	poller burnin.Poller,
-	matrix burnin.Matrix,
+	notifier burnin.Notifier,
+	opts Options,
 ) error {
 	diffs, err := diffsToCurrentCommit(baseBranch, burninGitlab)
 	if err != nil {
@@ -74,6 +76,10 @@ func ProcessRequest(
 		return err
 	}
 
+	if err := checkExtraVars(request.ExtraVars, opts.AllowedExtraVars); err != nil {
+		return err
+	}
+
 	if kind == newRequest {
 		return processNewRequest(
 			requestID,
@@ -82,7 +88,7 @@ func ProcessRequest(
 			burninGitlab,
 			buildGitlab,
 			poller,
-			matrix,
+			notifier,
 		)
 	}
 
@@ -105,7 +111,7 @@ func processNewRequest(
 	burninGitlab burnin.Gitlab,
 	buildGitlab burnin.Gitlab,
 	poller burnin.Poller,
-	matrix burnin.Matrix,
+	notifier burnin.Notifier,
 ) error {
 	log.Println("processing new burn-in request...")
 
@@ -115,6 +121,7 @@ func processNewRequest(
 		RequestedBy:     request.RequestedBy,
 		SyncFromScratch: request.SyncFromScratch,
 		CustomOptions:   request.CustomOptions,
+		ExtraVars:       request.ExtraVars,
 	}
 
 	if request.CustomBinary == nil {
@@ -134,7 +141,17 @@ func processNewRequest(
 		deployment.CustomBinary = customBinary.String()
 	} else {
 		deployment.CustomBinary = *request.CustomBinary
+		deployment.BinaryChecksum = request.BinaryChecksum
+	}
+
+	// The notification is sent before the "run" files are created, so they can refer to it. Notifiers being down must
+	// not hold up the burn-in, nor may a retry of the job notify twice, so errors are only logged. The "run" files
+	// refer to the thread if any notifier started one.
+	thread, err := notifier.SendRequestNotification(request)
+	if err != nil {
+		log.Printf("sending the request notification failed (thread '%s'): %v\n", thread, err)
 	}
+	deployment.MatrixThread = thread
 
 	for network, nodeTypes := range request.Nodes {
 		for nodeType, count := range nodeTypes {
@@ -156,7 +173,7 @@ func processNewRequest(
 		}
 	}
 
-	return matrix.SendRequestNotification(request)
+	return nil
 }
 
 func processUpdatedRequest(
@@ -172,9 +189,10 @@ func processUpdatedRequest(
 	log.Println("processing update to an existing burn-in request...")
 
 	var (
-		customBinary *url.URL
-		commitSHA    string
-		err          error
+		customBinary   *url.URL
+		binaryChecksum string
+		commitSHA      string
+		err            error
 	)
 
 	if kind == updatedCommitSHA {
@@ -201,6 +219,7 @@ func processUpdatedRequest(
 		if err != nil {
 			return err
 		}
+		binaryChecksum = request.BinaryChecksum
 	}
 
 	deployments, err := findDeployments(requestID, baseDirectory)
@@ -209,7 +228,16 @@ func processUpdatedRequest(
 	}
 
 	for _, deployment := range deployments {
-		err := updateDeployment(deployment, commitSHA, customBinary, request.CustomOptions, baseBranch, burninGitlab)
+		err := updateDeployment(
+			deployment,
+			commitSHA,
+			customBinary,
+			binaryChecksum,
+			request.CustomOptions,
+			request.ExtraVars,
+			baseBranch,
+			burninGitlab,
+		)
 		if err != nil {
 			return err
 		}
@@ -222,15 +250,29 @@ func updateDeployment(
 	deployment burnin.Deployment,
 	commitSHA string,
 	customBinary *url.URL,
+	binaryChecksum string,
 	customOptions []string,
+	extraVars map[string]interface{},
 	branch string,
 	gitlab burnin.Gitlab,
 ) error {
+	// Deployments which are not running yet have nothing to roll back to.
+	if deployment.DeployedOn != "" {
+		deployment.PreviousBinary = deployment.CustomBinary
+		deployment.PreviousBinaryChecksum = deployment.BinaryChecksum
+		deployment.PreviousCommitSHA = deployment.CommitSHA
+		deployment.PreviousCustomOptions = deployment.CustomOptions
+		deployment.PreviousExtraVars = deployment.ExtraVars
+	}
+	deployment.RolledBackFrom, deployment.RollbackReason = "", ""
+
 	deployment.CommitSHA = commitSHA
 	if customBinary != nil {
 		deployment.CustomBinary = customBinary.String()
+		deployment.BinaryChecksum = binaryChecksum
 	}
 	deployment.CustomOptions = customOptions
+	deployment.ExtraVars = extraVars
 
 	runFileContent, err := toml.Marshal(deployment)
 	if err != nil {
@@ -249,35 +291,16 @@ func updateDeployment(
 }
 
 func findDeployments(requestID, baseDirectory string) ([]burnin.Deployment, error) {
-	runsDir := path.Join(baseDirectory, "runs")
 	pattern := fmt.Sprintf("run-*-%s.toml", requestID)
-	runFiles, err := filepath.Glob(path.Join(runsDir, pattern))
+	deployments, err := readDeployments(path.Join(baseDirectory, "runs"), pattern)
 	if err != nil {
 		return nil, err
 	}
 
-	if runFiles == nil {
+	if len(deployments) == 0 {
 		return nil, fmt.Errorf("no \"run\" files found for burn-in request '%s'", requestID)
 	}
 
-	deployments := make([]burnin.Deployment, len(runFiles))
-	for i, runFile := range runFiles {
-		fd, err := os.Open(runFile)
-		if err != nil {
-			return nil, err
-		}
-
-		var deployment burnin.Deployment
-		err = toml.NewDecoder(fd).Decode(&deployment)
-		_ = fd.Close()
-		if err != nil {
-			return nil, err
-		}
-
-		deployment.Filename = strings.Replace(runFile, runsDir, "", 1)
-		deployments[i] = deployment
-	}
-
 	return deployments, nil
 }
 
@@ -364,5 +387,52 @@ func parseRequestFile(path string) (burnin.Request, error) {
 		}
 	}
 
+	if request.BinaryChecksum != "" {
+		if b, err := hex.DecodeString(request.BinaryChecksum); err != nil || len(b) != sha256.Size {
+			return request, fmt.Errorf("invalid binary checksum '%s' (must be a hex encoded SHA-256)", request.BinaryChecksum)
+		}
+	}
+
 	return request, nil
 }
+
+// checkExtraVars only accepts allowed variables with a string, number, boolean or an array of these as value.
+func checkExtraVars(vars map[string]interface{}, allowed []string) error {
+	isAllowed := make(map[string]bool)
+	for _, name := range allowed {
+		isAllowed[name] = true
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !isAllowed[name] {
+			return fmt.Errorf("extra var '%s' is not allowed (allowed are: %s)", name, strings.Join(allowed, ", "))
+		}
+
+		value := vars[name]
+		if values, ok := value.([]interface{}); ok {
+			for _, v := range values {
+				if !isScalar(v) {
+					return fmt.Errorf("extra var '%s' must only contain strings, numbers and booleans", name)
+				}
+			}
+		} else if !isScalar(value) {
+			return fmt.Errorf("extra var '%s' must be a string, number, boolean or array of these", name)
+		}
+	}
+
+	return nil
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, int64, float64, bool:
+		return true
+	}
+	return false
+}
@@ -16,6 +16,7 @@
 package job
 
 import (
+	"errors"
 	"log"
 	"regexp"
 	"strings"
@@ -270,12 +271,12 @@ func Test_ProcessRequest_create_requests(t *testing.T) {
 	for _, c := range cases {
 		log.Println(c.description)
 		burninGitlab, buildGitlab := newMockGitlabClientsForRequestCase(c)
-		matrix := new(mockMatrix)
+		notifier := new(mockNotifier)
 		getJobCallCount = 0
 		startJobCallCount = 0
 		getPipelinesForBranchCallCount = 0
 
-		err := ProcessRequest("testdata", "master", burninGitlab, buildGitlab, mockPoller, matrix)
+		err := ProcessRequest("testdata", "master", burninGitlab, buildGitlab, mockPoller, notifier, Options{})
 
 		require.NoError(t, err)
 
@@ -314,14 +315,42 @@ func Test_ProcessRequest_create_requests(t *testing.T) {
 			}
 		}
 
-		require.Len(t, matrix.requestNotificationCalls, 1)
+		require.Len(t, notifier.requestNotificationCalls, 1)
 		require.True(t, len(burninGitlab.createBranchCalls) == 0)
 		require.True(t, len(burninGitlab.createMergeRequestCalls) == 0)
 		require.Len(t, burninGitlab.deleteFileCalls, 0)
-		require.Len(t, matrix.deploymentNotificationCalls, 0)
-		require.Len(t, matrix.updateNotificationCalls, 0)
-		require.Len(t, matrix.cleanupNotificationCalls, 0)
-		require.Len(t, matrix.errorNotificationCalls, 0)
+		require.Len(t, notifier.deploymentNotificationCalls, 0)
+		require.Len(t, notifier.updateNotificationCalls, 0)
+		require.Len(t, notifier.cleanupNotificationCalls, 0)
+		require.Len(t, notifier.errorNotificationCalls, 0)
+	}
+}
+
+func Test_processNewRequest_notificationFailed(t *testing.T) {
+	customBinary := "https://gitlab.example.com/mocks/mockproject/-/jobs/752482/artifacts/raw/artifacts/polkadot"
+	request := burnin.Request{
+		PullRequest:  "https://github.com/paritytech/polkadot/pull/2013",
+		RequestedBy:  "mxinden",
+		CustomBinary: &customBinary,
+		Nodes:        burnin.NodesPerNetworkMap{"kusama": {burnin.FullNode: 1}, "westend": {burnin.FullNode: 1}},
+	}
+
+	for _, notifier := range []*mockNotifier{
+		{thread: "$root:matrix.example.com", requestErr: errors.New("slack: 500 Internal Server Error")},
+		{requestErr: errors.New("matrix: connection refused")},
+	} {
+		gitlab := newMockGitlabClient(burnin.CommitDiff{})
+
+		err := processNewRequest("1607684670", request, "master", gitlab, gitlab, mockPoller, notifier)
+
+		require.NoError(t, err, "a failed notification must not hold up the burn-in")
+		require.Len(t, notifier.requestNotificationCalls, 1)
+		require.Len(t, gitlab.createFileCalls, 2)
+		for _, call := range gitlab.createFileCalls {
+			var deployment burnin.Deployment
+			require.NoError(t, toml.Unmarshal(call.content, &deployment))
+			require.Equal(t, notifier.thread, deployment.MatrixThread)
+		}
 	}
 }
 
@@ -595,12 +624,12 @@ func Test_ProcessRequest_update_requests(t *testing.T) {
 		log.Println(c.description)
 
 		burninGitlab, buildGitlab := newMockGitlabClientsForRequestCase(c)
-		matrix := new(mockMatrix)
+		notifier := new(mockNotifier)
 		getJobCallCount = 0
 		startJobCallCount = 0
 		getPipelineForCommitCallCount = 0
 
-		err := ProcessRequest("testdata", "master", burninGitlab, buildGitlab, mockPoller, matrix)
+		err := ProcessRequest("testdata", "master", burninGitlab, buildGitlab, mockPoller, notifier, Options{})
 
 		require.NoError(t, err, c.description)
 		require.Equal(t, 0, len(burninGitlab.createBranchCalls))
@@ -630,15 +659,17 @@ func Test_ProcessRequest_update_requests(t *testing.T) {
 			require.NotNil(t, deployment.DeployedAt)
 			require.NotNil(t, deployment.UpdatedAt)
 			require.NotNil(t, deployment.DeployedOn)
+			require.NotEmpty(t, deployment.PreviousBinary, "the binary running before the update is kept for rollbacks")
+			require.NotEqual(t, deployment.CustomBinary, deployment.PreviousBinary)
 		}
 
 		require.Len(t, burninGitlab.createMergeRequestCalls, 0)
 
-		require.Len(t, matrix.requestNotificationCalls, 0) // request updates do not trigger notifications
-		require.Len(t, matrix.deploymentNotificationCalls, 0)
-		require.Len(t, matrix.updateNotificationCalls, 0)
-		require.Len(t, matrix.cleanupNotificationCalls, 0)
-		require.Len(t, matrix.errorNotificationCalls, 0)
+		require.Len(t, notifier.requestNotificationCalls, 0) // request updates do not trigger notifications
+		require.Len(t, notifier.deploymentNotificationCalls, 0)
+		require.Len(t, notifier.updateNotificationCalls, 0)
+		require.Len(t, notifier.cleanupNotificationCalls, 0)
+		require.Len(t, notifier.errorNotificationCalls, 0)
 	}
 }
 
@@ -826,6 +857,12 @@ func Test_parseRequestFile(t *testing.T) {
 			true,
 			burnin.Request{},
 		},
+		{
+			"invalid binary checksum",
+			"testdata/requests/request-1607684670_invalid_checksum.toml",
+			true,
+			burnin.Request{},
+		},
 		{
 			"invalid pull request URL",
 			"testdata/requests/request-1607684670_invalid_pr_url.toml",
@@ -886,6 +923,25 @@ func newMockGitlabClientsForRequestCase(testCase processRequestTestCase) (*mockG
 	return burninGitlab, buildGitlab
 }
 
+func Test_checkExtraVars(t *testing.T) {
+	allowed := []string{"node_pruning", "node_features"}
+
+	require.NoError(t, checkExtraVars(nil, nil))
+	require.NoError(t, checkExtraVars(map[string]interface{}{
+		"node_pruning":  int64(1000),
+		"node_features": []interface{}{"a", true, 1.5},
+	}, allowed))
+
+	err := checkExtraVars(map[string]interface{}{"node_pruning": int64(1000), "ansible_host": "evil.example.com"}, allowed)
+	require.EqualError(t, err, "extra var 'ansible_host' is not allowed (allowed are: node_pruning, node_features)")
+
+	err = checkExtraVars(map[string]interface{}{"node_pruning": map[string]interface{}{"blocks": int64(1000)}}, allowed)
+	require.EqualError(t, err, "extra var 'node_pruning' must be a string, number, boolean or array of these")
+
+	err = checkExtraVars(map[string]interface{}{"node_features": []interface{}{[]interface{}{"a"}}}, allowed)
+	require.EqualError(t, err, "extra var 'node_features' must only contain strings, numbers and booleans")
+}
+
 func mkCommitDiff(newPath string, newFile, renamedFile, deletedFile bool, patch string) burnin.CommitDiff {
 	mode := "0655"
 
@@ -0,0 +1,129 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// rollbackReason tells why an update has to be rolled back, if it has to: the playbook failed, the node is unhealthy
+// afterwards or the alert gate found gating alerts firing. Other errors of the alert gate, e.g. of the Alertmanager
+// API, say nothing about the update.
+func rollbackReason(playbookErr error, health *burnin.HealthCheck, alertsErr error) error {
+	if playbookErr != nil {
+		return playbookErr
+	}
+	if health != nil && !health.Healthy {
+		return fmt.Errorf("the node is unhealthy after the update: %s", strings.Join(health.Problems, "; "))
+	}
+	var firing *burnin.FiringAlertsError
+	if errors.As(alertsErr, &firing) {
+		return alertsErr
+	}
+	return nil
+}
+
+// rollback deploys the previous binary and options of the deployment again, commits them to the "run" file and tells
+// about it. The update is not retried, as the commit skips CI. The returned error always wraps reason, so that the job
+// fails even if the rollback succeeds. If the rollback fails, too, the host is left for a human to look at and the
+// error names both failures.
+func rollback(
+	path string,
+	deployment burnin.Deployment,
+	reason error,
+	gitlab burnin.Gitlab,
+	alertmanager burnin.Alertmanager,
+	driver burnin.DeploymentDriver,
+	notifier burnin.Notifier,
+	branch string,
+	opts Options,
+) error {
+	previousBinary, err := url.Parse(deployment.PreviousBinary)
+	if err != nil {
+		return fmt.Errorf("%w, rolling back failed: %v", reason, err)
+	}
+	log.Printf("rolling back %s to %s: %v\n", deployment.DeployedOn, burnin.RedactURL(previousBinary), reason)
+
+	comment := fmt.Sprintf("Rolling back burn-in test for %s on %s", deployment.PullRequest, deployment.DeployedOn)
+	s, err := startSilence(alertmanager, hostMatchers(deployment.DeployedOn), comment, opts)
+	if err != nil {
+		return fmt.Errorf("%w, rolling back failed: %v", reason, err)
+	}
+
+	playbook := fmt.Sprintf("%s-nodes.yml", deployment.Network)
+	err = driver.RunPlaybook(burnin.PlaybookRun{
+		Playbook:       playbook,
+		Network:        deployment.Network,
+		RunOn:          deployment.PublicFQDN,
+		NodePublicName: deployment.DeployedOn,
+		NodeBinary:     previousBinary,
+		BinaryChecksum: deployment.PreviousBinaryChecksum,
+		CustomOptions:  deployment.PreviousCustomOptions,
+		ExtraVars:      deployment.PreviousExtraVars,
+	})
+	finishSilence(s, opts)
+	if err != nil {
+		return fmt.Errorf(
+			"%w, rolling back to %s failed as well: %v",
+			reason,
+			burnin.RedactURL(previousBinary),
+			playbookFailed(playbook, err),
+		)
+	}
+
+	deployment = addHistory(deployment, "update", deployment.DeployedOn, "rolled back", reason.Error(), opts)
+	rolledBack := deployment
+	rolledBack.RolledBackFrom = deployment.CustomBinary
+	rolledBack.RollbackReason = reason.Error()
+	rolledBack.CustomBinary = deployment.PreviousBinary
+	rolledBack.BinaryChecksum = deployment.PreviousBinaryChecksum
+	rolledBack.CommitSHA = deployment.PreviousCommitSHA
+	rolledBack.CustomOptions = deployment.PreviousCustomOptions
+	rolledBack.ExtraVars = deployment.PreviousExtraVars
+	rolledBack.PreviousBinary, rolledBack.PreviousBinaryChecksum, rolledBack.PreviousCommitSHA = "", "", ""
+	rolledBack.PreviousCustomOptions, rolledBack.PreviousExtraVars = nil, nil
+	rolledBack.Health = checkHealth(opts.HealthChecker, rolledBack, rolledBack.DeployedOn)
+	rolledBack.UpdatedAt = time.Now().UTC()
+	outcome, details := healthOutcome(rolledBack.Health)
+	rolledBack = addHistory(rolledBack, "rollback", rolledBack.DeployedOn, outcome, details, opts)
+
+	runFileContent, err := toml.Marshal(rolledBack)
+	if err != nil {
+		return fmt.Errorf("%w, rolled back but failed to update the \"run\" file: %v", reason, err)
+	}
+	commitMsg := gitlab.PrefixSkipCI(fmt.Sprintf("Roll back burn-in on %s to 'previous_binary'", rolledBack.DeployedOn))
+	if err := gitlab.UpdateFile(path, branch, commitMsg, runFileContent); err != nil {
+		return fmt.Errorf("%w, rolled back but failed to update the \"run\" file: %v", reason, err)
+	}
+
+	if err := notifier.SendRollbackNotification(rolledBack); err != nil {
+		return fmt.Errorf("%w, rolled back but failed to send the notification: %v", reason, err)
+	}
+
+	if err := checkAlerts(alertmanager, rolledBack.DeployedOn, opts.AlertGate); err != nil {
+		return fmt.Errorf("%w, rolled back but %v", reason, err)
+	}
+
+	return fmt.Errorf("%w, rolled back to %s", reason, burnin.RedactURL(previousBinary))
+}
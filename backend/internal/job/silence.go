@@ -0,0 +1,170 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+const silenceCreatedBy = "Burn-in Automator"
+
+// silence is a silence covering a single playbook run. It is either created by the job or an existing silence with
+// the same matchers, which is extended if necessary.
+type silence struct {
+	alertmanager burnin.Alertmanager
+	silence      burnin.Silence
+	owned        bool      // created by a burn-in job, so it can be expired when the playbook is done
+	originalEnd  time.Time // end of a silence that is not owned, before it was extended
+	extended     bool
+}
+
+func hostMatchers(hostname string) []burnin.AlertMatcher {
+	return []burnin.AlertMatcher{
+		{
+			Name:    "instance",
+			Value:   fmt.Sprintf(".*%s.*", hostname),
+			IsRegex: true,
+		},
+	}
+}
+
+// startSilence silences the given matchers for opts.SilenceDuration. Existing silences with exactly the same matchers
+// are reused, and extended if they end too early, instead of stacking up duplicates.
+func startSilence(
+	alertmanager burnin.Alertmanager,
+	matchers []burnin.AlertMatcher,
+	comment string,
+	opts Options,
+) (*silence, error) {
+	now := time.Now()
+	endsAt := now.Add(opts.silenceDuration())
+
+	existing, err := alertmanager.GetSilences()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range existing {
+		if !equalMatchers(s.Matchers, matchers) {
+			continue
+		}
+
+		result := &silence{
+			alertmanager: alertmanager,
+			silence:      s,
+			owned:        s.CreatedBy == silenceCreatedBy,
+			originalEnd:  s.EndsAt,
+		}
+
+		if s.EndsAt.Before(endsAt) {
+			log.Printf("extending silence %s until %s\n", s.ID, endsAt.Format(time.RFC3339))
+			result.silence.EndsAt = endsAt
+			if result.silence.ID, err = alertmanager.UpdateSilence(result.silence); err != nil {
+				return nil, err
+			}
+			result.extended = true
+		} else {
+			log.Printf("reusing silence %s\n", s.ID)
+		}
+
+		return result, nil
+	}
+
+	id, err := alertmanager.CreateSilence(matchers, now, endsAt, silenceCreatedBy, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &silence{
+		alertmanager: alertmanager,
+		silence: burnin.Silence{
+			ID:        id,
+			Matchers:  matchers,
+			StartsAt:  now,
+			EndsAt:    endsAt,
+			CreatedBy: silenceCreatedBy,
+			Comment:   comment,
+		},
+		owned: true,
+	}, nil
+}
+
+// finish expires the silence after opts.SilenceGrace. Silences which were not created by a burn-in job are left alone,
+// except that an extension is shortened back as far as possible.
+func (s *silence) finish(opts Options) error {
+	if !s.owned && !s.extended {
+		return nil
+	}
+
+	endsAt := time.Now().Add(opts.SilenceGrace)
+	if !s.owned && s.originalEnd.After(endsAt) {
+		endsAt = s.originalEnd
+	}
+
+	if s.owned && opts.SilenceGrace <= 0 {
+		log.Printf("expiring silence %s\n", s.silence.ID)
+		return s.alertmanager.DeleteSilence(s.silence.ID)
+	}
+
+	log.Printf("silence %s ends at %s\n", s.silence.ID, endsAt.Format(time.RFC3339))
+	s.silence.EndsAt = endsAt
+	id, err := s.alertmanager.UpdateSilence(s.silence)
+	if err != nil {
+		return err
+	}
+
+	s.silence.ID = id
+	return nil
+}
+
+// finishSilence is called once the playbook has finished. Failing to expire the silence only delays alerts, so it
+// must not fail the job.
+func finishSilence(s *silence, opts Options) {
+	if err := s.finish(opts); err != nil {
+		log.Printf("expiring silence %s failed: %v\n", s.silence.ID, err)
+	}
+}
+
+func equalMatchers(a, b []burnin.AlertMatcher) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sorted := func(matchers []burnin.AlertMatcher) []burnin.AlertMatcher {
+		s := append([]burnin.AlertMatcher(nil), matchers...)
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].Name != s[j].Name {
+				return s[i].Name < s[j].Name
+			}
+			return s[i].Value < s[j].Value
+		})
+		return s
+	}
+
+	sa, sb := sorted(a), sorted(b)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+
+	return true
+}
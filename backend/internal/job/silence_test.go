@@ -0,0 +1,113 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func Test_startSilence_creates_and_expires(t *testing.T) {
+	alertmanager := new(mockAlertManager)
+
+	s, err := startSilence(alertmanager, hostMatchers("kusama-fullnode-uw1-0"), "testing", Options{SilenceDuration: 3 * time.Hour})
+	require.NoError(t, err)
+	require.Len(t, alertmanager.createSilenceCalls, 1)
+	call := alertmanager.createSilenceCalls[0]
+	require.Equal(t, 3*time.Hour, call.endsAt.Sub(call.startsAt))
+
+	require.NoError(t, s.finish(Options{}))
+	require.Equal(t, []string{"alert-1234"}, alertmanager.deleteSilenceCalls)
+	require.Empty(t, alertmanager.updateSilenceCalls)
+}
+
+func Test_startSilence_default_duration_and_grace(t *testing.T) {
+	alertmanager := new(mockAlertManager)
+
+	s, err := startSilence(alertmanager, hostMatchers("kusama-fullnode-uw1-0"), "testing", Options{})
+	require.NoError(t, err)
+	call := alertmanager.createSilenceCalls[0]
+	require.Equal(t, defaultSilenceDuration, call.endsAt.Sub(call.startsAt))
+
+	require.NoError(t, s.finish(Options{SilenceGrace: 10 * time.Minute}))
+	require.Empty(t, alertmanager.deleteSilenceCalls)
+	require.Len(t, alertmanager.updateSilenceCalls, 1)
+	require.WithinDuration(t, time.Now().Add(10*time.Minute), alertmanager.updateSilenceCalls[0].EndsAt, time.Minute)
+}
+
+func Test_startSilence_reuses_own_silence(t *testing.T) {
+	existing := burnin.Silence{
+		ID: "existing",
+		Matchers: append(
+			[]burnin.AlertMatcher{{Name: "chain", Value: "kusama"}},
+			hostMatchers("kusama-fullnode-uw1-0")...,
+		),
+		EndsAt:    time.Now().Add(10 * time.Minute),
+		CreatedBy: silenceCreatedBy,
+	}
+	alertmanager := &mockAlertManager{silences: []burnin.Silence{
+		{ID: "other-host", Matchers: hostMatchers("kusama-fullnode-uw1-1"), EndsAt: time.Now().Add(time.Hour)},
+		existing,
+	}}
+
+	matchers := append(hostMatchers("kusama-fullnode-uw1-0"), burnin.AlertMatcher{Name: "chain", Value: "kusama"})
+	s, err := startSilence(alertmanager, matchers, "testing", Options{})
+	require.NoError(t, err)
+	require.Empty(t, alertmanager.createSilenceCalls)
+	require.Len(t, alertmanager.updateSilenceCalls, 1, "silence should have been extended")
+	require.Equal(t, "existing", alertmanager.updateSilenceCalls[0].ID)
+	require.WithinDuration(t, time.Now().Add(defaultSilenceDuration), alertmanager.updateSilenceCalls[0].EndsAt, time.Minute)
+
+	require.NoError(t, s.finish(Options{}))
+	require.Equal(t, []string{"existing"}, alertmanager.deleteSilenceCalls)
+}
+
+func Test_startSilence_leaves_foreign_silence_alone(t *testing.T) {
+	maintenanceEnd := time.Now().Add(24 * time.Hour)
+	alertmanager := &mockAlertManager{silences: []burnin.Silence{{
+		ID:        "maintenance",
+		Matchers:  hostMatchers("kusama-fullnode-uw1-0"),
+		EndsAt:    maintenanceEnd,
+		CreatedBy: "someone@example.com",
+	}}}
+
+	s, err := startSilence(alertmanager, hostMatchers("kusama-fullnode-uw1-0"), "testing", Options{})
+	require.NoError(t, err)
+	require.NoError(t, s.finish(Options{}))
+	require.Empty(t, alertmanager.createSilenceCalls)
+	require.Empty(t, alertmanager.updateSilenceCalls)
+	require.Empty(t, alertmanager.deleteSilenceCalls)
+}
+
+func Test_startSilence_shortens_extended_foreign_silence(t *testing.T) {
+	originalEnd := time.Now().Add(5 * time.Minute)
+	alertmanager := &mockAlertManager{silences: []burnin.Silence{{
+		ID:        "short",
+		Matchers:  hostMatchers("kusama-fullnode-uw1-0"),
+		EndsAt:    originalEnd,
+		CreatedBy: "someone@example.com",
+	}}}
+
+	s, err := startSilence(alertmanager, hostMatchers("kusama-fullnode-uw1-0"), "testing", Options{})
+	require.NoError(t, err)
+	require.NoError(t, s.finish(Options{}))
+	require.Empty(t, alertmanager.deleteSilenceCalls)
+	require.Len(t, alertmanager.updateSilenceCalls, 2)
+	require.Equal(t, originalEnd, alertmanager.updateSilenceCalls[1].EndsAt)
+}
@@ -33,10 +33,11 @@ func ProcessUpdate(
 	baseDirectory string,
 	baseBranch string,
 	gitlab burnin.Gitlab,
-	alertmanager burnin.Alertmanager,
-	ansible burnin.AnsibleDriver,
-	matrix burnin.Matrix,
-) error {
+	alertmanagers burnin.Alertmanagers,
+	driver burnin.DeploymentDriver,
+	notifier burnin.Notifier,
+	opts Options,
+) (err error) {
 	diffs, err := diffsToCurrentCommit(baseBranch, gitlab)
 	if err != nil {
 		return err
@@ -63,6 +64,7 @@ func ProcessUpdate(
 	if err != nil {
 		return err
 	}
+	defer func() { err = withDeployment(deployment, err) }()
 
 	if deployment.DeployedOn == "" {
 		return errors.New(
@@ -76,34 +78,63 @@ func ProcessUpdate(
 		return err
 	}
 
+	alertmanager := alertmanagers.ForNetwork(deployment.Network)
 	log.Printf("creating silence for host %s\n", deployment.DeployedOn)
 	comment := fmt.Sprintf("Updating burn-in test for %s on %s", deployment.PullRequest, deployment.DeployedOn)
-	silenceID, err := createSilence(alertmanager, deployment.DeployedOn, comment)
+	s, err := startSilence(alertmanager, hostMatchers(deployment.DeployedOn), comment, opts)
 	if err != nil {
 		return err
 	}
-	log.Printf("silence id: %s\n", silenceID)
+	log.Printf("silence id: %s\n", s.silence.ID)
 
 	playbook := fmt.Sprintf("%s-nodes.yml", deployment.Network)
 	log.Printf("running ansible playbook %s on host %s\n", playbook, deployment.DeployedOn)
-	err = ansible.RunPlaybook(
-		playbook,
-		deployment.PublicFQDN,
-		customBinaryURL,
-		false,
-		deployment.DeployedOn,
-		deployment.CustomOptions,
-	)
+	err = driver.RunPlaybook(burnin.PlaybookRun{
+		Playbook:       playbook,
+		Network:        deployment.Network,
+		RunOn:          deployment.PublicFQDN,
+		NodePublicName: deployment.DeployedOn,
+		NodeBinary:     customBinaryURL,
+		BinaryChecksum: deployment.BinaryChecksum,
+		CustomOptions:  deployment.CustomOptions,
+		ExtraVars:      deployment.ExtraVars,
+	})
+	finishSilence(s, opts)
+	var alertsErr error
+	if err == nil {
+		deployment.Health = checkHealth(opts.HealthChecker, deployment, deployment.DeployedOn)
+		alertsErr = checkAlerts(alertmanager, deployment.DeployedOn, opts.AlertGate)
+	}
+
+	if reason := rollbackReason(playbookFailed(playbook, err), deployment.Health, alertsErr); reason != nil {
+		if deployment.PreviousBinary != "" {
+			return rollback(repoRunFilePath, deployment, reason, gitlab, alertmanager, driver, notifier, baseBranch, opts)
+		}
+		log.Printf("not rolling back %s, the \"run\" file has no 'previous_binary'\n", deployment.DeployedOn)
+	}
 	if err != nil {
-		return err
+		failure := playbookFailed(playbook, err)
+		return commitFailure(
+			repoRunFilePath, deployment, "update", deployment.DeployedOn, failure, gitlab, baseBranch, opts,
+		)
+	}
+
+	outcome, details := healthOutcome(deployment.Health)
+	if alertsErr != nil {
+		outcome, details = "failed", alertsErr.Error()
 	}
+	deployment = addHistory(deployment, "update", deployment.DeployedOn, outcome, details, opts)
 
 	deployment, err = updateDeploymentInfo(repoRunFilePath, deployment, deployment.DeployedOn, gitlab, baseBranch)
 	if err != nil {
 		return err
 	}
 
-	return matrix.SendUpdateNotification(deployment)
+	if alertsErr != nil {
+		return alertsErr
+	}
+
+	return notifier.SendUpdateNotification(deployment)
 }
 
 func updateDeploymentInfo(
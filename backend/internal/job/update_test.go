@@ -16,9 +16,12 @@
 package job
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/pelletier/go-toml"
 	"github.com/stretchr/testify/require"
 	burnin "gitlab.example.com/burn-in-tests/backend"
 )
@@ -29,10 +32,10 @@ func Test_ProcessUpdate(t *testing.T) {
 	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1610469388.toml", false, false, false, validDiffContent)
 	gitlab := newMockGitlabClient(diff)
 	alertmanager := new(mockAlertManager)
-	ansible := new(mockAnsibleDriver)
-	matrix := new(mockMatrix)
+	ansible := new(mockDeploymentDriver)
+	notifier := new(mockNotifier)
 
-	err := ProcessUpdate("testdata", "master", gitlab, alertmanager, ansible, matrix)
+	err := ProcessUpdate("testdata", "master", gitlab, alertmanager, ansible, notifier, Options{})
 
 	require.NoError(t, err)
 
@@ -49,18 +52,20 @@ func Test_ProcessUpdate(t *testing.T) {
 		"Updating burn-in test for https://github.com/paritytech/polkadot/pull/2013 on kusama-fullnode-uw1-0",
 		silenceCall.comment,
 	)
+	require.Equal(t, []string{"alert-1234"}, alertmanager.deleteSilenceCalls, "silence should expire after the playbook")
+	require.Equal(t, []string{"kusama"}, alertmanager.networks)
 
 	require.Len(t, ansible.runPlaybookCalls, 1)
 	playbookCall := ansible.runPlaybookCalls[0]
-	require.Equal(t, "kusama-nodes.yml", playbookCall.name)
-	require.Equal(t, "kusama-fullnode-uw1-0.example.com", playbookCall.runOn)
-	require.Equal(t, "kusama-fullnode-uw1-0", playbookCall.nodePublicName)
-	require.NotNil(t, playbookCall.nodeBinary)
+	require.Equal(t, "kusama-nodes.yml", playbookCall.Playbook)
+	require.Equal(t, "kusama-fullnode-uw1-0.example.com", playbookCall.RunOn)
+	require.Equal(t, "kusama-fullnode-uw1-0", playbookCall.NodePublicName)
+	require.NotNil(t, playbookCall.NodeBinary)
 
 	require.Equal(
 		t,
 		"https://gitlab.example.com/mocks/mockproject/-/jobs/752482/artifacts/raw/artifacts/polkadot",
-		playbookCall.nodeBinary.String(),
+		playbookCall.NodeBinary.String(),
 	)
 
 	require.Len(t, gitlab.createFileCalls, 0)
@@ -72,15 +77,216 @@ func Test_ProcessUpdate(t *testing.T) {
 	require.Equal(t, "runs/run-kusama-fullnode-0-1610469388.toml", updateCall.path)
 	require.True(t, strings.HasPrefix(updateCall.commitMsg, "[skip ci]"))
 
-	require.Len(t, matrix.requestNotificationCalls, 0)
-	require.Len(t, matrix.deploymentNotificationCalls, 0)
-	require.Len(t, matrix.cleanupNotificationCalls, 0)
-	require.Len(t, matrix.errorNotificationCalls, 0)
-	require.Len(t, matrix.updateNotificationCalls, 1)
-	matrixCall := matrix.updateNotificationCalls[0]
-	require.NotEmpty(t, matrixCall.UpdatedAt)
-	require.NotNil(t, matrixCall.DeployedOn)
-	require.Equal(t, "kusama-fullnode-uw1-0", matrixCall.DeployedOn)
+	require.Len(t, notifier.requestNotificationCalls, 0)
+	require.Len(t, notifier.deploymentNotificationCalls, 0)
+	require.Len(t, notifier.cleanupNotificationCalls, 0)
+	require.Len(t, notifier.errorNotificationCalls, 0)
+	require.Len(t, notifier.updateNotificationCalls, 1)
+	notificationCall := notifier.updateNotificationCalls[0]
+	require.NotEmpty(t, notificationCall.UpdatedAt)
+	require.NotNil(t, notificationCall.DeployedOn)
+	require.Equal(t, "kusama-fullnode-uw1-0", notificationCall.DeployedOn)
+}
+
+func Test_ProcessUpdate_playbookFailed(t *testing.T) {
+	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1610469388.toml", false, false, false, validDiffContent)
+	gitlab := newMockGitlabClient(diff)
+	alertmanager := new(mockAlertManager)
+	ansible := &mockDeploymentDriver{err: &burnin.PlaybookError{
+		Playbook: "kusama-nodes.yml",
+		Result: burnin.PlaybookResult{Tasks: []burnin.TaskResult{
+			{Task: "Download binary", Host: "kusama-fullnode-uw1-0", Changed: true},
+			{Task: "Restart node", Host: "kusama-fullnode-uw1-0", Failed: true, Msg: "non-zero return code"},
+		}},
+	}}
+	notifier := new(mockNotifier)
+
+	err := ProcessUpdate("testdata", "master", gitlab, alertmanager, ansible, notifier, Options{})
+
+	require.EqualError(
+		t,
+		err,
+		"playbook kusama-nodes.yml failed: task 'Restart node' failed on kusama-fullnode-uw1-0: non-zero return code",
+	)
+	var jobErr *burnin.JobError
+	require.True(t, errors.As(err, &jobErr))
+	require.Equal(t, "kusama-fullnode-uw1-0", jobErr.Deployment.DeployedOn)
+	var playbookErr *burnin.PlaybookError
+	require.True(t, errors.As(err, &playbookErr))
+
+	require.Equal(t, []string{"alert-1234"}, alertmanager.deleteSilenceCalls, "silence should expire after the playbook")
+	require.Len(t, gitlab.updateFileCalls, 1, "the failure should be recorded in the history")
+	updateCall := gitlab.updateFileCalls[0]
+	require.Equal(t, "[skip ci] Record failed update on kusama-fullnode-uw1-0", updateCall.commitMsg)
+	var recorded burnin.Deployment
+	require.NoError(t, toml.Unmarshal(updateCall.content, &recorded))
+	require.Len(t, recorded.History, 1)
+	require.Equal(t, "update", recorded.History[0].Action)
+	require.Equal(t, "failed", recorded.History[0].Outcome)
+	require.Equal(t, err.Error(), recorded.History[0].Details)
+	require.Len(t, notifier.updateNotificationCalls, 0)
+
+	ansible = &mockDeploymentDriver{err: &burnin.PlaybookTimeoutError{Playbook: "kusama-nodes.yml", Timeout: 30 * time.Minute}}
+	err = ProcessUpdate("testdata", "master", newMockGitlabClient(diff), alertmanager, ansible, notifier, Options{})
+
+	require.EqualError(
+		t,
+		err,
+		"playbook kusama-nodes.yml timed out after 30m0s and was killed, the host may be left half-deployed: timed out after 30m0s",
+	)
+	var timeoutErr *burnin.PlaybookTimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+}
+
+func Test_ProcessUpdate_health(t *testing.T) {
+	diff := mkCommitDiff("runs/run-kusama-fullnode-0-1610469388.toml", false, false, false, validDiffContent)
+	gitlab := newMockGitlabClient(diff)
+	checker := &mockHealthChecker{checks: []burnin.HealthCheck{{
+		CheckedAt:  time.Date(2021, 1, 12, 17, 0, 0, 0, time.UTC),
+		Version:    "0.8.27-a7810560c0f",
+		Peers:      2,
+		FirstBlock: 1000,
+		LastBlock:  1020,
+		Problems:   []string{"2 peers, expected at least 3"},
+	}}}
+	notifier := new(mockNotifier)
+
+	err := ProcessUpdate(
+		"testdata", "master", gitlab, new(mockAlertManager), new(mockDeploymentDriver), notifier,
+		Options{HealthChecker: checker},
+	)
+
+	require.NoError(t, err)
+	require.Equal(t, []checkHealthArgs{
+		{"kusama-fullnode-uw1-0-int.foo-chains.example.com", "a7810560c0f62dd6d347e710a5e2a64da465c109"},
+	}, checker.checkHealthCalls)
+
+	require.Len(t, gitlab.updateFileCalls, 1)
+	content := string(gitlab.updateFileCalls[0].content)
+	require.Contains(t, content, "[health]")
+	require.Contains(t, content, "healthy = false")
+	require.Contains(t, content, `problems = ["2 peers, expected at least 3"]`)
+
+	require.Len(t, notifier.updateNotificationCalls, 1)
+	require.Equal(t, &checker.checks[0], notifier.updateNotificationCalls[0].Health)
+	history := notifier.updateNotificationCalls[0].History
+	require.Len(t, history, 1)
+	require.Equal(t, "update", history[0].Action)
+	require.Equal(t, "unhealthy", history[0].Outcome)
+	require.Equal(t, "2 peers, expected at least 3", history[0].Details)
+}
+
+func Test_ProcessUpdate_rollback(t *testing.T) {
+	runFile := "runs/run-kusama-fullnode-0-1610470000.toml"
+	diff := mkCommitDiff(runFile, false, false, false, validDiffContent)
+	gitlab := newMockGitlabClient(diff)
+	alertmanager := new(mockAlertManager)
+	driver := &mockDeploymentDriver{err: &burnin.PlaybookError{
+		Playbook: "kusama-nodes.yml",
+		Result: burnin.PlaybookResult{Tasks: []burnin.TaskResult{
+			{Task: "Restart node", Host: "kusama-fullnode-uw1-0", Failed: true, Msg: "non-zero return code"},
+		}},
+	}}
+	notifier := new(mockNotifier)
+
+	// The mock fails the rollback as well.
+	err := ProcessUpdate("testdata", "master", gitlab, alertmanager, driver, notifier, Options{})
+
+	require.EqualError(
+		t,
+		err,
+		"playbook kusama-nodes.yml failed: task 'Restart node' failed on kusama-fullnode-uw1-0: non-zero return code, "+
+			"rolling back to https://gitlab.example.com/mocks/mockproject/-/jobs/748812/artifacts/raw/artifacts/polkadot "+
+			"failed as well: playbook kusama-nodes.yml failed: task 'Restart node' failed on kusama-fullnode-uw1-0: "+
+			"non-zero return code",
+	)
+	var playbookErr *burnin.PlaybookError
+	require.True(t, errors.As(err, &playbookErr), "the error of the update must be kept")
+	require.Len(t, driver.runPlaybookCalls, 2)
+	require.Len(t, gitlab.updateFileCalls, 0)
+	require.Len(t, notifier.rollbackNotificationCalls, 0)
+
+	// unhealthy after the update, healthy after the rollback
+	gitlab = newMockGitlabClient(diff)
+	driver = new(mockDeploymentDriver)
+	checker := &mockHealthChecker{checks: []burnin.HealthCheck{
+		{Problems: []string{"best block not advancing (#1000)"}},
+		{Healthy: true},
+	}}
+	notifier = new(mockNotifier)
+
+	err = ProcessUpdate("testdata", "master", gitlab, alertmanager, driver, notifier, Options{HealthChecker: checker})
+
+	require.EqualError(
+		t,
+		err,
+		"the node is unhealthy after the update: best block not advancing (#1000), rolled back to "+
+			"https://gitlab.example.com/mocks/mockproject/-/jobs/748812/artifacts/raw/artifacts/polkadot",
+		"the job must fail even though the rollback succeeded",
+	)
+	var jobErr *burnin.JobError
+	require.True(t, errors.As(err, &jobErr))
+	require.Len(t, alertmanager.getAlertsCalls, 2, "the alerts must be checked after the update and after the rollback")
+	require.Len(t, driver.runPlaybookCalls, 2)
+	rollbackRun := driver.runPlaybookCalls[1]
+	require.Equal(
+		t,
+		"https://gitlab.example.com/mocks/mockproject/-/jobs/748812/artifacts/raw/artifacts/polkadot",
+		rollbackRun.NodeBinary.String(),
+	)
+	require.Nil(t, rollbackRun.CustomOptions, "the update added the custom options")
+
+	require.Len(t, gitlab.updateFileCalls, 1)
+	updateCall := gitlab.updateFileCalls[0]
+	require.Equal(t, runFile, updateCall.path)
+	require.True(t, strings.HasPrefix(updateCall.commitMsg, "[skip ci]"), "the rollback must not trigger another update")
+
+	require.Len(t, notifier.updateNotificationCalls, 0)
+	require.Len(t, notifier.rollbackNotificationCalls, 1)
+	rolledBack := notifier.rollbackNotificationCalls[0]
+	require.Equal(
+		t,
+		"https://gitlab.example.com/mocks/mockproject/-/jobs/748812/artifacts/raw/artifacts/polkadot",
+		rolledBack.CustomBinary,
+	)
+	require.Equal(t, "f52b0b01d8f27fdb387667de5a56da2754ce77a1", rolledBack.CommitSHA)
+	require.Equal(
+		t,
+		"https://gitlab.example.com/mocks/mockproject/-/jobs/752482/artifacts/raw/artifacts/polkadot",
+		rolledBack.RolledBackFrom,
+	)
+	require.Equal(t, "the node is unhealthy after the update: best block not advancing (#1000)", rolledBack.RollbackReason)
+	require.Empty(t, rolledBack.PreviousBinary, "there is nothing to roll back to from the previous binary")
+	require.True(t, rolledBack.Health.Healthy)
+
+	require.Len(t, rolledBack.History, 2)
+	require.Equal(t, "update", rolledBack.History[0].Action)
+	require.Equal(t, "rolled back", rolledBack.History[0].Outcome)
+	require.Equal(t, rolledBack.RolledBackFrom, rolledBack.History[0].Binary)
+	require.Equal(t, "rollback", rolledBack.History[1].Action)
+	require.Equal(t, "succeeded", rolledBack.History[1].Outcome)
+	require.Equal(t, rolledBack.CustomBinary, rolledBack.History[1].Binary)
+	require.Contains(t, string(updateCall.content), "[[history]]")
+
+	// alerts firing after the rollback
+	gitlab = newMockGitlabClient(diff)
+	alertmanager = &mockAlertManager{alerts: []burnin.Alert{
+		{Labels: map[string]string{"alertname": "BlockProductionSlow", "severity": "critical"}},
+	}}
+	driver = new(mockDeploymentDriver)
+	checker = &mockHealthChecker{checks: []burnin.HealthCheck{{Problems: []string{"no peers"}}, {Healthy: true}}}
+	notifier = new(mockNotifier)
+
+	err = ProcessUpdate("testdata", "master", gitlab, alertmanager, driver, notifier, Options{HealthChecker: checker})
+
+	require.EqualError(
+		t,
+		err,
+		"the node is unhealthy after the update: no peers, rolled back but 1 alert(s) firing on kusama-fullnode-uw1-0: "+
+			"BlockProductionSlow",
+	)
+	require.Len(t, gitlab.updateFileCalls, 1)
+	require.Len(t, notifier.rollbackNotificationCalls, 1)
 }
 
 func Test_validUpdateCommit(t *testing.T) {
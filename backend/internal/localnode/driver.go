@@ -0,0 +1,344 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package localnode runs nodes as child processes on the local machine, e.g. to test the jobs end to end without
+// burn-in hosts.
+package localnode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// Options of the driver. Zero durations use the defaults.
+type Options struct {
+	Dir          string        // receives a directory per node with its binary, chain database, log and PID file
+	Binary       string        // runs this file instead of downloading the node binary, e.g. a stub in tests
+	StartupGrace time.Duration // a node exiting within this time fails the run
+	StopTimeout  time.Duration // between SIGTERM and SIGKILL when a node is replaced
+	Check        bool          // only log what would be done
+}
+
+const (
+	defaultStartupGrace = time.Second
+	defaultStopTimeout  = 10 * time.Second
+)
+
+// Driver implements burnin.DeploymentDriver by (re)starting the node binary with the custom options of the run. Nodes
+// are identified by their public name. Nodes started by another driver, e.g. the one of a previous job, are found
+// through their PID file.
+type Driver struct {
+	opts   Options
+	client *http.Client
+
+	mu    sync.Mutex
+	nodes map[string]*node // by node public name
+}
+
+type node struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+func NewDriver(opts Options) *Driver {
+	if opts.StartupGrace == 0 {
+		opts.StartupGrace = defaultStartupGrace
+	}
+	if opts.StopTimeout == 0 {
+		opts.StopTimeout = defaultStopTimeout
+	}
+
+	return &Driver{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Minute},
+		nodes:  make(map[string]*node),
+	}
+}
+
+func (d *Driver) RunPlaybook(run burnin.PlaybookRun) error {
+	dir := filepath.Join(d.opts.Dir, run.NodePublicName)
+	chainDB := filepath.Join(dir, "chain-db")
+	args := []string{"--name", run.NodePublicName, "--base-path", chainDB}
+	for _, option := range run.CustomOptions {
+		args = append(args, strings.Fields(option)...)
+	}
+
+	log.Printf(
+		"(re)starting %s locally with %v (for playbook %s)\n",
+		run.NodePublicName, burnin.RedactURL(run.NodeBinary), run.Playbook,
+	)
+	if d.opts.Check {
+		log.Printf("check mode, not running %s\n", strings.Join(args, " "))
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	binary, err := d.binary(run, dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Stop(run.NodePublicName); err != nil {
+		return err
+	}
+
+	if run.WipeChainDB {
+		log.Printf("wiping %s\n", chainDB)
+		if err := os.RemoveAll(chainDB); err != nil {
+			return err
+		}
+	}
+
+	return d.start(run, dir, binary, args)
+}
+
+// binary returns the path of the binary to run, which is downloaded and verified unless Options.Binary is set.
+func (d *Driver) binary(run burnin.PlaybookRun, dir string) (string, error) {
+	if d.opts.Binary != "" {
+		return d.opts.Binary, nil
+	}
+	if run.NodeBinary == nil {
+		return "", fmt.Errorf("no node binary to run %s", run.NodePublicName)
+	}
+
+	resp, err := d.client.Get(run.NodeBinary.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s failed: %s", burnin.RedactURL(run.NodeBinary), resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if run.BinaryChecksum != "" && !strings.EqualFold(sum, run.BinaryChecksum) {
+		return "", fmt.Errorf(
+			"checksum mismatch of %s: expected %s, got %s", burnin.RedactURL(run.NodeBinary), run.BinaryChecksum, sum,
+		)
+	}
+
+	binary := filepath.Join(dir, "polkadot-"+sum)
+	if err := os.Rename(tmp.Name(), binary); err != nil {
+		return "", err
+	}
+	return binary, os.Chmod(binary, 0755)
+}
+
+func (d *Driver) start(run burnin.PlaybookRun, dir, binary string, args []string) error {
+	logFile, err := os.OpenFile(filepath.Join(dir, "node.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), environment(run)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return err
+	}
+
+	n := &node{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		logFile.Close()
+		close(n.exited)
+	}()
+
+	d.mu.Lock()
+	d.nodes[run.NodePublicName] = n
+	d.mu.Unlock()
+
+	pid := cmd.Process.Pid
+	log.Printf("started %s with PID %d, logging to %s\n", run.NodePublicName, pid, logFile.Name())
+	// the start time tells the node from a later process with the same PID in Stop
+	content := strconv.Itoa(pid)
+	started, err := startTime(pid)
+	if err != nil {
+		return err
+	}
+	if started != "" {
+		content += " " + started
+	}
+	if err := ioutil.WriteFile(pidFile(dir), []byte(content), 0644); err != nil {
+		return err
+	}
+
+	select {
+	case <-n.exited:
+		return fmt.Errorf("%s exited right after starting (%v), see %s", run.NodePublicName, cmd.ProcessState, logFile.Name())
+	case <-time.After(d.opts.StartupGrace):
+		return nil
+	}
+}
+
+// Stop terminates the node, if it is running.
+func (d *Driver) Stop(nodePublicName string) error {
+	d.mu.Lock()
+	n, ok := d.nodes[nodePublicName]
+	delete(d.nodes, nodePublicName)
+	d.mu.Unlock()
+
+	dir := filepath.Join(d.opts.Dir, nodePublicName)
+	defer os.Remove(pidFile(dir))
+
+	if ok {
+		return d.stop(n.cmd.Process, n.exited)
+	}
+
+	// started by another driver
+	content, err := ioutil.ReadFile(pidFile(dir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid PID file of %s: empty", nodePublicName)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid PID file of %s: %v", nodePublicName, err)
+	}
+
+	// The PID may have been reused since the node exited, e.g. after a reboot.
+	if len(fields) > 1 {
+		started, err := startTime(pid)
+		if err != nil || started != fields[1] {
+			log.Printf("PID %d of %s is gone or belongs to another process, not stopping it\n", pid, nodePublicName)
+			return nil
+		}
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for process.Signal(syscall.Signal(0)) == nil {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	return d.stop(process, exited)
+}
+
+func (d *Driver) stop(process *os.Process, exited <-chan struct{}) error {
+	log.Printf("stopping PID %d\n", process.Pid)
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		return nil // already gone
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(d.opts.StopTimeout):
+		log.Printf("PID %d did not stop within %s, killing it\n", process.Pid, d.opts.StopTimeout)
+		if err := process.Kill(); err != nil {
+			return err
+		}
+		<-exited
+		return nil
+	}
+}
+
+// StopAll terminates all nodes started by the driver.
+func (d *Driver) StopAll() error {
+	for name := range d.PIDs() {
+		if err := d.Stop(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PIDs returns the process IDs of the nodes started by the driver which are still running, by node public name.
+func (d *Driver) PIDs() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pids := make(map[string]int)
+	for name, n := range d.nodes {
+		select {
+		case <-n.exited:
+		default:
+			pids[name] = n.cmd.Process.Pid
+		}
+	}
+	return pids
+}
+
+// environment passes the extra vars to the node, e.g. "node_pruning" as NODE_PRUNING.
+func environment(run burnin.PlaybookRun) []string {
+	env := []string{"NODE_PUBLIC_NAME=" + run.NodePublicName}
+	for name, value := range run.ExtraVars {
+		if values, ok := value.([]interface{}); ok {
+			parts := make([]string, len(values))
+			for i, v := range values {
+				parts[i] = fmt.Sprint(v)
+			}
+			value = strings.Join(parts, " ")
+		}
+		env = append(env, fmt.Sprintf("%s=%v", strings.ToUpper(name), value))
+	}
+	sort.Strings(env[1:])
+	return env
+}
+
+func pidFile(dir string) string {
+	return filepath.Join(dir, "node.pid")
+}
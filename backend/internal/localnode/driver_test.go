@@ -0,0 +1,168 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package localnode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// stubNode records its arguments and environment, then runs until it is stopped.
+const stubNode = `#!/bin/sh
+echo "$@" > args
+echo "$NODE_PUBLIC_NAME $NODE_PRUNING" > env
+mkdir -p chain-db
+exec sleep 60
+`
+
+func newTestDriver(t *testing.T, binary string) *Driver {
+	d := NewDriver(Options{Dir: t.TempDir(), Binary: binary, StartupGrace: 100 * time.Millisecond, StopTimeout: time.Second})
+	t.Cleanup(func() { require.NoError(t, d.StopAll()) })
+	return d
+}
+
+func writeStub(t *testing.T, script string) string {
+	stub := filepath.Join(t.TempDir(), "polkadot")
+	require.NoError(t, ioutil.WriteFile(stub, []byte(script), 0755))
+	return stub
+}
+
+func TestDriver_RunPlaybook(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	d := newTestDriver(t, writeStub(t, stubNode))
+	run := burnin.PlaybookRun{
+		Playbook:       "kusama-nodes.yml",
+		RunOn:          "localhost",
+		NodePublicName: "kusama-fullnode-uw1-0",
+		CustomOptions:  []string{"--wasm-execution Compiled", "--rpc-methods Unsafe"},
+		ExtraVars:      map[string]interface{}{"node_pruning": int64(1000)},
+	}
+	require.NoError(t, d.RunPlaybook(run))
+
+	dir := filepath.Join(d.opts.Dir, "kusama-fullnode-uw1-0")
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		"--name kusama-fullnode-uw1-0 --base-path "+filepath.Join(dir, "chain-db")+" --wasm-execution Compiled --rpc-methods Unsafe\n",
+		string(args),
+	)
+	env, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+	require.NoError(t, err)
+	require.Equal(t, "kusama-fullnode-uw1-0 1000\n", string(env))
+
+	first := d.PIDs()["kusama-fullnode-uw1-0"]
+	require.NotZero(t, first)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "chain-db", "db"), nil, 0644))
+
+	// An update replaces the process, wiping the chain database only if asked to.
+	require.NoError(t, d.RunPlaybook(run))
+	second := d.PIDs()["kusama-fullnode-uw1-0"]
+	require.NotEqual(t, first, second)
+	require.FileExists(t, filepath.Join(dir, "chain-db", "db"))
+
+	run.WipeChainDB = true
+	require.NoError(t, d.RunPlaybook(run))
+	require.NoFileExists(t, filepath.Join(dir, "chain-db", "db"))
+
+	// Another driver, e.g. of the next job, finds the node through its PID file.
+	other := newTestDriver(t, d.opts.Binary)
+	other.opts.Dir = d.opts.Dir
+	third := d.PIDs()["kusama-fullnode-uw1-0"]
+	require.NoError(t, other.Stop("kusama-fullnode-uw1-0"))
+	require.Eventually(t, func() bool { return len(d.PIDs()) == 0 }, time.Second, 10*time.Millisecond, "%d still runs", third)
+	require.NoFileExists(t, filepath.Join(dir, "node.pid"))
+}
+
+func TestDriver_Stop_stalePIDFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("start times of processes are only known on Linux")
+	}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// an unrelated process which got the PID of a node that exited long ago
+	unrelated := exec.Command("sleep", "60")
+	require.NoError(t, unrelated.Start())
+	defer func() { _ = unrelated.Process.Kill() }()
+
+	d := newTestDriver(t, writeStub(t, stubNode))
+	dir := filepath.Join(d.opts.Dir, "kusama-fullnode-uw1-0")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	content := fmt.Sprintf("%d 1", unrelated.Process.Pid)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "node.pid"), []byte(content), 0644))
+
+	require.NoError(t, d.Stop("kusama-fullnode-uw1-0"))
+	require.NoError(t, unrelated.Process.Signal(syscall.Signal(0)), "the unrelated process must not be stopped")
+	require.NoFileExists(t, filepath.Join(dir, "node.pid"))
+}
+
+func TestDriver_RunPlaybook_crash(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	d := newTestDriver(t, writeStub(t, "#!/bin/sh\necho 'error: unknown flag' >&2\nexit 1\n"))
+	err := d.RunPlaybook(burnin.PlaybookRun{NodePublicName: "kusama-fullnode-uw1-0", CustomOptions: []string{"--bogus"}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "kusama-fullnode-uw1-0 exited right after starting (exit status 1)")
+
+	content, err := ioutil.ReadFile(filepath.Join(d.opts.Dir, "kusama-fullnode-uw1-0", "node.log"))
+	require.NoError(t, err)
+	require.Equal(t, "error: unknown flag\n", string(content))
+}
+
+func TestDriver_RunPlaybook_download(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(stubNode))
+	}))
+	defer server.Close()
+
+	binary, err := url.Parse(server.URL + "/polkadot")
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(stubNode))
+
+	d := newTestDriver(t, "")
+	run := burnin.PlaybookRun{NodePublicName: "kusama-fullnode-uw1-0", NodeBinary: binary, BinaryChecksum: "00"}
+	err = d.RunPlaybook(run)
+	require.EqualError(t, err, "checksum mismatch of "+binary.String()+": expected 00, got "+hex.EncodeToString(sum[:]))
+	require.Empty(t, d.PIDs())
+
+	run.BinaryChecksum = hex.EncodeToString(sum[:])
+	require.NoError(t, d.RunPlaybook(run))
+	require.FileExists(t, filepath.Join(d.opts.Dir, "kusama-fullnode-uw1-0", "polkadot-"+run.BinaryChecksum))
+	require.Len(t, d.PIDs(), 1)
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.example.com/burn-in-tests/backend/internal/job"
+)
+
+// syncTimeout is how long the homeserver holds back a /sync response when there are no new events. It has to be
+// shorter than the timeout of the HTTP client.
+const syncTimeout = 5 * time.Second
+
+// CommandHandler returns the reply to a message, or false if the message isn't meant for the bot.
+type CommandHandler func(sender, body string) (string, bool)
+
+type roomEvent struct {
+	Type    string `json:"type"`
+	EventID string `json:"event_id"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType   string    `json:"msgtype"`
+		Body      string    `json:"body"`
+		RelatesTo *relation `json:"m.relates_to"`
+	} `json:"content"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []roomEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// Listen follows the room and passes every new text message to handle. Replies are sent as notices in reply to the
+// message. Messages sent before Listen was called are ignored. Failed syncs are retried with a growing delay, so Listen
+// only returns if it can't get started.
+func (c *Client) Listen(handle CommandHandler) error {
+	me, err := c.whoami()
+	if err != nil {
+		return err
+	}
+
+	// The initial sync returns the history of the room, which has been dealt with already.
+	initial, err := c.sync("", 0)
+	if err != nil {
+		return err
+	}
+	since := initial.NextBatch
+	log.Printf("listening to room %s as %s\n", c.roomID, me)
+
+	delay := time.Second
+	for {
+		next, err := c.listenOnce(me, since, handle)
+		if err != nil {
+			log.Printf("matrix sync failed, retrying in %v: %v\n", delay, err)
+			time.Sleep(delay)
+			if delay < time.Minute {
+				delay *= 2
+			}
+			continue
+		}
+
+		delay = time.Second
+		since = next
+	}
+}
+
+// listenOnce handles the messages since the given sync token and returns the token for the next call.
+func (c *Client) listenOnce(me, since string, handle CommandHandler) (string, error) {
+	response, err := c.sync(since, syncTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	for _, event := range response.Rooms.Join[c.roomID].Timeline.Events {
+		if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Sender == me {
+			continue
+		}
+		// Edits repeat the whole message, running the command again is never what the sender wants.
+		if event.Content.RelatesTo != nil && event.Content.RelatesTo.RelType == "m.replace" {
+			continue
+		}
+
+		reply, ok := handle(event.Sender, event.Content.Body)
+		if !ok {
+			continue
+		}
+
+		if err := c.sendNotice(event.EventID, event.Sender, reply); err != nil {
+			log.Printf("replying to %s failed: %v\n", event.EventID, err)
+		}
+	}
+
+	return response.NextBatch, nil
+}
+
+func (c *Client) sync(since string, timeout time.Duration) (syncResponse, error) {
+	var response syncResponse
+
+	filter, err := json.Marshal(map[string]interface{}{
+		"room": map[string]interface{}{
+			"rooms":    []string{c.roomID},
+			"timeline": map[string]interface{}{"types": []string{"m.room.message"}},
+		},
+		"presence":     map[string]interface{}{"types": []string{}},
+		"account_data": map[string]interface{}{"types": []string{}},
+	})
+	if err != nil {
+		return response, err
+	}
+
+	u, err := job.AddPathsToURL(c.homeserverURL, "/_matrix/client/r0/sync")
+	if err != nil {
+		return response, err
+	}
+
+	q := u.Query()
+	q.Set("filter", string(filter))
+	q.Set("timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+	if since != "" {
+		q.Set("since", since)
+	}
+	u.RawQuery = q.Encode()
+
+	_, err = c.callURL(http.MethodGet, u, nil, &response)
+	return response, err
+}
+
+func (c *Client) sendNotice(replyTo, sender, text string) error {
+	_, err := c.sendMessage(c.roomID, messageContent{
+		MsgType:   "m.notice",
+		Body:      text,
+		Mentions:  &mentions{UserIDs: []string{sender}},
+		RelatesTo: &relation{InReplyTo: &inReplyTo{EventID: replyTo}},
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Client_listenOnce(t *testing.T) {
+	var replies []messageContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+
+		switch {
+		case r.URL.Path == "/_matrix/client/r0/sync":
+			require.Equal(t, "s1", r.URL.Query().Get("since"))
+			require.Equal(t, "5000", r.URL.Query().Get("timeout"))
+			require.Contains(t, r.URL.Query().Get("filter"), `"rooms":["!room:matrix.example.com"]`)
+			_, _ = fmt.Fprint(w, `{"next_batch": "s2", "rooms": {"join": {"!room:matrix.example.com": {"timeline": {"events": [
+				{"type": "m.room.message", "event_id": "$1", "sender": "@alice:matrix.example.com", "content": {"msgtype": "m.text", "body": "!burnin list"}},
+				{"type": "m.room.message", "event_id": "$2", "sender": "@alice:matrix.example.com", "content": {"msgtype": "m.text", "body": "hello"}},
+				{"type": "m.room.message", "event_id": "$3", "sender": "@burnin:matrix.example.com", "content": {"msgtype": "m.text", "body": "!burnin list"}},
+				{"type": "m.room.message", "event_id": "$4", "sender": "@alice:matrix.example.com", "content": {"msgtype": "m.text", "body": "* !burnin list", "m.relates_to": {"rel_type": "m.replace", "event_id": "$1"}}}
+			]}}}}}`)
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/r0/rooms/!room:matrix.example.com/send/m.room.message/"):
+			require.Equal(t, http.MethodPut, r.Method)
+			var content messageContent
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+			replies = append(replies, content)
+			_, _ = fmt.Fprintf(w, `{"event_id": "$reply-%d"}`, len(replies))
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	homeserverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client := NewClient(homeserverURL, "!room:matrix.example.com", "s3cr3t", nil, nil, Templates{})
+
+	var handled []string
+	next, err := client.listenOnce("@burnin:matrix.example.com", "s1", func(sender, body string) (string, bool) {
+		handled = append(handled, body)
+		return "no burn-ins", body == "!burnin list"
+	})
+	require.NoError(t, err)
+	require.Equal(t, "s2", next)
+
+	require.Equal(t, []string{"!burnin list", "hello"}, handled, "own messages and edits should be ignored")
+	require.Len(t, replies, 1)
+	require.Equal(t, "m.notice", replies[0].MsgType)
+	require.Equal(t, "no burn-ins", replies[0].Body)
+	require.Equal(t, &relation{InReplyTo: &inReplyTo{EventID: "$1"}}, replies[0].RelatesTo)
+	require.Equal(t, &mentions{UserIDs: []string{"@alice:matrix.example.com"}}, replies[0].Mentions)
+}
@@ -17,12 +17,17 @@ package matrix
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -35,7 +40,13 @@ type Client struct {
 	roomID        string
 	accessToken   string
 	ciJobURL      *url.URL
+	identities    Identities
+	templates     Templates
 	httpClient    *http.Client
+
+	userID string              // of the access token, see whoami
+	sent   int                 // messages sent so far, part of the transaction IDs
+	sleep  func(time.Duration) // between retries, replaced in tests
 }
 
 func NewClient(
@@ -43,15 +54,24 @@ func NewClient(
 	roomID string,
 	accessToken string,
 	ciJobURL *url.URL,
+	identities Identities,
+	templates Templates,
 ) *Client {
+	if templates.byName == nil {
+		templates = DefaultTemplates()
+	}
+
 	return &Client{
 		homeserverURL: homeserverURL,
 		roomID:        roomID,
 		accessToken:   accessToken,
 		ciJobURL:      ciJobURL,
+		identities:    identities,
+		templates:     templates,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		sleep: time.Sleep,
 	}
 }
 
@@ -76,102 +96,586 @@ func (c *Client) Authenticate() error {
 	return errorIfNot(http.StatusOK, request, nil, response, true)
 }
 
-func (c *Client) SendRequestNotification(request burnin.Request) error {
-	vars := tmplVars{
+// SendRequestNotification returns the event ID of the message, which starts the thread of the burn-in.
+func (c *Client) SendRequestNotification(request burnin.Request) (string, error) {
+	vars := c.requestVars(request)
+	return c.sendHTMLMessage(c.roomID, c.templates.lookup("request"), vars, "")
+}
+
+func (c *Client) SendDeploymentNotification(deployment burnin.Deployment) error {
+	return c.sendThreadMessage(c.templates.lookup("deployment"), c.deploymentVars(deployment), "deployed")
+}
+
+func (c *Client) SendUpdateNotification(deployment burnin.Deployment) error {
+	return c.sendThreadMessage(c.templates.lookup("update"), c.deploymentVars(deployment), "updated")
+}
+
+func (c *Client) SendCleanupNotification(deployment burnin.Deployment) error {
+	return c.sendThreadMessage(c.templates.lookup("cleanup"), c.deploymentVars(deployment), "removed")
+}
+
+func (c *Client) SendRollbackNotification(deployment burnin.Deployment) error {
+	return c.sendThreadMessage(c.templates.lookup("rollback"), c.deploymentVars(deployment), "rolled back")
+}
+
+func (c *Client) SendErrorNotification(err error) error {
+	vars := c.errorVars(err)
+	errorTmpl := c.templates.lookup("error")
+
+	if err := c.sendThreadMessage(errorTmpl, vars, "failed"); err != nil {
+		return err
+	}
+
+	if vars.Mention != "" {
+		if err := c.sendDirectMessage(vars.Mention, errorTmpl, vars); err != nil {
+			// The failure has been posted to the room already.
+			log.Printf("sending direct message to %s failed: %v\n", vars.Mention, err)
+		}
+	}
+
+	return nil
+}
+
+// SendDigestNotification posts the digest to the room. It mentions nobody, as it is sent on a schedule.
+func (c *Client) SendDigestNotification(digest burnin.Digest) error {
+	buf := new(bytes.Buffer)
+	if err := c.templates.lookup("digest").Execute(buf, c.digestVars(digest)); err != nil {
+		return err
+	}
+
+	content := htmlMessage(buf.String())
+	content.Mentions = &mentions{}
+	_, err := c.sendMessage(c.roomID, content)
+	return err
+}
+
+// Preview renders a notification about the deployment as HTML and plain text without sending it. The "error"
+// notification shows a sample error, the "rollback" one a sample reason unless the deployment has been rolled back
+// and the "digest" one a digest with just this deployment.
+func (c *Client) Preview(name string, deployment burnin.Deployment) (string, string, error) {
+	var vars interface{}
+	switch name {
+	case "request":
+		vars = c.requestVars(burnin.Request{
+			PullRequest:     deployment.PullRequest,
+			CommitSHA:       deployment.CommitSHA,
+			CustomBinary:    &deployment.CustomBinary,
+			BinaryChecksum:  deployment.BinaryChecksum,
+			CustomOptions:   deployment.CustomOptions,
+			RequestedBy:     deployment.RequestedBy,
+			SyncFromScratch: deployment.SyncFromScratch,
+			Nodes:           burnin.NodesPerNetworkMap{deployment.Network: {deployment.NodeType: 1}},
+		})
+	case "deployment", "update", "cleanup":
+		vars = c.deploymentVars(deployment)
+	case "rollback":
+		if deployment.RollbackReason == "" {
+			deployment.RolledBackFrom = deployment.CustomBinary
+			deployment.RollbackReason = "sample reason"
+		}
+		vars = c.deploymentVars(deployment)
+	case "status":
+		v := c.deploymentVars(deployment)
+		v.Status = "deployed"
+		v.Nodes = []nodeStatus{{
+			Network:  deployment.Network,
+			NodeType: string(deployment.NodeType),
+			Host:     deployment.DeployedOn,
+			Status:   v.Status,
+			JobURL:   v.JobURL,
+		}}
+		vars = v
+	case "error":
+		vars = c.errorVars(&burnin.JobError{Deployment: deployment, Err: errors.New("sample error")})
+	case "digest":
+		vars = c.digestVars(burnin.Digest{
+			CreatedAt: time.Now().UTC(),
+			BurnIns: []burnin.BurnIn{{
+				RequestID:   "1610000000",
+				PullRequest: deployment.PullRequest,
+				RequestedBy: deployment.RequestedBy,
+				DeployedAt:  deployment.DeployedAt,
+				UpdatedAt:   deployment.UpdatedAt,
+				Deployments: []burnin.Deployment{deployment},
+			}},
+		})
+	default:
+		return "", "", fmt.Errorf("unknown notification '%s' (must be one of %s)", name, strings.Join(templateNames, ", "))
+	}
+
+	buf := new(bytes.Buffer)
+	if err := c.templates.lookup(name).Execute(buf, vars); err != nil {
+		return "", "", err
+	}
+	return buf.String(), plainText(buf.String()), nil
+}
+
+func (c *Client) jobURL() template.URL {
+	if c.ciJobURL == nil {
+		return ""
+	}
+	return template.URL(c.ciJobURL.String())
+}
+
+func (c *Client) requestVars(request burnin.Request) tmplVars {
+	return tmplVars{
 		Request:     request,
-		JobURL:      template.URL(c.ciJobURL.String()),
+		Mention:     c.identities.Resolve(request.RequestedBy),
 		PullRequest: formatPullRequest(request.PullRequest),
+		JobURL:      c.jobURL(),
+		OverviewURL: template.URL(c.templates.OverviewURL),
 	}
-
-	return c.sendHTMLMessage(requestTmpl, vars)
 }
 
-func (c *Client) SendDeploymentNotification(deployment burnin.Deployment) error {
-	vars := tmplVars{
+func (c *Client) deploymentVars(deployment burnin.Deployment) tmplVars {
+	return tmplVars{
 		Deployment:   deployment,
-		JobURL:       template.URL(c.ciJobURL.String()),
+		Mention:      c.identities.Resolve(deployment.RequestedBy),
 		PullRequest:  formatPullRequest(deployment.PullRequest),
+		JobURL:       c.jobURL(),
+		OverviewURL:  template.URL(c.templates.OverviewURL),
+		CommitURL:    buildCommitURL(deployment.CommitSHA, deployment.PullRequest),
 		DashboardURL: template.URL(deployment.Dashboards["substrate_networking"]),
 	}
-
-	vars.CommitURL = buildCommitURL(deployment.CommitSHA, deployment.PullRequest)
-	return c.sendHTMLMessage(deployTmpl, vars)
 }
 
-func (c *Client) SendUpdateNotification(deployment burnin.Deployment) error {
+// errorVars only refer to a deployment if the error is a burnin.JobError.
+func (c *Client) errorVars(err error) tmplVars {
 	vars := tmplVars{
-		Deployment:   deployment,
-		JobURL:       template.URL(c.ciJobURL.String()),
-		PullRequest:  formatPullRequest(deployment.PullRequest),
-		DashboardURL: template.URL(deployment.Dashboards["substrate_networking"]),
+		Error:       err,
+		JobURL:      c.jobURL(),
+		OverviewURL: template.URL(c.templates.OverviewURL),
 	}
 
-	vars.CommitURL = buildCommitURL(deployment.CommitSHA, deployment.PullRequest)
+	var firing *burnin.FiringAlertsError
+	if errors.As(err, &firing) {
+		vars.FiringAlerts = firing.Alerts
+	}
 
-	return c.sendHTMLMessage(updateTmpl, vars)
-}
+	var playbookErr *burnin.PlaybookError
+	if errors.As(err, &playbookErr) {
+		vars.FailedTasks = playbookErr.Result.Failures()
+	}
 
-func (c *Client) SendCleanupNotification(deployment burnin.Deployment) error {
-	vars := tmplVars{
-		Deployment:  deployment,
-		JobURL:      template.URL(c.ciJobURL.String()),
-		PullRequest: formatPullRequest(deployment.PullRequest),
+	var jobErr *burnin.JobError
+	if errors.As(err, &jobErr) {
+		vars.Deployment = jobErr.Deployment
+		vars.PullRequest = formatPullRequest(jobErr.Deployment.PullRequest)
+		vars.Mention = c.identities.Resolve(jobErr.Deployment.RequestedBy)
 	}
 
-	return c.sendHTMLMessage(cleanupTmpl, vars)
+	return vars
 }
 
-func (c *Client) SendErrorNotification(err error) error {
-	vars := tmplVars{
-		Error:  err,
-		JobURL: template.URL(c.ciJobURL.String()),
+func (c *Client) digestVars(digest burnin.Digest) digestVars {
+	return digestVars{
+		Digest:      digest,
+		JobURL:      c.jobURL(),
+		OverviewURL: template.URL(c.templates.OverviewURL),
 	}
-
-	return c.sendHTMLMessage(errorTmpl, vars)
 }
 
-func (c *Client) sendHTMLMessage(tmpl *template.Template, vars tmplVars) error {
-	formattedBody := new(bytes.Buffer)
-	if err := tmpl.Execute(formattedBody, vars); err != nil {
+// sendThreadMessage posts the message into the thread of the burn-in and updates the status in the message that
+// started the thread. Deployments without a thread (e.g. from before threads were introduced) are posted to the room.
+func (c *Client) sendThreadMessage(tmpl *template.Template, vars tmplVars, status string) error {
+	thread := vars.Deployment.MatrixThread
+	if _, err := c.sendHTMLMessage(c.roomID, tmpl, vars, thread); err != nil {
 		return err
 	}
 
-	u, err := job.AddPathsToURL(c.homeserverURL, "/_matrix/client/r0/rooms/", c.roomID, "/send/m.room.message")
+	if thread == "" {
+		return nil
+	}
+
+	vars.Status = status
+	vars.Nodes = c.updateNodeStatuses(thread, nodeStatus{
+		Network:  vars.Deployment.Network,
+		NodeType: string(vars.Deployment.NodeType),
+		Host:     vars.Deployment.DeployedOn,
+		Status:   status,
+		JobURL:   vars.JobURL,
+	})
+	if err := c.editMessage(thread, c.templates.lookup("status"), vars); err != nil {
+		// The notification itself has been sent, an outdated status is not worth failing the job for.
+		log.Printf("updating status of matrix thread %s failed: %v\n", thread, err)
+	}
+
+	return nil
+}
+
+// nodeStatusesType is the type of the room account data which keeps the status of each node of the burn-ins, so that
+// the message starting the thread of a request lists all its nodes, which are processed by separate CI jobs.
+const nodeStatusesType = "io.parity.burnin.node_statuses"
+
+// nodeStatuses are the statuses of the nodes by key (see nodeStatus.key) and thread.
+type nodeStatuses struct {
+	Threads map[string]map[string]nodeStatus `json:"threads"`
+}
+
+// nodeStatus is listed in the message starting the thread of a burn-in.
+type nodeStatus struct {
+	Network  string       `json:"network"`
+	NodeType string       `json:"node_type"`
+	Host     string       `json:"host,omitempty"` // empty if the node has not been deployed (yet)
+	Status   string       `json:"status"`
+	JobURL   template.URL `json:"job_url"`
+}
+
+func (s nodeStatus) key() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", s.Network, s.NodeType, s.Host))
+}
+
+// updateNodeStatuses stores the status of the node and returns the statuses of all nodes of the thread. A node which
+// has been deployed replaces its status from before. Threads are forgotten once all their nodes are removed. If the
+// statuses cannot be stored, only the status of the node is returned.
+func (c *Client) updateNodeStatuses(thread string, node nodeStatus) []nodeStatus {
+	statuses, err := c.storeNodeStatus(thread, node)
 	if err != nil {
-		return err
+		log.Printf("updating node statuses of matrix thread %s failed: %v\n", thread, err)
+		return []nodeStatus{node}
+	}
+	return statuses
+}
+
+func (c *Client) storeNodeStatus(thread string, node nodeStatus) ([]nodeStatus, error) {
+	me, err := c.whoami()
+	if err != nil {
+		return nil, err
+	}
+
+	var stored nodeStatuses
+	paths := []string{"/_matrix/client/r0/user/", me, "/rooms/", c.roomID, "/account_data/", nodeStatusesType}
+	status, err := c.call(http.MethodGet, nil, &stored, paths...)
+	if err != nil && status != http.StatusNotFound {
+		return nil, err
+	}
+	if stored.Threads == nil {
+		stored.Threads = make(map[string]map[string]nodeStatus)
+	}
+
+	nodes := stored.Threads[thread]
+	if nodes == nil {
+		nodes = make(map[string]nodeStatus)
 	}
+	if node.Host != "" {
+		delete(nodes, nodeStatus{Network: node.Network, NodeType: node.NodeType}.key())
+	}
+	nodes[node.key()] = node
+
+	list := make([]nodeStatus, 0, len(nodes))
+	removed := true
+	for _, n := range nodes {
+		list = append(list, n)
+		removed = removed && n.Status == "removed"
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+
+	if removed {
+		delete(stored.Threads, thread)
+	} else {
+		stored.Threads[thread] = nodes
+	}
+	if _, err := c.call(http.MethodPut, stored, nil, paths...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+type messageContent struct {
+	MsgType       string          `json:"msgtype"`
+	Format        string          `json:"format,omitempty"`
+	Body          string          `json:"body"`
+	FormattedBody string          `json:"formatted_body,omitempty"`
+	Mentions      *mentions       `json:"m.mentions,omitempty"`
+	RelatesTo     *relation       `json:"m.relates_to,omitempty"`
+	NewContent    *messageContent `json:"m.new_content,omitempty"`
+}
+
+type mentions struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+}
 
-	payload := struct {
-		MsgType       string `json:"msgtype"`
-		Format        string `json:"format"`
-		Body          string `json:"body"`
-		FormattedBody string `json:"formatted_body"`
-	}{
+func mentionsOf(vars tmplVars) *mentions {
+	if vars.Mention == "" {
+		return &mentions{}
+	}
+	return &mentions{UserIDs: []string{vars.Mention}}
+}
+
+type relation struct {
+	RelType       string     `json:"rel_type,omitempty"`
+	EventID       string     `json:"event_id,omitempty"`
+	IsFallingBack bool       `json:"is_falling_back,omitempty"`
+	InReplyTo     *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// htmlMessage returns the content of a message with the given HTML and a plain text version of it for clients which
+// don't render HTML.
+func htmlMessage(formattedBody string) messageContent {
+	return messageContent{
 		MsgType:       "m.text",
 		Format:        "org.matrix.custom.html",
-		Body:          "",
-		FormattedBody: string(formattedBody.Bytes()),
+		Body:          plainText(formattedBody),
+		FormattedBody: formattedBody,
+	}
+}
+
+func render(tmpl *template.Template, vars tmplVars) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, vars); err != nil {
+		return "", err
 	}
+	return buf.String(), nil
+}
 
-	buf, err := json.Marshal(payload)
+// sendHTMLMessage sends the message to the thread started by the given event, or to the room if thread is empty. The
+// thread falls back to a reply for clients without thread support.
+func (c *Client) sendHTMLMessage(roomID string, tmpl *template.Template, vars tmplVars, thread string) (string, error) {
+	formattedBody, err := render(tmpl, vars)
+	if err != nil {
+		return "", err
+	}
+
+	content := htmlMessage(formattedBody)
+	content.Mentions = mentionsOf(vars)
+
+	if thread != "" {
+		content.RelatesTo = &relation{
+			RelType:       "m.thread",
+			EventID:       thread,
+			IsFallingBack: true,
+			InReplyTo:     &inReplyTo{EventID: thread},
+		}
+	}
+
+	return c.sendMessage(roomID, content)
+}
+
+// editMessage replaces the content of the given event.
+func (c *Client) editMessage(eventID string, tmpl *template.Template, vars tmplVars) error {
+	formattedBody, err := render(tmpl, vars)
 	if err != nil {
 		return err
 	}
 
-	request, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewBuffer(buf))
+	newContent := htmlMessage(formattedBody)
+	newContent.Mentions = mentionsOf(vars)
+
+	// Users mentioned in the original message are not mentioned again by the edit.
+	_, err = c.sendMessage(c.roomID, messageContent{
+		MsgType:       newContent.MsgType,
+		Format:        newContent.Format,
+		Body:          "* " + newContent.Body,
+		FormattedBody: "* " + formattedBody,
+		Mentions:      &mentions{},
+		RelatesTo:     &relation{RelType: "m.replace", EventID: eventID},
+		NewContent:    &newContent,
+	})
+	return err
+}
+
+// sendMessage sends the message with a transaction ID derived from the CI job, the number of messages sent before,
+// the room and the content. The homeserver ignores repeated transactions, which makes retries safe.
+func (c *Client) sendMessage(roomID string, content messageContent) (string, error) {
+	buf, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	var sendResponse struct {
+		EventID string `json:"event_id"`
+	}
+	txnID := c.txnID(roomID, buf)
+	_, err = c.call(http.MethodPut, content, &sendResponse, "/_matrix/client/r0/rooms/", roomID, "/send/m.room.message/", txnID)
+	if err != nil {
+		return "", err
+	}
+
+	return sendResponse.EventID, nil
+}
+
+func (c *Client) txnID(roomID string, content []byte) string {
+	c.sent++
+
+	h := sha256.New()
+	if c.ciJobURL != nil {
+		h.Write([]byte(c.ciJobURL.String()))
+	}
+	fmt.Fprintf(h, "\x00%d\x00%s\x00", c.sent, roomID)
+	h.Write(content)
+	return "burnin-" + hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// sendDirectMessage sends the message to the direct chat with the user, which is created if there isn't one yet.
+func (c *Client) sendDirectMessage(userID string, tmpl *template.Template, vars tmplVars) error {
+	roomID, err := c.directRoom(userID)
 	if err != nil {
 		return err
 	}
 
+	_, err = c.sendHTMLMessage(roomID, tmpl, vars, "")
+	return err
+}
+
+// directRoom returns the most recent direct chat with the user listed in the m.direct account data, or creates one.
+func (c *Client) directRoom(userID string) (string, error) {
+	me, err := c.whoami()
+	if err != nil {
+		return "", err
+	}
+
+	direct := map[string][]string{}
+	status, err := c.call(http.MethodGet, nil, &direct, "/_matrix/client/r0/user/", me, "/account_data/m.direct")
+	if err != nil && status != http.StatusNotFound {
+		return "", err
+	}
+
+	if rooms := direct[userID]; len(rooms) > 0 {
+		return rooms[len(rooms)-1], nil
+	}
+
+	createRoom := struct {
+		Preset   string   `json:"preset"`
+		IsDirect bool     `json:"is_direct"`
+		Invite   []string `json:"invite"`
+	}{"trusted_private_chat", true, []string{userID}}
+
+	var created struct {
+		RoomID string `json:"room_id"`
+	}
+	if _, err := c.call(http.MethodPost, createRoom, &created, "/_matrix/client/r0/createRoom"); err != nil {
+		return "", err
+	}
+
+	direct[userID] = append(direct[userID], created.RoomID)
+	if _, err := c.call(http.MethodPut, direct, nil, "/_matrix/client/r0/user/", me, "/account_data/m.direct"); err != nil {
+		// The room works anyway, but the next direct message will create another one.
+		log.Printf("storing direct chat %s with %s failed: %v\n", created.RoomID, userID, err)
+	}
+
+	return created.RoomID, nil
+}
+
+// whoami returns the user ID of the access token.
+func (c *Client) whoami() (string, error) {
+	if c.userID != "" {
+		return c.userID, nil
+	}
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if _, err := c.call(http.MethodGet, nil, &whoami, "/_matrix/client/r0/account/whoami"); err != nil {
+		return "", err
+	}
+
+	c.userID = whoami.UserID
+	return c.userID, nil
+}
+
+// call sends the payload (if not nil) as JSON and decodes the response into out (if not nil). The status code is
+// returned together with the error if it isn't 200 OK.
+func (c *Client) call(method string, payload interface{}, out interface{}, paths ...string) (int, error) {
+	u, err := job.AddPathsToURL(c.homeserverURL, paths...)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.callURL(method, u, payload, out)
+}
+
+// callURL retries GET and PUT requests, which are idempotent, on network errors, rate limits and server errors.
+func (c *Client) callURL(method string, u *url.URL, payload interface{}, out interface{}) (int, error) {
+	var (
+		buf []byte
+		err error
+	)
+	if payload != nil {
+		buf, err = json.Marshal(payload)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	retry := method == http.MethodGet || method == http.MethodPut
+
+	for attempt := 1; ; attempt++ {
+		status, retryAfter, err := c.do(method, u, buf, out, attempt)
+		if err == nil || !retry || retryAfter < 0 || attempt == maxAttempts {
+			return status, err
+		}
+
+		log.Printf("matrix request %s %s failed, retrying in %v: %v\n", method, u.Path, retryAfter, err)
+		c.sleep(retryAfter)
+	}
+}
+
+const maxAttempts = 5
+
+// do sends a single request. On errors, it returns how long to wait before trying again, or a negative duration if
+// trying again is pointless.
+func (c *Client) do(method string, u *url.URL, buf []byte, out interface{}, attempt int) (int, time.Duration, error) {
+	request, err := http.NewRequest(method, u.String(), bytes.NewReader(buf))
+	if err != nil {
+		return 0, -1, err
+	}
+
 	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.accessToken))
-	request.Header.Set("Content-Type", "application/json")
+	if buf != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
 
 	response, err := c.httpClient.Do(request)
 	if err != nil {
-		return err
+		return 0, backoff(attempt), err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		if out != nil {
+			if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+				return response.StatusCode, -1, err
+			}
+		}
+		return response.StatusCode, 0, nil
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response.StatusCode, backoff(attempt), err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	err = errorIfNot(http.StatusOK, request, buf, response, false)
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		var limited struct {
+			RetryAfterMS int64 `json:"retry_after_ms"`
+		}
+		if json.Unmarshal(body, &limited) == nil && limited.RetryAfterMS > 0 {
+			retryAfter := time.Duration(limited.RetryAfterMS) * time.Millisecond
+			if retryAfter > maxRetryAfter {
+				retryAfter = maxRetryAfter
+			}
+			return response.StatusCode, retryAfter, err
+		}
+		return response.StatusCode, backoff(attempt), err
+	case response.StatusCode >= http.StatusInternalServerError:
+		return response.StatusCode, backoff(attempt), err
+	default:
+		return response.StatusCode, -1, err
 	}
+}
 
-	return errorIfNot(http.StatusOK, request, buf, response, true)
+const maxRetryAfter = time.Minute
+
+// backoff returns 1s, 2s, 4s, ... for the attempts 1, 2, 3, ...
+func backoff(attempt int) time.Duration {
+	return time.Second << (attempt - 1)
 }
 
 const polkadotRepoURL = "https://github.com/paritytech/polkadot"
@@ -218,45 +722,38 @@ type tmplVars struct {
 	burnin.Request
 	burnin.Deployment
 	Error        error
+	FailedTasks  []burnin.TaskResult // of the playbook, if the error is a burnin.PlaybookError
+	FiringAlerts []burnin.Alert
+	Status       string       // current status of the deployment, shown in the message that started its thread
+	Nodes        []nodeStatus // current status of all nodes of the burn-in, shown in the same message
+	Mention      string       // Matrix user ID of the requester, empty if unknown
 	PullRequest  string
 	JobURL       template.URL
+	OverviewURL  template.URL
 	CommitURL    template.URL
 	DashboardURL template.URL
 }
 
-var (
-	requestTmpl = template.Must(template.New("request").Parse(
-		`<a href="{{.JobURL}}">Processed burn-in request</a> for <a href="{{.Request.PullRequest}}">{{.PullRequest}}</a>
-(requested by {{.Request.RequestedBy}})`))
-
-	deployTmpl = template.Must(template.New("deploy").Parse(
-		`<a href="{{.JobURL}}">Deployed burn-in</a> for <a href="{{.Deployment.PullRequest}}">{{.PullRequest}}</a>
-(requested by {{.Deployment.RequestedBy}}) on {{.Deployment.DeployedOn}}<br />
-<ul>
-<li><a href="https://burnins.example.com/">Burn-in Test Overview</a></li>
-{{if .CommitURL}}<li>Commit SHA: <a href="{{.CommitURL}}"><code>{{.Deployment.CommitSHA}}</code></a></li>{{end}}
-<li><a href="{{.Deployment.CustomBinary}}">Client Binary</a></li>
-<li><a href="{{.Deployment.LogViewer}}">Logs</a></li>
-<li>{{if .DashboardURL}}<a href="{{.DashboardURL}}">Substrate Networking Dashboard</a></li>{{end}}
-</ul>
-`))
-
-	updateTmpl = template.Must(template.New("update").Parse(
-		`<a href="{{.JobURL}}">Updated burn-in</a> for <a href="{{.Deployment.PullRequest}}">{{.PullRequest}}</a>
-(requested by {{.Deployment.RequestedBy}}) on {{.Deployment.DeployedOn}}<br />
-<ul>
-<li><a href="https://burnins.example.com/">Burn-in Test Overview</a></li>
-{{if .CommitURL}}<li>Commit SHA: <a href="{{.CommitURL}}"><code>{{.Deployment.CommitSHA}}</code></a></li>{{end}}
-<li><a href="{{.Deployment.CustomBinary}}">Client Binary</a></li>
-<li><a href="{{.Deployment.LogViewer}}">Logs</a></li>
-<li>{{if .DashboardURL}}<a href="{{.DashboardURL}}">Substrate Networking Dashboard</a></li>{{end}}
-</ul>
-`))
-
-	cleanupTmpl = template.Must(template.New("cleanup").Parse(
-		`<a href="{{.JobURL}}">Removed burn-in</a> for <a href="{{.Deployment.PullRequest}}">{{.PullRequest}}</a>
-(requested by {{.Deployment.RequestedBy}}) from {{.Deployment.DeployedOn}}`))
-
-	errorTmpl = template.Must(template.New("error").Parse(
-		`<a href="{{.JobURL}}">Burn-in CI job failed</a> with the following error:<br /><pre>{{.Error}}</pre>`))
-)
+// digestVars are available to the "digest" template, next to the fields of burnin.Digest.
+type digestVars struct {
+	burnin.Digest
+	JobURL      template.URL
+	OverviewURL template.URL
+}
+
+// Requester is a pill linking to the requester if the Matrix user ID is known, the requested_by text otherwise.
+func (v tmplVars) Requester() template.HTML {
+	if v.Mention != "" {
+		return template.HTML(fmt.Sprintf(
+			`<a href="https://matrix.to/#/%s">%s</a>`,
+			template.HTMLEscapeString(url.PathEscape(v.Mention)),
+			template.HTMLEscapeString(v.Mention),
+		))
+	}
+
+	requestedBy := v.Deployment.RequestedBy
+	if requestedBy == "" {
+		requestedBy = v.Request.RequestedBy
+	}
+	return template.HTML(template.HTMLEscapeString(requestedBy))
+}
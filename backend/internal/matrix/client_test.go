@@ -17,7 +17,13 @@ package matrix
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -37,7 +43,7 @@ func Test_requestTmpl(t *testing.T) {
 	}
 
 	buf := new(bytes.Buffer)
-	err := requestTmpl.Execute(buf, vars)
+	err := defaults.lookup("request").Execute(buf, vars)
 
 	require.Nil(t, err)
 	rendered := buf.String()
@@ -68,7 +74,7 @@ func Test_deployTmpl(t *testing.T) {
 	vars.DashboardURL = template.URL(vars.Deployment.Dashboards["substrate_networking"])
 
 	buf := new(bytes.Buffer)
-	err := deployTmpl.Execute(buf, vars)
+	err := defaults.lookup("deployment").Execute(buf, vars)
 
 	require.Nil(t, err)
 	rendered := buf.String()
@@ -111,7 +117,7 @@ func Test_updateTmpl(t *testing.T) {
 	vars.DashboardURL = template.URL(vars.Deployment.Dashboards["substrate_networking"])
 
 	buf := new(bytes.Buffer)
-	err := updateTmpl.Execute(buf, vars)
+	err := defaults.lookup("update").Execute(buf, vars)
 
 	require.Nil(t, err)
 	rendered := buf.String()
@@ -128,6 +134,49 @@ func Test_updateTmpl(t *testing.T) {
 		rendered,
 		"http://grafana.example.com/d/vKVuiD9Zk/substrate-networking?orgId=1&amp;refresh=1m&amp;var-nodename=kusama-unit-test-hostname-int.example.com:9615",
 	)
+	require.NotContains(t, rendered, "Health check")
+
+	vars.Deployment.Health = &burnin.HealthCheck{Version: "0.8.28-0fb42a9", Peers: 1, FirstBlock: 10, LastBlock: 10, Problems: []string{
+		"best block not advancing (#10)",
+	}}
+	buf.Reset()
+	require.NoError(t, defaults.lookup("update").Execute(buf, vars))
+	require.Contains(
+		t,
+		buf.String(),
+		"<li>Health check: <strong>unhealthy (best block not advancing (#10)): version 0.8.28-0fb42a9, 1 peers, "+
+			"best block #10 to #10</strong></li>",
+	)
+}
+
+func Test_rollbackTmpl(t *testing.T) {
+	vars := tmplVars{
+		Deployment: burnin.Deployment{
+			PullRequest:    "https://github.com/paritytech/polkadot/pull/2398",
+			CommitSHA:      "0fb42a943e216914ee7181b978c86786edbd07ba",
+			CustomBinary:   "https://gitlab.example.com/parity/polkadot/-/jobs/805835/artifacts/raw/artifacts/polkadot",
+			RequestedBy:    "haiko@example.com",
+			DeployedOn:     "kusama-unit-test-hostname",
+			RolledBackFrom: "https://gitlab.example.com/parity/polkadot/-/jobs/806001/artifacts/raw/artifacts/polkadot",
+			RollbackReason: "playbook kusama-nodes.yml failed: task 'Restart node' failed on kusama-unit-test-hostname",
+		},
+		PullRequest: formatPullRequest("https://github.com/paritytech/polkadot/pull/2398"),
+		JobURL:      template.URL("https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/"),
+	}
+	vars.CommitURL = buildCommitURL(vars.Deployment.CommitSHA, vars.Deployment.PullRequest)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, defaults.lookup("rollback").Execute(buf, vars))
+	rendered := buf.String()
+
+	require.Contains(t, rendered, "Rolled back burn-in</a> for")
+	require.Contains(
+		t,
+		rendered,
+		"<strong>Reason:</strong> playbook kusama-nodes.yml failed: task &#39;Restart node&#39; failed on kusama-unit-test-hostname",
+	)
+	require.Contains(t, rendered, `<a href="https://gitlab.example.com/parity/polkadot/-/jobs/806001/artifacts/raw/artifacts/polkadot">`)
+	require.Contains(t, rendered, "<code>0fb42a943e216914ee7181b978c86786edbd07ba</code>")
 }
 
 func Test_cleanupTmpl(t *testing.T) {
@@ -154,7 +203,7 @@ func Test_cleanupTmpl(t *testing.T) {
 	vars.DashboardURL = template.URL(vars.Deployment.Dashboards["substrate_networking"])
 
 	buf := new(bytes.Buffer)
-	err := cleanupTmpl.Execute(buf, vars)
+	err := defaults.lookup("cleanup").Execute(buf, vars)
 
 	require.Nil(t, err)
 	rendered := buf.String()
@@ -167,3 +216,345 @@ func Test_cleanupTmpl(t *testing.T) {
 		"https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/",
 	)
 }
+
+func Test_errorTmpl(t *testing.T) {
+	alerts := []burnin.Alert{{
+		Labels:      map[string]string{"alertname": "NodeDown", "severity": "critical"},
+		Annotations: map[string]string{"summary": "Node <kusama-fullnode-uw1-0> is down"},
+	}}
+	vars := tmplVars{
+		Error:        &burnin.FiringAlertsError{Hostname: "kusama-fullnode-uw1-0", Alerts: alerts},
+		FiringAlerts: alerts,
+		JobURL:       template.URL("https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/"),
+	}
+
+	buf := new(bytes.Buffer)
+	err := defaults.lookup("error").Execute(buf, vars)
+
+	require.Nil(t, err)
+	rendered := buf.String()
+	require.Contains(t, rendered, "<pre>1 alert(s) firing on kusama-fullnode-uw1-0: NodeDown</pre>")
+	require.Contains(t, rendered, "<li><code>NodeDown</code>: Node &lt;kusama-fullnode-uw1-0&gt; is down</li>")
+
+	playbookErr := &burnin.PlaybookError{Playbook: "kusama-nodes.yml", Result: burnin.PlaybookResult{Tasks: []burnin.TaskResult{
+		{Task: "Restart node", Host: "kusama-fullnode-uw1-0", Failed: true, Msg: "non-zero return code"},
+		{Task: "Restart node", Host: "kusama-fullnode-uw1-1", Unreachable: true, Msg: "timeout"},
+		{Task: "Restart node", Host: "kusama-fullnode-uw1-2", Changed: true},
+	}}}
+	client := NewClient(nil, "!room:matrix.example.com", "", nil, nil, Templates{})
+	rendered, err = render(defaults.lookup("error"), client.errorVars(fmt.Errorf("playbook kusama-nodes.yml failed: %w", playbookErr)))
+	require.NoError(t, err)
+	require.Contains(t, rendered, "<pre>playbook kusama-nodes.yml failed: task &#39;Restart node&#39; failed on kusama-fullnode-uw1-0: "+
+		"non-zero return code (and 1 more failure(s))</pre>")
+	require.Contains(t, rendered, "<li><code>Restart node</code> on kusama-fullnode-uw1-0: non-zero return code</li>")
+	require.Contains(t, rendered, "<li><code>Restart node</code> on kusama-fullnode-uw1-1 (unreachable): timeout</li>")
+	require.NotContains(t, rendered, "kusama-fullnode-uw1-2")
+}
+
+func Test_digestTmpl(t *testing.T) {
+	deployedAt := time.Date(2021, 1, 12, 16, 0, 0, 0, time.UTC)
+	digest := burnin.Digest{
+		CreatedAt: deployedAt.Add(50 * time.Hour),
+		BurnIns: []burnin.BurnIn{{
+			RequestID:   "1610469388",
+			PullRequest: "https://github.com/paritytech/polkadot/pull/2398",
+			RequestedBy: "haiko@example.com",
+			DeployedAt:  deployedAt,
+			Overdue:     true,
+			Deployments: []burnin.Deployment{
+				{
+					Network:    "kusama",
+					NodeType:   burnin.FullNode,
+					DeployedOn: "kusama-fullnode-uw1-0",
+					Dashboards: map[string]string{"substrate_networking": "https://grafana.example.com/d/networking"},
+				},
+				{Network: "kusama", NodeType: burnin.Sentry},
+			},
+		}},
+		OrphanedHosts: []string{"westend-sentry-uw1-0", "polkadot-fullnode-uw1-0"},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, defaults.lookup("digest").Execute(buf, digest))
+
+	rendered := buf.String()
+	require.Contains(t, rendered, "<b>Active burn-ins</b> (1)")
+	require.Contains(t, rendered, "<td>1610469388 ⚠️ <b>overdue</b></td>")
+	require.Contains(t, rendered, `<td><a href="https://github.com/paritytech/polkadot/pull/2398">polkadot#2398</a></td>`)
+	require.Contains(t, rendered, "<td>kusama fullnode on kusama-fullnode-uw1-0<br />kusama sentry (not deployed yet)</td>")
+	require.Contains(t, rendered, "<td>2d 2h ago</td>\n<td></td>")
+	require.Contains(t, rendered, `<a href="https://grafana.example.com/d/networking">kusama-fullnode-uw1-0 substrate_networking</a>`)
+	require.Contains(t, rendered, "<code>westend-sentry-uw1-0</code>, <code>polkadot-fullnode-uw1-0</code>")
+
+	buf.Reset()
+	require.NoError(t, defaults.lookup("digest").Execute(buf, burnin.Digest{}))
+	require.Equal(t, "<b>Active burn-ins</b> (0)<br />\nNo burn-ins running.", buf.String())
+}
+
+func Test_Client_threads(t *testing.T) {
+	var (
+		sent     []messageContent
+		statuses json.RawMessage
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+
+		const accountData = "/_matrix/client/r0/user/@burnin:matrix.example.com/rooms/!room:matrix.example.com" +
+			"/account_data/io.parity.burnin.node_statuses"
+		switch request := r.Method + " " + r.URL.Path; request {
+		case "GET /_matrix/client/r0/account/whoami":
+			_, _ = fmt.Fprint(w, `{"user_id": "@burnin:matrix.example.com"}`)
+		case "GET " + accountData:
+			if statuses == nil {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = fmt.Fprint(w, `{"errcode": "M_NOT_FOUND"}`)
+				return
+			}
+			_, _ = w.Write(statuses)
+		case "PUT " + accountData:
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&statuses))
+			_, _ = fmt.Fprint(w, `{}`)
+		default:
+			const send = "PUT /_matrix/client/r0/rooms/!room:matrix.example.com/send/m.room.message/burnin-"
+			require.True(t, strings.HasPrefix(request, send), request)
+
+			var content messageContent
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+			sent = append(sent, content)
+			_, _ = fmt.Fprintf(w, `{"event_id": "$event-%d"}`, len(sent))
+		}
+	}))
+	defer server.Close()
+
+	homeserverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	jobURL, err := url.Parse("https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/")
+	require.NoError(t, err)
+	client := NewClient(homeserverURL, "!room:matrix.example.com", "s3cr3t", jobURL, nil, Templates{})
+
+	thread, err := client.SendRequestNotification(burnin.Request{PullRequest: "https://github.com/paritytech/polkadot/pull/2398"})
+	require.NoError(t, err)
+	require.Equal(t, "$event-1", thread)
+	require.Nil(t, sent[0].RelatesTo)
+
+	deployment := burnin.Deployment{
+		PullRequest:  "https://github.com/paritytech/polkadot/pull/2398",
+		RequestedBy:  "haiko@example.com",
+		Network:      "kusama",
+		NodeType:     burnin.FullNode,
+		DeployedOn:   "kusama-fullnode-uw1-0",
+		MatrixThread: thread,
+	}
+	require.NoError(t, client.SendDeploymentNotification(deployment))
+	require.Len(t, sent, 3)
+
+	reply := sent[1]
+	require.Equal(t, &relation{RelType: "m.thread", EventID: thread, IsFallingBack: true, InReplyTo: &inReplyTo{EventID: thread}}, reply.RelatesTo)
+	require.Contains(t, reply.FormattedBody, "Deployed burn-in")
+
+	edit := sent[2]
+	require.Equal(t, &relation{RelType: "m.replace", EventID: thread}, edit.RelatesTo)
+	require.NotNil(t, edit.NewContent)
+	require.Contains(t, edit.NewContent.FormattedBody, "<b>deployed</b> kusama fullnode on kusama-fullnode-uw1-0")
+	require.Equal(t, "* "+edit.NewContent.FormattedBody, edit.FormattedBody)
+
+	// errors of a job processing a deployment end up in its thread
+	err = client.SendErrorNotification(&burnin.JobError{Deployment: deployment, Err: errors.New("playbook failed")})
+	require.NoError(t, err)
+	require.Len(t, sent, 5)
+	require.Equal(t, thread, sent[3].RelatesTo.EventID)
+	require.Contains(t, sent[3].FormattedBody, "playbook failed")
+	require.Contains(t, sent[4].NewContent.FormattedBody, "<b>failed</b>")
+
+	// everything else goes to the room
+	require.NoError(t, client.SendErrorNotification(errors.New("invalid request")))
+	require.Len(t, sent, 6)
+	require.Nil(t, sent[5].RelatesTo)
+
+	// the status lists every node of the request, a node which failed before it was deployed is replaced once it is
+	sentry := deployment
+	sentry.NodeType, sentry.DeployedOn = burnin.Sentry, ""
+	err = client.SendErrorNotification(&burnin.JobError{Deployment: sentry, Err: errors.New("playbook failed")})
+	require.NoError(t, err)
+	require.Len(t, sent, 8)
+	status := sent[7].NewContent.FormattedBody
+	require.Contains(t, status, "<b>failed</b> kusama fullnode on kusama-fullnode-uw1-0")
+	require.Contains(t, status, "<b>failed</b> kusama sentry (")
+
+	sentry.DeployedOn = "kusama-sentry-uw1-0"
+	require.NoError(t, client.SendDeploymentNotification(sentry))
+	require.Len(t, sent, 10)
+	status = sent[9].NewContent.FormattedBody
+	require.Contains(t, status, "<b>failed</b> kusama fullnode on kusama-fullnode-uw1-0")
+	require.Contains(t, status, "<b>deployed</b> kusama sentry on kusama-sentry-uw1-0")
+	require.NotContains(t, status, "kusama sentry (")
+
+	// threads are forgotten once all nodes are removed
+	require.NoError(t, client.SendCleanupNotification(deployment))
+	require.NoError(t, client.SendCleanupNotification(sentry))
+	require.Contains(t, sent[len(sent)-1].NewContent.FormattedBody, "<b>removed</b> kusama sentry on kusama-sentry-uw1-0")
+	require.JSONEq(t, `{"threads": {}}`, string(statuses))
+}
+
+func Test_Client_mentions(t *testing.T) {
+	var (
+		roomMessages   []messageContent
+		directMessages []messageContent
+		createRoom     map[string]interface{}
+		direct         map[string][]string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+
+		request := r.Method + " " + r.URL.Path
+		if strings.HasPrefix(request, "PUT ") && strings.Contains(request, "/send/m.room.message/") {
+			request = request[:strings.LastIndex(request, "/")]
+		}
+
+		switch request {
+		case "PUT /_matrix/client/r0/rooms/!room:matrix.example.com/send/m.room.message":
+			var content messageContent
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+			roomMessages = append(roomMessages, content)
+			_, _ = fmt.Fprintf(w, `{"event_id": "$room-%d"}`, len(roomMessages))
+		case "PUT /_matrix/client/r0/rooms/!dm:matrix.example.com/send/m.room.message":
+			var content messageContent
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+			directMessages = append(directMessages, content)
+			_, _ = fmt.Fprintf(w, `{"event_id": "$dm-%d"}`, len(directMessages))
+		case "GET /_matrix/client/r0/account/whoami":
+			_, _ = fmt.Fprint(w, `{"user_id": "@burnin:matrix.example.com"}`)
+		case "GET /_matrix/client/r0/user/@burnin:matrix.example.com/account_data/m.direct":
+			if direct == nil {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = fmt.Fprint(w, `{"errcode": "M_NOT_FOUND"}`)
+				return
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(direct))
+		case "PUT /_matrix/client/r0/user/@burnin:matrix.example.com/account_data/m.direct":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&direct))
+			_, _ = fmt.Fprint(w, `{}`)
+		case "POST /_matrix/client/r0/createRoom":
+			require.Nil(t, createRoom, "the direct chat should be reused")
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&createRoom))
+			_, _ = fmt.Fprint(w, `{"room_id": "!dm:matrix.example.com"}`)
+		default:
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	homeserverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	jobURL, err := url.Parse("https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/")
+	require.NoError(t, err)
+	identities := Identities{"haiko@example.com": "@haiko:matrix.example.com"}
+	client := NewClient(homeserverURL, "!room:matrix.example.com", "s3cr3t", jobURL, identities, Templates{})
+
+	deployment := burnin.Deployment{
+		PullRequest: "https://github.com/paritytech/polkadot/pull/2398",
+		RequestedBy: "haiko@example.com",
+		Network:     "kusama",
+		NodeType:    burnin.FullNode,
+		DeployedOn:  "kusama-fullnode-uw1-0",
+	}
+	require.NoError(t, client.SendDeploymentNotification(deployment))
+	require.Len(t, roomMessages, 1)
+	require.Equal(t, &mentions{UserIDs: []string{"@haiko:matrix.example.com"}}, roomMessages[0].Mentions)
+	require.Contains(
+		t,
+		roomMessages[0].FormattedBody,
+		`(requested by <a href="https://matrix.to/#/@haiko:matrix.example.com">@haiko:matrix.example.com</a>)`,
+	)
+	require.Empty(t, directMessages)
+
+	// failures are sent to the requester directly, the direct chat is created once
+	for i := 0; i < 2; i++ {
+		err = client.SendErrorNotification(&burnin.JobError{Deployment: deployment, Err: errors.New("playbook failed")})
+		require.NoError(t, err)
+	}
+	require.Len(t, roomMessages, 3)
+	require.Len(t, directMessages, 2)
+	require.Contains(t, directMessages[0].FormattedBody, "playbook failed")
+	require.Equal(t, true, createRoom["is_direct"])
+	require.Equal(t, []interface{}{"@haiko:matrix.example.com"}, createRoom["invite"])
+	require.Equal(t, map[string][]string{"@haiko:matrix.example.com": {"!dm:matrix.example.com"}}, direct)
+
+	// unknown requesters are neither mentioned nor messaged
+	deployment.RequestedBy = "someone@example.com"
+	err = client.SendErrorNotification(&burnin.JobError{Deployment: deployment, Err: errors.New("playbook failed")})
+	require.NoError(t, err)
+	require.Len(t, roomMessages, 4)
+	require.Empty(t, roomMessages[3].Mentions.UserIDs)
+	require.Contains(t, roomMessages[3].FormattedBody, "(requested by someone@example.com)")
+	require.Len(t, directMessages, 2)
+}
+
+func Test_Client_retries(t *testing.T) {
+	var (
+		txnIDs   []string
+		contents []messageContent
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		txnIDs = append(txnIDs, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+
+		var content messageContent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&content))
+		contents = append(contents, content)
+
+		switch len(txnIDs) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = fmt.Fprint(w, `{"errcode": "M_LIMIT_EXCEEDED", "retry_after_ms": 2500}`)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = fmt.Fprint(w, `{"event_id": "$event"}`)
+		}
+	}))
+	defer server.Close()
+
+	homeserverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	jobURL, err := url.Parse("https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/")
+	require.NoError(t, err)
+	client := NewClient(homeserverURL, "!room:matrix.example.com", "s3cr3t", jobURL, nil, Templates{})
+	var slept []time.Duration
+	client.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	eventID, err := client.SendRequestNotification(burnin.Request{
+		PullRequest: "https://github.com/paritytech/polkadot/pull/2398",
+		RequestedBy: "haiko@example.com",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "$event", eventID)
+	require.Equal(t, []time.Duration{2500 * time.Millisecond, 2 * time.Second}, slept)
+	require.Len(t, txnIDs, 3)
+	require.Equal(t, txnIDs[0], txnIDs[1], "retries should reuse the transaction ID")
+	require.Equal(t, txnIDs[0], txnIDs[2], "retries should reuse the transaction ID")
+	require.Equal(
+		t,
+		"Processed burn-in request (https://gitlab.example.com/deployments/burn-in-tests/-/jobs/752482/) "+
+			"for polkadot#2398 (https://github.com/paritytech/polkadot/pull/2398) (requested by haiko@example.com)",
+		contents[2].Body,
+	)
+
+	// another message gets another transaction ID, even with the same content
+	_, err = client.SendRequestNotification(burnin.Request{
+		PullRequest: "https://github.com/paritytech/polkadot/pull/2398",
+		RequestedBy: "haiko@example.com",
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, txnIDs[0], txnIDs[3])
+
+	// client errors are not retried
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txnIDs = append(txnIDs, r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+	})
+	_, err = client.SendRequestNotification(burnin.Request{})
+	require.Error(t, err)
+	require.Len(t, txnIDs, 5)
+	require.Len(t, slept, 2)
+}
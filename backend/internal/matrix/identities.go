@@ -0,0 +1,73 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// Identities maps the free text in requested_by (GitHub handle, email address, ...) to Matrix user IDs, e.g.
+//
+//	mxinden = "@mxinden:matrix.example.com"
+//	"haiko@example.com" = "@haiko:matrix.example.com"
+type Identities map[string]string
+
+// LoadIdentities reads the identity map from a TOML file.
+func LoadIdentities(path string) (Identities, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids map[string]string
+	if err := toml.Unmarshal(content, &ids); err != nil {
+		return nil, fmt.Errorf("parsing identity map %s failed: %w", path, err)
+	}
+
+	identities := make(Identities, len(ids))
+	for name, userID := range ids {
+		if !isUserID(userID) {
+			return nil, fmt.Errorf("identity map %s: '%s' is not a Matrix user ID", path, userID)
+		}
+		identities[strings.ToLower(name)] = userID
+	}
+
+	return identities, nil
+}
+
+// Resolve returns the Matrix user ID of the requester, or an empty string if it is unknown. Matrix user IDs are
+// returned as they are, everything else is looked up case-insensitively with and without a leading "@".
+func (ids Identities) Resolve(requestedBy string) string {
+	name := strings.TrimSpace(requestedBy)
+	if isUserID(name) {
+		return name
+	}
+
+	name = strings.ToLower(name)
+	if userID, ok := ids[name]; ok {
+		return userID
+	}
+	return ids[strings.TrimPrefix(name, "@")]
+}
+
+// isUserID reports whether s looks like "@localpart:server".
+func isUserID(s string) bool {
+	return strings.HasPrefix(s, "@") && strings.Index(s, ":") > 1 && !strings.ContainsAny(s, " \t")
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadIdentities(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "identities.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+mxinden = "@mxinden:matrix.example.com"
+"Haiko@example.com" = "@haiko:matrix.example.com"
+`), 0644))
+
+	ids, err := LoadIdentities(path)
+	require.NoError(t, err)
+
+	require.Equal(t, "@mxinden:matrix.example.com", ids.Resolve("mxinden"))
+	require.Equal(t, "@mxinden:matrix.example.com", ids.Resolve("@mxinden"))
+	require.Equal(t, "@haiko:matrix.example.com", ids.Resolve(" haiko@example.com "))
+	require.Equal(t, "@someone:matrix.example.com", ids.Resolve("@someone:matrix.example.com"))
+	require.Empty(t, ids.Resolve("unknown"))
+	require.Empty(t, Identities(nil).Resolve("mxinden"))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`mxinden = "mxinden"`), 0644))
+	_, err = LoadIdentities(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	preRegexp       = regexp.MustCompile(`(?is)<pre[^>]*>(.*?)</pre>`)
+	linkRegexp      = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	lineBreakRegexp = regexp.MustCompile(`(?i)<br\s*/?>|<(?:ul|ol|table)[^>]*>|</(?:li|tr|ul|ol|table|p|h[1-6])>`)
+	listItemRegexp  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	cellRegexp      = regexp.MustCompile(`(?i)</t[dh]>`)
+	tagRegexp       = regexp.MustCompile(`<[^>]*>`)
+	spaceRegexp     = regexp.MustCompile(`[ \t\n]+`)
+	emptyCellRegexp = regexp.MustCompile(`(?: *\|)+ *$`)
+	blankRegexp     = regexp.MustCompile(`\n(?: *\n)+`)
+)
+
+// plainText turns the HTML of a notification into the plain text body of the message, which clients without HTML
+// support display. Links keep their URL unless it is the same as the text, and mentions are reduced to the user ID.
+func plainText(s string) string {
+	// like browsers, ignore the formatting of the HTML itself, except for line breaks in preformatted text
+	s = preRegexp.ReplaceAllStringFunc(s, func(pre string) string {
+		return "<br>" + strings.ReplaceAll(preRegexp.FindStringSubmatch(pre)[1], "\n", "<br>") + "<br>"
+	})
+	s = spaceRegexp.ReplaceAllString(s, " ")
+	s = linkRegexp.ReplaceAllStringFunc(s, func(link string) string {
+		m := linkRegexp.FindStringSubmatch(link)
+		href, text := html.UnescapeString(m[1]), m[2]
+		plain := html.UnescapeString(tagRegexp.ReplaceAllString(text, ""))
+		if plain == href || strings.HasPrefix(href, "https://matrix.to/#/") {
+			return text
+		}
+		return text + " (" + html.EscapeString(href) + ")"
+	})
+	s = lineBreakRegexp.ReplaceAllString(s, "\n")
+	s = listItemRegexp.ReplaceAllString(s, "- ")
+	s = cellRegexp.ReplaceAllString(s, " | ")
+	s = tagRegexp.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		lines[i] = emptyCellRegexp.ReplaceAllString(line, "")
+	}
+	s = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankRegexp.ReplaceAllString(s, "\n"))
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+func Test_plainText(t *testing.T) {
+	require.Equal(
+		t,
+		"Deployed burn-in (https://ci.example.com/1) for polkadot#2398 (https://github.com/paritytech/polkadot/pull/2398) "+
+			"(requested by @haiko:matrix.example.com)",
+		plainText(`<a href="https://ci.example.com/1">Deployed burn-in</a> for `+
+			`<a href="https://github.com/paritytech/polkadot/pull/2398">polkadot#2398</a>
+(requested by <a href="https://matrix.to/#/@haiko:matrix.example.com">@haiko:matrix.example.com</a>)`),
+	)
+	require.Equal(t, "a & b\n- one\n- two", plainText("a &amp; b<ul>\n<li>one</li>\n<li>two</li>\n</ul>"))
+	require.Equal(t, "see https://example.com/?a=1&b=2", plainText(`see <a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a>`))
+
+	vars := tmplVars{
+		Deployment:  burnin.Deployment{PullRequest: "https://github.com/paritytech/polkadot/pull/2398", RequestedBy: "haiko@example.com"},
+		PullRequest: "polkadot#2398",
+		JobURL:      "https://ci.example.com/1",
+		Error:       errors.New("playbook failed:\nunreachable"),
+	}
+	rendered, err := render(defaults.lookup("error"), vars)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		"Burn-in CI job failed (https://ci.example.com/1) for polkadot#2398 (https://github.com/paritytech/polkadot/pull/2398) "+
+			"(requested by haiko@example.com) with the following error:\nplaybook failed:\nunreachable",
+		plainText(rendered),
+	)
+
+	deployedAt := time.Date(2021, 1, 12, 16, 0, 0, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	require.NoError(t, defaults.lookup("digest").Execute(buf, burnin.Digest{
+		CreatedAt: deployedAt.Add(5 * time.Hour),
+		BurnIns: []burnin.BurnIn{{
+			RequestID:   "1610469388",
+			PullRequest: "https://github.com/paritytech/polkadot/pull/2398",
+			RequestedBy: "haiko@example.com",
+			DeployedAt:  deployedAt,
+			Deployments: []burnin.Deployment{{Network: "kusama", NodeType: burnin.FullNode, DeployedOn: "kusama-fullnode-uw1-0"}},
+		}},
+	}))
+	require.Equal(t, `Active burn-ins (1)
+Request | Pull request | Requested by | Hosts | Deployed | Updated | Dashboards
+1610469388 | polkadot#2398 (https://github.com/paritytech/polkadot/pull/2398) | haiko@example.com | kusama fullnode on kusama-fullnode-uw1-0 | 5h ago`,
+		plainText(buf.String()))
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// templateNames are the notifications, each one is rendered by the template in "<name>.html".
+var templateNames = []string{"request", "deployment", "update", "cleanup", "rollback", "status", "error", "digest"}
+
+const defaultOverviewURL = "https://burnins.example.com/"
+
+// Templates render the HTML of the notifications. The defaults are embedded into the binary.
+type Templates struct {
+	OverviewURL string // link to the burn-in frontend, available to the templates as .OverviewURL
+	byName      map[string]*template.Template
+}
+
+// LoadTemplates reads the templates from dir. Templates missing in dir, or all of them if dir is empty, are the
+// embedded defaults.
+func LoadTemplates(dir, overviewURL string) (Templates, error) {
+	templates := Templates{OverviewURL: overviewURL, byName: make(map[string]*template.Template)}
+
+	for _, name := range templateNames {
+		filename := name + ".html"
+
+		var (
+			text []byte
+			err  error
+		)
+		if dir != "" {
+			text, err = os.ReadFile(filepath.Join(dir, filename))
+		}
+		if dir == "" || os.IsNotExist(err) {
+			text, err = fs.ReadFile(embeddedTemplates, "templates/"+filename)
+		}
+		if err != nil {
+			return Templates{}, err
+		}
+
+		// The final newline of the file is not part of the message.
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(strings.TrimSuffix(string(text), "\n"))
+		if err != nil {
+			return Templates{}, fmt.Errorf("parsing template %s failed: %v", filename, err)
+		}
+		templates.byName[name] = tmpl
+	}
+
+	return templates, nil
+}
+
+// DefaultTemplates returns the embedded templates.
+func DefaultTemplates() Templates {
+	templates, err := LoadTemplates("", defaultOverviewURL)
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
+
+var templateFuncs = template.FuncMap{"formatPullRequest": formatPullRequest}
+
+func (t Templates) lookup(name string) *template.Template {
+	tmpl, ok := t.byName[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown template '%s'", name))
+	}
+	return tmpl
+}
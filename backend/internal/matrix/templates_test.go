@@ -0,0 +1,83 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package matrix
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+var defaults = DefaultTemplates()
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(
+		filepath.Join(dir, "deployment.html"),
+		[]byte("{{.Deployment.Network}} {{.Deployment.BinaryChecksum}} {{.Deployment.PublicFQDN}} "+
+			`{{index .Deployment.Dashboards "grandpa"}} <a href="{{.OverviewURL}}">overview</a>`+"\n"),
+		0644,
+	)
+	require.NoError(t, err)
+
+	templates, err := LoadTemplates(dir, "https://burnins.test/")
+	require.NoError(t, err)
+
+	client := NewClient(nil, "!room:matrix.example.com", "", nil, nil, templates)
+	deployment := burnin.Deployment{
+		PullRequest:    "https://github.com/paritytech/polkadot/pull/2398",
+		RequestedBy:    "haiko@example.com",
+		Network:        "kusama",
+		NodeType:       burnin.FullNode,
+		BinaryChecksum: "9f86d081",
+		PublicFQDN:     "kusama-fullnode-uw1-0.example.com",
+		Dashboards:     map[string]string{"grandpa": "https://grafana.example.com/d/grandpa"},
+	}
+
+	html, plain, err := client.Preview("deployment", deployment)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		`kusama 9f86d081 kusama-fullnode-uw1-0.example.com https://grafana.example.com/d/grandpa `+
+			`<a href="https://burnins.test/">overview</a>`,
+		html,
+	)
+	require.Equal(
+		t,
+		"kusama 9f86d081 kusama-fullnode-uw1-0.example.com https://grafana.example.com/d/grandpa overview (https://burnins.test/)",
+		plain,
+	)
+
+	// templates missing in the directory are the embedded ones
+	html, _, err = client.Preview("cleanup", deployment)
+	require.NoError(t, err)
+	require.Contains(t, html, "Removed burn-in</a> for")
+
+	for _, name := range templateNames {
+		_, _, err := client.Preview(name, deployment)
+		require.NoError(t, err, name)
+	}
+
+	_, _, err = client.Preview("unknown", deployment)
+	require.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "error.html"), []byte("{{if}}"), 0644))
+	_, err = LoadTemplates(dir, "https://burnins.test/")
+	require.Error(t, err)
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// Event is a notification independent of the way it is delivered.
+type Event struct {
+	Name       string             `json:"event"` // "request", "deployment", "update", "cleanup", "rollback", "error" or "digest"
+	Request    *burnin.Request    `json:"request,omitempty"`
+	Deployment *burnin.Deployment `json:"deployment,omitempty"`
+	Digest     *burnin.Digest     `json:"digest,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	JobURL     string             `json:"job_url"`
+}
+
+// PullRequestURL returns the URL of the pull request the event is about.
+func (e Event) PullRequestURL() string {
+	if e.Request != nil {
+		return e.Request.PullRequest
+	}
+	if e.Deployment != nil {
+		return e.Deployment.PullRequest
+	}
+	return ""
+}
+
+// PullRequest returns a short name for the pull request, e.g. "polkadot#2013".
+func (e Event) PullRequest() string {
+	return ShortPullRequest(e.PullRequestURL())
+}
+
+// ShortPullRequest turns the URL of a Polkadot pull request into e.g. "polkadot#2013". Other URLs are returned as they
+// are.
+func ShortPullRequest(pr string) string {
+	if strings.HasPrefix(pr, polkadotRepoURL+"/pull/") {
+		return fmt.Sprintf("polkadot#%s", strings.TrimPrefix(pr, polkadotRepoURL+"/pull/"))
+	}
+	return pr
+}
+
+// RequestedBy returns who requested the burn-in the event is about.
+func (e Event) RequestedBy() string {
+	if e.Request != nil {
+		return e.Request.RequestedBy
+	}
+	if e.Deployment != nil {
+		return e.Deployment.RequestedBy
+	}
+	return ""
+}
+
+const polkadotRepoURL = "https://github.com/paritytech/polkadot"
+
+// Sender delivers events, e.g. to a chat or a mailbox.
+type Sender interface {
+	Send(event Event) error
+}
+
+// Events turns a Sender into a burnin.Notifier.
+type Events struct {
+	Sender Sender
+	JobURL *url.URL // URL of the CI job sending the notifications
+}
+
+func (e Events) event(name string) Event {
+	event := Event{Name: name}
+	if e.JobURL != nil {
+		event.JobURL = e.JobURL.String()
+	}
+	return event
+}
+
+func (e Events) SendRequestNotification(request burnin.Request) (string, error) {
+	event := e.event("request")
+	event.Request = &request
+	return "", e.Sender.Send(event)
+}
+
+func (e Events) SendDeploymentNotification(deployment burnin.Deployment) error {
+	event := e.event("deployment")
+	event.Deployment = &deployment
+	return e.Sender.Send(event)
+}
+
+func (e Events) SendUpdateNotification(deployment burnin.Deployment) error {
+	event := e.event("update")
+	event.Deployment = &deployment
+	return e.Sender.Send(event)
+}
+
+func (e Events) SendCleanupNotification(deployment burnin.Deployment) error {
+	event := e.event("cleanup")
+	event.Deployment = &deployment
+	return e.Sender.Send(event)
+}
+
+func (e Events) SendRollbackNotification(deployment burnin.Deployment) error {
+	event := e.event("rollback")
+	event.Deployment = &deployment
+	return e.Sender.Send(event)
+}
+
+func (e Events) SendErrorNotification(err error) error {
+	event := e.event("error")
+	event.Error = err.Error()
+
+	var jobErr *burnin.JobError
+	if errors.As(err, &jobErr) {
+		event.Deployment = &jobErr.Deployment
+	}
+
+	return e.Sender.Send(event)
+}
+
+func (e Events) SendDigestNotification(digest burnin.Digest) error {
+	event := e.event("digest")
+	event.Digest = &digest
+	return e.Sender.Send(event)
+}
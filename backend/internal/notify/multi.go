@@ -0,0 +1,99 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"strings"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// Multi sends every notification to all of its notifiers. A failing notifier doesn't keep the others from being
+// notified, the errors of all notifiers are returned together.
+type Multi []burnin.Notifier
+
+// SendRequestNotification returns the first non-empty ID returned by the notifiers.
+func (m Multi) SendRequestNotification(request burnin.Request) (string, error) {
+	var (
+		id   string
+		errs Errors
+	)
+
+	for _, n := range m {
+		nid, err := n.SendRequestNotification(request)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if id == "" {
+			id = nid
+		}
+	}
+
+	return id, errs.orNil()
+}
+
+func (m Multi) SendDeploymentNotification(deployment burnin.Deployment) error {
+	return m.each(func(n burnin.Notifier) error { return n.SendDeploymentNotification(deployment) })
+}
+
+func (m Multi) SendUpdateNotification(deployment burnin.Deployment) error {
+	return m.each(func(n burnin.Notifier) error { return n.SendUpdateNotification(deployment) })
+}
+
+func (m Multi) SendCleanupNotification(deployment burnin.Deployment) error {
+	return m.each(func(n burnin.Notifier) error { return n.SendCleanupNotification(deployment) })
+}
+
+func (m Multi) SendRollbackNotification(deployment burnin.Deployment) error {
+	return m.each(func(n burnin.Notifier) error { return n.SendRollbackNotification(deployment) })
+}
+
+func (m Multi) SendErrorNotification(err error) error {
+	return m.each(func(n burnin.Notifier) error { return n.SendErrorNotification(err) })
+}
+
+func (m Multi) SendDigestNotification(digest burnin.Digest) error {
+	return m.each(func(n burnin.Notifier) error { return n.SendDigestNotification(digest) })
+}
+
+func (m Multi) each(send func(burnin.Notifier) error) error {
+	var errs Errors
+	for _, n := range m {
+		if err := send(n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+// Errors are the errors of several notifiers.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e Errors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+type recorder struct {
+	id     string
+	err    error
+	events []string
+}
+
+func (r *recorder) SendRequestNotification(burnin.Request) (string, error) {
+	r.events = append(r.events, "request")
+	return r.id, r.err
+}
+
+func (r *recorder) SendDeploymentNotification(burnin.Deployment) error {
+	r.events = append(r.events, "deployment")
+	return r.err
+}
+
+func (r *recorder) SendUpdateNotification(burnin.Deployment) error {
+	r.events = append(r.events, "update")
+	return r.err
+}
+
+func (r *recorder) SendCleanupNotification(burnin.Deployment) error {
+	r.events = append(r.events, "cleanup")
+	return r.err
+}
+
+func (r *recorder) SendRollbackNotification(burnin.Deployment) error {
+	r.events = append(r.events, "rollback")
+	return r.err
+}
+
+func (r *recorder) SendErrorNotification(error) error {
+	r.events = append(r.events, "error")
+	return r.err
+}
+
+func (r *recorder) SendDigestNotification(burnin.Digest) error {
+	r.events = append(r.events, "digest")
+	return r.err
+}
+
+func TestMulti(t *testing.T) {
+	failing := &recorder{id: "failing", err: errors.New("webhook unavailable")}
+	noThreads := &recorder{}
+	matrix := &recorder{id: "$root"}
+	multi := Multi{failing, noThreads, matrix}
+
+	id, err := multi.SendRequestNotification(burnin.Request{})
+	require.Equal(t, "$root", id)
+	require.EqualError(t, err, "webhook unavailable")
+
+	require.Error(t, multi.SendDeploymentNotification(burnin.Deployment{}))
+	require.Error(t, multi.SendUpdateNotification(burnin.Deployment{}))
+	require.Error(t, multi.SendCleanupNotification(burnin.Deployment{}))
+	require.Error(t, multi.SendErrorNotification(errors.New("boom")))
+	require.Error(t, multi.SendDigestNotification(burnin.Digest{}))
+
+	for _, r := range multi {
+		require.Equal(t, []string{"request", "deployment", "update", "cleanup", "error", "digest"}, r.(*recorder).events)
+	}
+
+	require.NoError(t, Multi{noThreads, matrix}.SendCleanupNotification(burnin.Deployment{}))
+}
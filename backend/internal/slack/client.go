@@ -0,0 +1,136 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+)
+
+// Client posts notifications to a Slack-style incoming webhook (also supported by Mattermost and Rocket.Chat).
+type Client struct {
+	webhookURL *url.URL
+	httpClient *http.Client
+}
+
+func NewClient(webhookURL *url.URL) *Client {
+	return &Client{
+		webhookURL: webhookURL,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (c *Client) Send(event notify.Event) error {
+	tmpl, ok := templates[event.Name]
+	if !ok {
+		return fmt.Errorf("unknown event '%s'", event.Name)
+	}
+
+	text := new(bytes.Buffer)
+	if err := tmpl.Execute(text, event); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text.String()})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, c.webhookURL.String(), bytes.NewBuffer(buf))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	return errorIfNot(http.StatusOK, request, buf, response, true)
+}
+
+func errorIfNot(status int, request *http.Request, payload []byte, response *http.Response, closeResponse bool) error {
+	if closeResponse {
+		defer response.Body.Close()
+	}
+
+	if response.StatusCode == status {
+		return nil
+	}
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	// The webhook URL contains the secret, so only its host is part of the error.
+	return fmt.Errorf(`HTTP request to Slack webhook failed.
+Request: %s %s
+Body: %s
+
+Response: %s
+Body: %s`, request.Method, request.URL.Host, payload, response.Status, string(responseBody))
+}
+
+// escape escapes the characters with a special meaning in Slack's "mrkdwn".
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func parse(name, text string) *template.Template {
+	funcs := template.FuncMap{"escape": escape, "short": notify.ShortPullRequest}
+	return template.Must(template.New(name).Funcs(funcs).Parse(text))
+}
+
+const pullRequest = `<{{.PullRequestURL}}|{{escape .PullRequest}}> (requested by {{escape .RequestedBy}})`
+
+var templates = map[string]*template.Template{
+	"request": parse("request", `Processed burn-in request for `+pullRequest+` - <{{.JobURL}}|CI job>`),
+	"deployment": parse("deployment", `Deployed burn-in for `+pullRequest+` on {{escape .Deployment.DeployedOn}}`+
+		`{{with .Deployment.LogViewer}} - <{{.}}|Logs>{{end}}{{with .Deployment.Health}} - {{escape .Summary}}{{end}}`+
+		` - <{{.JobURL}}|CI job>`),
+	"update": parse("update", `Updated burn-in for `+pullRequest+` on {{escape .Deployment.DeployedOn}}`+
+		`{{with .Deployment.LogViewer}} - <{{.}}|Logs>{{end}}{{with .Deployment.Health}} - {{escape .Summary}}{{end}}`+
+		` - <{{.JobURL}}|CI job>`),
+	"rollback": parse("rollback", `Rolled back burn-in for `+pullRequest+` on {{escape .Deployment.DeployedOn}}`+
+		` to the previous binary: {{escape .Deployment.RollbackReason}}`+
+		`{{with .Deployment.LogViewer}} - <{{.}}|Logs>{{end}} - <{{.JobURL}}|CI job>`),
+	"cleanup": parse("cleanup", `Removed burn-in for `+pullRequest+` from {{escape .Deployment.DeployedOn}}`+
+		` - <{{.JobURL}}|CI job>`),
+	"error": parse("error", `<{{.JobURL}}|Burn-in CI job failed>`+
+		`{{with .Deployment}} for {{escape .Network}} {{.NodeType}}{{with .DeployedOn}} on {{escape .}}{{end}}{{end}}`+
+		"\n```{{escape .Error}}```"),
+	"digest": parse("digest", `*Active burn-ins* ({{len .Digest.BurnIns}})`+
+		`{{range .Digest.BurnIns}}`+"\n"+`• {{if .Overdue}}:warning: *overdue* {{end}}<{{.PullRequest}}|{{escape (short .PullRequest)}}>`+
+		` (requested by {{escape .RequestedBy}}){{with $.Digest.Age .DeployedAt}}, deployed {{.}} ago{{end}}:`+
+		`{{range .Deployments}} {{escape .Network}} {{.NodeType}}{{with .DeployedOn}} on {{escape .}}{{end}};{{end}}`+
+		`{{else}}`+"\n"+`No burn-ins running.{{end}}`+
+		`{{with .Digest.OrphanedHosts}}`+"\n"+`Paused runners without a "run" file:{{range .}} {{escape .}}{{end}}{{end}}`),
+}
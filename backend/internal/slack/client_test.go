@@ -0,0 +1,110 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+)
+
+func TestClient(t *testing.T) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/services/T000/B000/XXXX", r.URL.Path)
+		var payload struct {
+			Text string `json:"text"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		texts = append(texts, payload.Text)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	webhookURL, err := url.Parse(server.URL + "/services/T000/B000/XXXX")
+	require.NoError(t, err)
+	jobURL, err := url.Parse("https://gitlab.example.com/burn-in-tests/deployments/-/jobs/23")
+	require.NoError(t, err)
+	notifier := notify.Events{Sender: NewClient(webhookURL), JobURL: jobURL}
+
+	deployment := burnin.Deployment{
+		PullRequest: "https://github.com/paritytech/polkadot/pull/2013",
+		RequestedBy: "<mxinden>",
+		Network:     "kusama",
+		NodeType:    burnin.FullNode,
+		DeployedOn:  "kusama-fullnode-uw1-0",
+		LogViewer:   "http://grafana.example.com/explore",
+	}
+
+	_, err = notifier.SendRequestNotification(burnin.Request{PullRequest: deployment.PullRequest, RequestedBy: "mxinden"})
+	require.NoError(t, err)
+	require.NoError(t, notifier.SendDeploymentNotification(deployment))
+	require.NoError(t, notifier.SendErrorNotification(&burnin.JobError{Deployment: deployment, Err: errors.New("playbook failed")}))
+
+	require.Equal(t, []string{
+		"Processed burn-in request for <https://github.com/paritytech/polkadot/pull/2013|polkadot#2013> (requested by mxinden) - <https://gitlab.example.com/burn-in-tests/deployments/-/jobs/23|CI job>",
+		"Deployed burn-in for <https://github.com/paritytech/polkadot/pull/2013|polkadot#2013> (requested by &lt;mxinden&gt;) on kusama-fullnode-uw1-0 - <http://grafana.example.com/explore|Logs> - <https://gitlab.example.com/burn-in-tests/deployments/-/jobs/23|CI job>",
+		"<https://gitlab.example.com/burn-in-tests/deployments/-/jobs/23|Burn-in CI job failed> for kusama fullnode on kusama-fullnode-uw1-0\n```playbook failed```",
+	}, texts)
+
+	deployedAt := time.Date(2021, 1, 12, 16, 0, 0, 0, time.UTC)
+	deployment.DeployedAt = deployedAt
+	require.NoError(t, notifier.SendDigestNotification(burnin.Digest{
+		CreatedAt: deployedAt.Add(8*24*time.Hour + 3*time.Hour),
+		BurnIns: []burnin.BurnIn{{
+			RequestID:   "1610469388",
+			PullRequest: deployment.PullRequest,
+			RequestedBy: "mxinden",
+			DeployedAt:  deployedAt,
+			Overdue:     true,
+			Deployments: []burnin.Deployment{deployment},
+		}},
+		OrphanedHosts: []string{"westend-sentry-uw1-0"},
+	}))
+	require.Equal(
+		t,
+		"*Active burn-ins* (1)\n"+
+			"• :warning: *overdue* <https://github.com/paritytech/polkadot/pull/2013|polkadot#2013> (requested by mxinden), deployed 8d 3h ago: kusama fullnode on kusama-fullnode-uw1-0;\n"+
+			"Paused runners without a \"run\" file: westend-sentry-uw1-0",
+		texts[3],
+	)
+
+	server.Close()
+	require.Error(t, notifier.SendCleanupNotification(deployment))
+}
+
+func TestClient_error_hides_webhook_secret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	webhookURL, err := url.Parse(server.URL + "/services/T000/B000/XXXX")
+	require.NoError(t, err)
+
+	err = NewClient(webhookURL).Send(notify.Event{Name: "cleanup", Deployment: &burnin.Deployment{}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "403 Forbidden")
+	require.NotContains(t, err.Error(), "XXXX")
+}
@@ -0,0 +1,273 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package systemd deploys nodes without Ansible. It expects the node to run as systemd unit, whose ExecStart runs the
+// binary behind a symlink and ends with $NODE_CUSTOM_OPTIONS.
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	burnin "gitlab.example.com/burn-in-tests/backend"
+)
+
+// Options of the driver. Empty fields use the defaults below, which match the hosts set up by the Ansible playbooks.
+type Options struct {
+	User       string        // logs in over SSH, e.g. "gitlab"
+	SSHArgs    []string      // passed to ssh before the host, e.g. "-i", "/path/to/key"
+	Sudo       string        // prefix of commands which need root, "-" for none
+	Unit       string        // e.g. "polkadot.service"
+	BinaryDir  string        // keeps the downloaded binaries, named after their SHA-256
+	BinaryLink string        // symlink to the current binary, used by the unit
+	DropInDir  string        // of the unit, receives "burnin.conf"
+	ChainDB    string        // directory removed when the chain database is wiped
+	Systemctl  string        // replaced in tests
+	Timeout    time.Duration // of a whole run, zero for no limit
+	Check      bool          // only log the script instead of running it
+}
+
+func (o Options) withDefaults() Options {
+	defaults := Options{
+		User:       "gitlab",
+		Sudo:       "sudo",
+		Unit:       "polkadot.service",
+		BinaryDir:  "/usr/local/lib/burnin",
+		BinaryLink: "/usr/local/bin/polkadot",
+		ChainDB:    "/home/polkadot/.local/share/polkadot/chains",
+		Systemctl:  "systemctl",
+	}
+
+	set := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	set(&o.User, defaults.User)
+	set(&o.Sudo, defaults.Sudo)
+	set(&o.Unit, defaults.Unit)
+	set(&o.BinaryDir, defaults.BinaryDir)
+	set(&o.BinaryLink, defaults.BinaryLink)
+	set(&o.DropInDir, "/etc/systemd/system/"+o.Unit+".d")
+	set(&o.ChainDB, defaults.ChainDB)
+	set(&o.Systemctl, defaults.Systemctl)
+	if o.Sudo == "-" {
+		o.Sudo = ""
+	}
+	return o
+}
+
+// Driver implements burnin.DeploymentDriver with a shell script, which runs over SSH or, for "localhost", directly.
+// It downloads and verifies the binary, points the symlink to it, writes a drop-in with the custom options and
+// restarts the unit. The playbook name of the run is only logged.
+type Driver struct {
+	opts Options
+	ssh  string // "ssh", replaced in tests
+}
+
+func NewDriver(opts Options) *Driver {
+	return &Driver{opts: opts.withDefaults(), ssh: "ssh"}
+}
+
+func (d *Driver) RunPlaybook(run burnin.PlaybookRun) error {
+	if run.NodeBinary == nil {
+		return fmt.Errorf("the systemd driver needs a node binary to deploy on %s", run.NodePublicName)
+	}
+
+	script, err := d.script(run, false)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if d.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.opts.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if run.RunOn == "localhost" {
+		cmd = exec.CommandContext(ctx, "sh", "-s")
+	} else {
+		args := append(append([]string{}, d.opts.SSHArgs...), "-l", d.opts.User, run.RunOn, "sh -s")
+		cmd = exec.CommandContext(ctx, d.ssh, args...)
+	}
+
+	log.Printf(
+		"deploying %s on %s with unit %s (for playbook %s)\n",
+		burnin.RedactURL(run.NodeBinary), run.RunOn, d.opts.Unit, run.Playbook,
+	)
+	if d.opts.Check {
+		// the binary URL and the extra vars may carry credentials
+		redacted, _ := d.script(run, true)
+		log.Printf("check mode, not running:\n%s", redacted)
+		return nil
+	}
+
+	var out bytes.Buffer
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+
+	prefix := run.NodePublicName + " |"
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		log.Println(prefix, line)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return &burnin.PlaybookTimeoutError{Playbook: run.Playbook, Timeout: d.opts.Timeout, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("deploying on %s failed: %w\n%s", run.RunOn, err, lastLines(out.String(), 20))
+	}
+	return nil
+}
+
+// script fails on the first failing command, before the unit is touched if the binary is broken. A redacted
+// script hides the credentials of the binary URL and the values of the unit environment, it is only for logging.
+func (d *Driver) script(run burnin.PlaybookRun, redact bool) (string, error) {
+	o := d.opts
+	sudo := ""
+	if o.Sudo != "" {
+		sudo = o.Sudo + " "
+	}
+
+	dropIn, err := dropIn(run)
+	if err != nil {
+		return "", err
+	}
+	binary := run.NodeBinary.String()
+	if redact {
+		dropIn = redactDropIn(dropIn)
+		binary = burnin.RedactURL(run.NodeBinary)
+	}
+
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+
+	line("set -eu")
+	line("%smkdir -p %s %s", sudo, quote(o.BinaryDir), quote(path.Dir(o.BinaryLink)))
+	line("tmp=$(%smktemp %s)", sudo, quote(o.BinaryDir+"/.download.XXXXXX"))
+	line(`trap '%srm -f "$tmp"' EXIT`, sudo)
+	line("%scurl -fsSL --retry 3 -o \"$tmp\" %s", sudo, quote(binary))
+	line(`sum=$(sha256sum "$tmp" | cut -d ' ' -f 1)`)
+	if run.BinaryChecksum != "" {
+		line(`if [ "$sum" != %s ]; then`, quote(strings.ToLower(run.BinaryChecksum)))
+		line(`  echo "checksum mismatch: expected %s, got $sum" >&2`, run.BinaryChecksum)
+		line("  exit 1")
+		line("fi")
+	}
+	line(`binary=%s"/polkadot-$sum"`, quote(o.BinaryDir))
+	line(`%smv -f "$tmp" "$binary"`, sudo)
+	line(`%schmod 0755 "$binary"`, sudo)
+	line(`"$binary" --version`)
+	line(`%sln -sfn "$binary" %s`, sudo, quote(o.BinaryLink+".new"))
+	line(`%smv -Tf %s %s`, sudo, quote(o.BinaryLink+".new"), quote(o.BinaryLink))
+	line("%smkdir -p %s", sudo, quote(o.DropInDir))
+	line("%stee %s >/dev/null <<'BURNIN_EOF'", sudo, quote(o.DropInDir+"/burnin.conf"))
+	b.WriteString(dropIn)
+	line("BURNIN_EOF")
+	line("%s%s daemon-reload", sudo, o.Systemctl)
+	if run.WipeChainDB {
+		line("%s%s stop %s", sudo, o.Systemctl, quote(o.Unit))
+		line("%srm -rf %s", sudo, quote(o.ChainDB))
+	}
+	line("%s%s restart %s", sudo, o.Systemctl, quote(o.Unit))
+	line("%s%s is-active %s", sudo, o.Systemctl, quote(o.Unit))
+
+	return b.String(), nil
+}
+
+// dropIn sets the custom options and extra vars as environment of the unit, e.g. extra var "node_pruning" as
+// NODE_PRUNING.
+func dropIn(run burnin.PlaybookRun) (string, error) {
+	env := map[string]string{
+		"NODE_PUBLIC_NAME":    run.NodePublicName,
+		"NODE_CUSTOM_OPTIONS": strings.Join(run.CustomOptions, " "),
+	}
+
+	for name, value := range run.ExtraVars {
+		key := strings.ToUpper(name)
+		if _, ok := env[key]; ok {
+			return "", fmt.Errorf("extra var '%s' must not replace %s", name, key)
+		}
+
+		if values, ok := value.([]interface{}); ok {
+			parts := make([]string, len(values))
+			for i, v := range values {
+				parts[i] = fmt.Sprint(v)
+			}
+			env[key] = strings.Join(parts, " ")
+		} else {
+			env[key] = fmt.Sprint(value)
+		}
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("# written by the burn-in backend, do not edit\n[Service]\n")
+	for _, k := range keys {
+		if strings.ContainsAny(env[k], "\n\"\\") {
+			return "", fmt.Errorf("value of %s must not contain newlines, quotes or backslashes", k)
+		}
+		// systemd would replace specifiers like "%h"
+		fmt.Fprintf(&b, "Environment=\"%s=%s\"\n", k, strings.ReplaceAll(env[k], "%", "%%"))
+	}
+	return b.String(), nil
+}
+
+// redactDropIn hides every value of the unit environment but the public name of the node.
+func redactDropIn(dropIn string) string {
+	const prefix = "Environment=\""
+	lines := strings.Split(dropIn, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, prefix) || strings.HasPrefix(line, prefix+"NODE_PUBLIC_NAME=") {
+			continue
+		}
+		key := strings.SplitN(strings.TrimPrefix(line, prefix), "=", 2)[0]
+		lines[i] = prefix + key + "=<redacted>\""
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quote makes s a single shell word.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+)
+
+// SignatureHeader contains the hex encoded HMAC-SHA256 of the request body, if the client has a secret.
+const SignatureHeader = "X-Burnin-Signature"
+
+// Client posts notifications as JSON encoded notify.Event to an arbitrary URL.
+type Client struct {
+	url        *url.URL
+	secret     string
+	httpClient *http.Client
+}
+
+// NewClient returns a client which signs its requests if secret is not empty.
+func NewClient(u *url.URL, secret string) *Client {
+	return &Client{
+		url:    u,
+		secret: secret,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (c *Client) Send(event notify.Event) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, c.url.String(), bytes.NewBuffer(buf))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if c.secret != "" {
+		request.Header.Set(SignatureHeader, "sha256="+Sign(c.secret, buf))
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf(`HTTP request to webhook failed.
+Request: %s %s
+Body: %s
+
+Response: %s
+Body: %s`, request.Method, request.URL.String(), buf, response.Status, string(responseBody))
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, so receivers can verify the SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2022 Parity Technologies (UK) Ltd.
+// SPDX-License-Identifier: GPL-3.0-or-later WITH Classpath-exception-2.0
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	burnin "gitlab.example.com/burn-in-tests/backend"
+	"gitlab.example.com/burn-in-tests/backend/internal/notify"
+)
+
+func TestClient(t *testing.T) {
+	var events []notify.Event
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "sha256="+Sign("s3cr3t", body), r.Header.Get(SignatureHeader))
+
+		var event notify.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		events = append(events, event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	jobURL, err := url.Parse("https://gitlab.example.com/burn-in-tests/deployments/-/jobs/23")
+	require.NoError(t, err)
+	notifier := notify.Events{Sender: NewClient(u, "s3cr3t"), JobURL: jobURL}
+
+	deployment := burnin.Deployment{PullRequest: "https://github.com/paritytech/polkadot/pull/2013", DeployedOn: "kusama-fullnode-uw1-0"}
+	require.NoError(t, notifier.SendUpdateNotification(deployment))
+	require.NoError(t, notifier.SendErrorNotification(&burnin.JobError{Deployment: deployment, Err: errors.New("playbook failed")}))
+
+	require.Len(t, events, 2)
+	require.Equal(t, "update", events[0].Name)
+	require.Equal(t, "kusama-fullnode-uw1-0", events[0].Deployment.DeployedOn)
+	require.Equal(t, jobURL.String(), events[0].JobURL)
+	require.Equal(t, "error", events[1].Name)
+	require.Equal(t, "playbook failed", events[1].Error)
+	require.Equal(t, "kusama-fullnode-uw1-0", events[1].Deployment.DeployedOn)
+
+	status = http.StatusBadGateway
+	require.Error(t, notifier.SendCleanupNotification(deployment))
+}